@@ -0,0 +1,5 @@
+module github.com/darlinggo/site
+
+go 1.24
+
+require github.com/yuin/goldmark v1.7.13
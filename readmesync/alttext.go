@@ -0,0 +1,56 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	altTextWarn = "warn"
+	altTextFail = "fail"
+)
+
+var (
+	markdownImage = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]*)`)
+	htmlImage     = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+	htmlAlt       = regexp.MustCompile(`(?i)\balt\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+	htmlSrc       = regexp.MustCompile(`(?i)\bsrc\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+)
+
+// altTextError is what a repo fails with when ALT_TEXT_POLICY is fail and
+// its README has images with no alt text.
+type altTextError struct {
+	repo    string
+	missing []string
+}
+
+func (e altTextError) Error() string {
+	return e.repo + ": " + strconv.Itoa(len(e.missing)) + " images have no alt text"
+}
+
+// missingAltText returns the sources of the images in readme, outside of
+// code fences, that have no alt text.
+func missingAltText(readme []byte) []string {
+	var missing []string
+	eachLine(readme, func(line string) string {
+		for _, m := range markdownImage.FindAllStringSubmatch(line, -1) {
+			if strings.TrimSpace(m[1]) == "" {
+				missing = append(missing, m[2])
+			}
+		}
+		for _, img := range htmlImage.FindAllString(line, -1) {
+			alt := htmlAlt.FindStringSubmatch(img)
+			if alt != nil && strings.TrimSpace(strings.Trim(alt[1], `"'`)) != "" {
+				continue
+			}
+			src := ""
+			if m := htmlSrc.FindStringSubmatch(img); m != nil {
+				src = strings.Trim(m[1], `"'`)
+			}
+			missing = append(missing, src)
+		}
+		return line
+	})
+	return missing
+}
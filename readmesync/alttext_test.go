@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMissingAltText(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		readme string
+		want   []string
+	}{
+		{name: "markdown with alt text", readme: "![The logo](logo.png)\n"},
+		{name: "markdown without alt text", readme: "![](logo.png)\n", want: []string{"logo.png"}},
+		{name: "markdown with blank alt text", readme: "![ ](logo.png \"Logo\")\n", want: []string{"logo.png"}},
+		{name: "html with alt text", readme: `<img src="logo.png" alt="The logo">` + "\n"},
+		{name: "html without alt text", readme: `<img src="logo.png" width="100">` + "\n", want: []string{"logo.png"}},
+		{name: "html with empty alt text", readme: `<IMG alt='' src=logo.png>` + "\n", want: []string{"logo.png"}},
+		{name: "code fences", readme: "```md\n![](logo.png)\n```\n"},
+		{name: "several", readme: "![](a.png) ![b](b.png) <img src=\"c.png\">\n", want: []string{"a.png", "c.png"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := missingAltText([]byte(tc.readme)); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAltTextPolicy(t *testing.T) {
+	const (
+		withAlt    = "# hash\n\n![The logo](logo.png)\n"
+		withoutAlt = "# api\n\n![](logo.png)\n"
+	)
+	for _, tc := range []struct {
+		name       string
+		policy     string
+		event      string
+		readme     string
+		want       int
+		wantPages  map[string]bool
+		wantFailed []string
+		wantLogs   bool
+	}{
+		{name: "off", event: "push", readme: withoutAlt, want: http.StatusOK, wantPages: map[string]bool{"api": true}},
+		{name: "warn", policy: altTextWarn, event: "push", readme: withoutAlt, want: http.StatusOK, wantPages: map[string]bool{"api": true}, wantLogs: true},
+		{name: "fail with alt text", policy: altTextFail, event: "push", readme: withAlt, want: http.StatusOK, wantPages: map[string]bool{"api": true}},
+		{name: "fail without alt text", policy: altTextFail, event: "push", readme: withoutAlt, want: http.StatusUnprocessableEntity, wantPages: map[string]bool{"api": false}, wantLogs: true},
+		{
+			name:       "fail in a sync-all",
+			policy:     altTextFail,
+			event:      "sync-all",
+			readme:     withoutAlt,
+			want:       http.StatusOK,
+			wantPages:  map[string]bool{"api": false, "hash": true},
+			wantFailed: []string{"api"},
+			wantLogs:   true,
+		},
+		{
+			name:      "warn in a sync-all",
+			policy:    altTextWarn,
+			event:     "sync-all",
+			readme:    withoutAlt,
+			want:      http.StatusOK,
+			wantPages: map[string]bool{"api": true, "hash": true},
+			wantLogs:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", tc.readme)
+			gh.setReadme("darlinggo/hash", withAlt)
+			e := newTestEnv(t, gh.URL)
+			e.altTextPolicy = tc.policy
+			body := pushBody("api", "refs/heads/master")
+			if tc.event == "sync-all" {
+				body = syncAllBody("api", "hash")
+			}
+			logs := captureLog(t)
+			w := serve(e, newDelivery(tc.event, body))
+			if w.Code != tc.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			for repo, want := range tc.wantPages {
+				_, err := os.Stat(filepath.Join(e.hugoSource, e.dir, repo+".md"))
+				if got := err == nil; got != want {
+					t.Errorf("%s page exists: got %v, want %v", repo, got, want)
+				}
+			}
+			if tc.event == "sync-all" {
+				var s syncSummary
+				if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+					t.Fatalf("%v: %s", err, w.Body.String())
+				}
+				var failed []string
+				for repo, msg := range s.Errors {
+					failed = append(failed, repo)
+					if !strings.Contains(msg, "1 images have no alt text") {
+						t.Errorf("%s: got error %q", repo, msg)
+					}
+				}
+				if !reflect.DeepEqual(failed, tc.wantFailed) {
+					t.Errorf("got failures %q, want %q", failed, tc.wantFailed)
+				}
+				if runs := hugoRuns(t, e.hugoCmd); len(runs) != 1 {
+					t.Errorf("hugo ran %d times, want once", len(runs))
+				}
+			}
+			if got := strings.Contains(logs.String(), "api: image logo.png has no alt text"); got != tc.wantLogs {
+				t.Errorf("logged the missing alt text: got %v, want %v\n%s", got, tc.wantLogs, logs)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditEntry records a single webhook delivery for the audit log.
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Delivery string    `json:"delivery,omitempty"`
+	Event    string    `json:"event"`
+	Sender   string    `json:"sender,omitempty"`
+	Remote   string    `json:"remote"`
+	Repos    []string  `json:"repos"`
+	Status   int       `json:"status"`
+}
+
+// auditLog appends an entry to AUDIT_LOG, as JSON lines, for every
+// delivery, separately from the operational log.
+type auditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{f: f}, nil
+}
+
+func (a *auditLog) record(entry auditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.f.Write(append(b, '\n'))
+	return err
+}
+
+// deliveryID returns the ID the forge gave the delivery in r, if any.
+func deliveryID(r *http.Request) string {
+	for _, header := range []string{"X-GitHub-Delivery", "X-Gitea-Delivery", "X-Gitlab-Event-UUID"} {
+		if id := r.Header.Get(header); id != "" {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readAudit returns the entries appended to the audit log at path.
+func readAudit(t *testing.T, path string) []auditEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("audit line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		req   *http.Request
+		want  auditEntry
+		wantN int
+	}{
+		{
+			name: "push",
+			req:  newDelivery("push", pushBody("api", "refs/heads/master")),
+			want: auditEntry{Delivery: "push-1", Event: "push", Sender: "octocat", Repos: []string{"darlinggo/api"}, Status: http.StatusOK},
+		},
+		{
+			name: "sync-all",
+			req:  newDelivery("sync-all", syncAllBody("api", "hash")),
+			want: auditEntry{Delivery: "push-1", Event: "sync-all", Repos: []string{"api", "hash"}, Status: http.StatusOK},
+		},
+		{
+			name: "unhandled event",
+			req:  newDelivery("star", "{}"),
+			want: auditEntry{Delivery: "push-1", Event: "star", Status: http.StatusBadRequest},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			gh.setReadme("darlinggo/hash", "# hash\n")
+			e := newTestEnv(t, gh.URL)
+			path := filepath.Join(t.TempDir(), "audit.log")
+			var err error
+			if e.audit, err = openAuditLog(path); err != nil {
+				t.Fatal(err)
+			}
+			tc.req.Header.Set("X-GitHub-Delivery", "push-1")
+			serve(e, tc.req)
+			entries := readAudit(t, path)
+			if len(entries) != 1 {
+				t.Fatalf("got %d audit entries, want 1", len(entries))
+			}
+			got := entries[0]
+			if got.Time.IsZero() || got.Remote == "" {
+				t.Errorf("audit entry %+v is missing its time or remote address", got)
+			}
+			got.Time, got.Remote = tc.want.Time, tc.want.Remote
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got audit entry %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuditLogAppends(t *testing.T) {
+	e := newTestEnv(t, "http://github.invalid")
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := ioutil.WriteFile(path, []byte(`{"event": "ping"}`+"\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if e.audit, err = openAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		serve(e, newDelivery("ping", "{}"))
+	}
+	entries := readAudit(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("got %d audit entries, want the existing one and 2 more", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Event != "ping" {
+			t.Errorf("got event %q, want ping", entry.Event)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// authorized reports whether r carries ADMIN_TOKEN as a bearer token.
+func (e env) authorized(r *http.Request) bool {
+	if e.adminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+e.adminToken)) == 1
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type batchResult struct {
+	report buildReport
+	err    error
+}
+
+type batch struct {
+	event string
+	repos map[string]struct{}
+	bytes int64
+	done  chan struct{}
+	res   batchResult
+	// then is called with res by flush, once the batch has been built,
+	// for each caller that didn't wait for it.
+	then []func(buildReport, error)
+}
+
+// batcher collects the builds requested within window of each other into
+// a single build, and hands its result to every caller that asked for it.
+type batcher struct {
+	window time.Duration
+	run    func(event string, repos []string, bytes int64) (buildReport, error)
+
+	mu      sync.Mutex
+	pending *batch
+	// unbuilt counts the batches that haven't been built yet.
+	unbuilt sync.WaitGroup
+}
+
+// build adds repos to the pending batch and waits for it to be built.
+func (b *batcher) build(event string, repos []string, bytes int64) (buildReport, error) {
+	p := b.join(event, repos, bytes, nil)
+	<-p.done
+	return p.res.report, p.res.err
+}
+
+// add adds repos to the pending batch without waiting for it to be built,
+// and has then called with the result once it is.
+func (b *batcher) add(event string, repos []string, bytes int64, then func(buildReport, error)) {
+	b.join(event, repos, bytes, then)
+}
+
+func (b *batcher) join(event string, repos []string, bytes int64, then func(buildReport, error)) *batch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p := b.pending
+	if p == nil {
+		p = &batch{event: event, repos: map[string]struct{}{}, done: make(chan struct{})}
+		b.pending = p
+		b.unbuilt.Add(1)
+		time.AfterFunc(b.window, func() { b.flush(p) })
+	}
+	for _, repo := range repos {
+		p.repos[repo] = struct{}{}
+	}
+	p.bytes += bytes
+	if then != nil {
+		p.then = append(p.then, then)
+	}
+	return p
+}
+
+func (b *batcher) flush(p *batch) {
+	defer b.unbuilt.Done()
+	b.mu.Lock()
+	if b.pending == p {
+		b.pending = nil
+	}
+	repos := make([]string, 0, len(p.repos))
+	for repo := range p.repos {
+		repos = append(repos, repo)
+	}
+	then := p.then
+	b.mu.Unlock()
+	sort.Strings(repos)
+	p.res.report, p.res.err = b.run(p.event, repos, p.bytes)
+	close(p.done)
+	for _, f := range then {
+		f(p.res.report, p.res.err)
+	}
+}
+
+// wait blocks until every batch has been built, or ctx is done. A nil
+// batcher has nothing to wait for.
+func (b *batcher) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	return waitGroup(ctx, &b.unbuilt)
+}
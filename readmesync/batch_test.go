@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatcher(t *testing.T) {
+	var mu sync.Mutex
+	var runs [][]string
+	var bytes []int64
+	b := &batcher{window: 100 * time.Millisecond, run: func(event string, repos []string, n int64) (buildReport, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		runs = append(runs, repos)
+		bytes = append(bytes, n)
+		return buildReport{Repos: repos, Bytes: n}, errors.New("build failed")
+	}}
+	var wg sync.WaitGroup
+	reports := make([]buildReport, 3)
+	errs := make([]error, 3)
+	for i, repo := range []string{"site", "api", "hash"} {
+		wg.Add(1)
+		go func(i int, repo string) {
+			defer wg.Done()
+			reports[i], errs[i] = b.build("push", []string{repo}, 10)
+		}(i, repo)
+	}
+	wg.Wait()
+	want := []string{"api", "hash", "site"}
+	if !reflect.DeepEqual(runs, [][]string{want}) || !reflect.DeepEqual(bytes, []int64{30}) {
+		t.Fatalf("got runs %q with %v bytes, want one of %q with 30", runs, bytes, want)
+	}
+	for i := range reports {
+		if !reflect.DeepEqual(reports[i].Repos, want) || errs[i] == nil || errs[i].Error() != "build failed" {
+			t.Errorf("caller %d: got %q, %v, want the shared result", i, reports[i].Repos, errs[i])
+		}
+	}
+
+	// Builds requested after a batch has been flushed start a new one.
+	if _, err := b.build("push", []string{"api"}, 1); err == nil {
+		t.Error("got no error from the second batch")
+	}
+	if len(runs) != 2 || !reflect.DeepEqual(runs[1], []string{"api"}) {
+		t.Errorf("got runs %q, want a second of just api", runs)
+	}
+}
+
+// TestBatchedDeliveries checks that pushes in quick succession are each
+// answered as soon as they've joined the batch, with or without
+// BUILD_ASYNC, and share one build once the window closes.
+func TestBatchedDeliveries(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		hugo  string
+		async bool
+	}{
+		{name: "successful build"},
+		{name: "failed build", hugo: "exit 1\n"},
+		{name: "async", async: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			repos := []string{"api", "hash", "site"}
+			for _, repo := range repos {
+				gh.setReadme("darlinggo/"+repo, "# "+repo+"\n")
+			}
+			e := newTestEnv(t, gh.URL)
+			e.hugoCmd = fakeHugo(t, tc.hugo)
+			window := 300 * time.Millisecond
+			e.batcher = &batcher{window: window, run: e.buildAndReport}
+			if tc.async {
+				e.worker = newBuildWorker(e.buildAndReport)
+				e.batcher.run = e.worker.build
+			}
+			start := time.Now()
+			for _, repo := range repos {
+				if w := serve(e, newDelivery("push", pushBody(repo, "refs/heads/master"))); w.Code != http.StatusOK {
+					t.Fatalf("%s: got status %d: %s", repo, w.Code, w.Body.String())
+				}
+			}
+			if answered := time.Since(start); answered >= window {
+				t.Errorf("took %s to answer the pushes, want them answered before the %s window closed", answered, window)
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != 0 {
+				t.Errorf("hugo ran %d times before the window closed", len(runs))
+			}
+			if err := e.batcher.wait(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != 1 {
+				t.Errorf("hugo ran %d times, want once for all three", len(runs))
+			}
+			for _, repo := range repos {
+				if page := readPage(t, e, repo); !strings.Contains(page, "# "+repo+"\n") {
+					t.Errorf("%s page is missing its README:\n%s", repo, page)
+				}
+			}
+			e.reports.RLock()
+			defer e.reports.RUnlock()
+			if got := e.reports.last.Repos; !reflect.DeepEqual(got, repos) {
+				t.Errorf("got a report for %q, want %q", got, repos)
+			}
+			if failed := e.reports.last.Error != ""; failed != (tc.hugo != "") {
+				t.Errorf("got report error %q for hugo %q", e.reports.last.Error, tc.hugo)
+			}
+		})
+	}
+}
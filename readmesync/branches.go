@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type cachedBranch struct {
+	name    string
+	fetched time.Time
+}
+
+// branchCache remembers each repo's default branch for ttl, so a push
+// doesn't cost an extra API call every time.
+type branchCache struct {
+	sync.Mutex
+	ttl      time.Duration
+	branches map[string]cachedBranch
+}
+
+func newBranchCache(ttl time.Duration) *branchCache {
+	return &branchCache{ttl: ttl, branches: map[string]cachedBranch{}}
+}
+
+func (e env) defaultBranch(ctx context.Context, repo string) (string, error) {
+	if !e.branchFromAPI {
+		return "master", nil
+	}
+	c := e.branches
+	c.Lock()
+	cached, ok := c.branches[repo]
+	c.Unlock()
+	if ok && time.Since(cached.fetched) < c.ttl {
+		return cached.name, nil
+	}
+	info, err := e.pullRepo(ctx, e.fullName(repo))
+	if err != nil {
+		return "", err
+	}
+	c.Lock()
+	c.branches[repo] = cachedBranch{name: info.DefaultBranch, fetched: time.Now()}
+	c.Unlock()
+	return info.DefaultBranch, nil
+}
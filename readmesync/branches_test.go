@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultBranchFromAPI(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		fromAPI  bool
+		repo     string
+		ref      string
+		wantSync bool
+	}{
+		{name: "main default", fromAPI: true, repo: "api", ref: "refs/heads/main", wantSync: true},
+		{name: "push to master of a main repo", fromAPI: true, repo: "api", ref: "refs/heads/master"},
+		{name: "trunk default", fromAPI: true, repo: "hash", ref: "refs/heads/trunk", wantSync: true},
+		{name: "push to main of a trunk repo", fromAPI: true, repo: "hash", ref: "refs/heads/main"},
+		{name: "static master", repo: "api", ref: "refs/heads/master", wantSync: true},
+		{name: "static master ignores the real default", repo: "api", ref: "refs/heads/main"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setRepo("darlinggo/api", `{"default_branch": "main", "size": 1}`)
+			gh.setRepo("darlinggo/hash", `{"default_branch": "trunk", "size": 1}`)
+			gh.setReadme("darlinggo/api", "# api\n")
+			gh.setReadme("darlinggo/hash", "# hash\n")
+			e := newTestEnv(t, gh.URL)
+			e.branchFromAPI = tc.fromAPI
+			if w := serve(e, newDelivery("push", pushBody(tc.repo, tc.ref))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			_, err := os.Stat(filepath.Join(e.hugoSource, e.dir, tc.repo+".md"))
+			if got := err == nil; got != tc.wantSync {
+				t.Errorf("synced: got %v, want %v", got, tc.wantSync)
+			}
+		})
+	}
+}
+
+func TestDefaultBranchCached(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setRepo("darlinggo/api", `{"default_branch": "main", "size": 1}`)
+	gh.setRepo("darlinggo/hash", `{"default_branch": "trunk", "size": 1}`)
+	e := newTestEnv(t, gh.URL)
+	e.branchFromAPI = true
+	for _, ref := range []string{"refs/heads/feature", "refs/heads/fix", "refs/heads/other"} {
+		for _, repo := range []string{"api", "hash"} {
+			if w := serve(e, newDelivery("push", pushBody(repo, ref))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+		}
+	}
+	lookups := map[string]int{}
+	for _, path := range gh.requested() {
+		lookups[path]++
+	}
+	for _, path := range []string{"/repos/darlinggo/api", "/repos/darlinggo/hash"} {
+		if lookups[path] != 1 {
+			t.Errorf("got %d lookups of %s, want 1", lookups[path], path)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (e env) configFor(repo string) string {
+	if config, ok := e.repoConfigs[repo]; ok {
+		return config
+	}
+	return e.hugoConfig
+}
+
+// outputDir returns the absolute path of the directory config's site is
+// built into, if OUTPUT_DIRS_FILE or PUBLISH_DIR says what it is.
+func (e env) outputDir(config string) (string, bool) {
+	dir, ok := e.outputDirs[config]
+	if !ok && config == e.hugoConfig {
+		dir, ok = e.publishDir, true
+	}
+	if !ok {
+		return "", false
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(e.hugoSource, dir)
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return dir, true
+}
+
+// siteKey returns the key builds with config are serialized on: the
+// directory they write to, so that configs sharing one never build at
+// once. Configs whose output dir isn't known are assumed to set their own,
+// and are keyed by their own path instead.
+func (e env) siteKey(config string) string {
+	if dir, ok := e.outputDir(config); ok {
+		return dir
+	}
+	if abs, err := filepath.Abs(filepath.Join(e.hugoSource, config)); err == nil {
+		return abs
+	}
+	return config
+}
+
+func (e env) hugoArgs(config string) []string {
+	if config == "" {
+		return nil
+	}
+	return []string{"--config", config}
+}
+
+type hugoRun struct {
+	exitCode int
+	warnings []string
+	err      error
+}
+
+func hugoWarnings(output []byte) []string {
+	var warnings []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.Contains(line, "WARN") {
+			warnings = append(warnings, strings.TrimSpace(line))
+		}
+	}
+	return warnings
+}
+
+// sectionSegment is the Hugo segment that BUILD_SECTION_ONLY renders.
+const sectionSegment = "readmesync"
+
+// writeSegmentConfig writes a Hugo config file defining sectionSegment as
+// the pages in section, and returns its path.
+func writeSegmentConfig(section string) (string, error) {
+	f, err := ioutil.TempFile("", "readmesync-segment-*.toml")
+	if err != nil {
+		return "", err
+	}
+	path := "/" + filepath.ToSlash(section)
+	_, err = f.WriteString("[segments." + sectionSegment + "]\n" +
+		"[[segments." + sectionSegment + ".includes]]\n" +
+		"path = " + tomlString("{"+path+","+path+"/**}") + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// runHugo builds the site with config. With BUILD_SECTION_ONLY set, only
+// the generated section is rendered, leaving the rest of the site as it
+// was.
+func (e env) runHugo(config string) hugoRun {
+	start := time.Now()
+	args := e.hugoArgs(config)
+	if e.segmentConfig != "" {
+		args = []string{"--config", config + "," + e.segmentConfig, "--renderSegments", sectionSegment}
+	}
+	if dir, ok := e.outputDirs[config]; ok {
+		args = append(args, "--destination", dir)
+	}
+	run := e.hugo(args...)
+	e.metrics.build(time.Since(start), run.err)
+	return run
+}
+
+func (e env) hugo(args ...string) hugoRun {
+	cmd := exec.Command(e.hugoCmd, args...)
+	cmd.Dir = e.hugoSource
+	output, err := cmd.CombinedOutput()
+	run := hugoRun{warnings: hugoWarnings(output), err: err}
+	if err != nil {
+		run.exitCode = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			run.exitCode = exitErr.ExitCode()
+		}
+		log.Println(err)
+		log.Println(string(output))
+		return run
+	}
+	log.Println(string(output))
+	return run
+}
+
+// sitesFor returns the distinct configs used by repos, sorted, or just
+// HUGO_CONFIG if there are no repos.
+func (e env) sitesFor(repos []string) []string {
+	configs := map[string]struct{}{}
+	for _, repo := range repos {
+		configs[e.configFor(repo)] = struct{}{}
+	}
+	if len(configs) == 0 {
+		configs[e.hugoConfig] = struct{}{}
+	}
+	sorted := make([]string, 0, len(configs))
+	for config := range configs {
+		sorted = append(sorted, config)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// build runs Hugo once for every distinct config used by repos. Each
+// config is its own site: builds into the same output dir never overlap,
+// but with PARALLEL_BUILDS set, sites with different output dirs build
+// concurrently. The outcome is recorded in report.
+func (e env) build(repos []string, report *buildReport) error {
+	start := time.Now()
+	sorted := e.sitesFor(repos)
+	var runs []hugoRun
+	if !e.parallelBuilds {
+		for _, config := range sorted {
+			run := e.buildSite(config)
+			runs = append(runs, run)
+			if run.err != nil {
+				break
+			}
+		}
+	} else {
+		ch := make(chan hugoRun, len(sorted))
+		for _, config := range sorted {
+			go func(config string) {
+				ch <- e.buildSite(config)
+			}(config)
+		}
+		for range sorted {
+			runs = append(runs, <-ch)
+		}
+	}
+	report.DurationMS = int64(time.Since(start) / time.Millisecond)
+	var err error
+	for _, run := range runs {
+		report.Warnings = append(report.Warnings, run.warnings...)
+		if run.err != nil && err == nil {
+			err = run.err
+			report.ExitCode = run.exitCode
+		}
+	}
+	return err
+}
+
+func (e env) buildSite(config string) hugoRun {
+	unlock := e.siteLocks.lock(e.siteKey(config))
+	defer unlock()
+	return e.runHugo(config)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBuildConfigPerRepo(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		repo string
+		want []string
+	}{
+		{name: "mapped repo", repo: "api", want: []string{"--config", "api.toml"}},
+		{name: "another mapped repo", repo: "hash", want: []string{"--config", "hash.toml"}},
+		{name: "unmapped repo", repo: "site", want: []string{"--config", "config.toml"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/"+tc.repo, "# "+tc.repo+"\n")
+			e := newTestEnv(t, gh.URL)
+			e.hugoConfig = "config.toml"
+			e.repoConfigs = map[string]string{"api": "api.toml", "hash": "hash.toml"}
+			w := serve(e, newDelivery("push", pushBody(tc.repo, "refs/heads/master")))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			runs := hugoRuns(t, e.hugoCmd)
+			if len(runs) != 1 || !reflect.DeepEqual(runs[0], tc.want) {
+				t.Errorf("got hugo runs %q, want one with %q", runs, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildConfigsForSyncAll(t *testing.T) {
+	gh := newFakeGitHub(t)
+	for _, repo := range []string{"api", "hash", "site"} {
+		gh.setReadme("darlinggo/"+repo, "# "+repo+"\n")
+	}
+	e := newTestEnv(t, gh.URL)
+	e.hugoConfig = "config.toml"
+	e.repoConfigs = map[string]string{"api": "api.toml", "hash": "api.toml"}
+	w := serve(e, newDelivery("sync-all", syncAllBody("api", "hash", "site")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	want := [][]string{{"--config", "api.toml"}, {"--config", "config.toml"}}
+	if runs := hugoRuns(t, e.hugoCmd); !reflect.DeepEqual(runs, want) {
+		t.Errorf("got hugo runs %q, want %q", runs, want)
+	}
+}
+
+// concurrentHugo is a fakeHugo script that takes a while to run, and
+// records how many runs were in progress half way through each.
+const concurrentHugo = `touch "$0.running.$$"
+sleep 0.3
+ls "$0".running.* | wc -l >> "$0.concurrency"
+rm "$0.running.$$"
+`
+
+// maxConcurrency returns the most runs of a concurrentHugo cmd that were
+// seen in progress at once.
+func maxConcurrency(t *testing.T, cmd string) int {
+	t.Helper()
+	b, err := ioutil.ReadFile(cmd + ".concurrency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	most := 0
+	for _, line := range strings.Fields(string(b)) {
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > most {
+			most = n
+		}
+	}
+	return most
+}
+
+func TestParallelBuilds(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		parallel   bool
+		outputDirs map[string]string
+		want       int
+	}{
+		{
+			name:       "different sites",
+			parallel:   true,
+			outputDirs: map[string]string{"api.toml": "public/api", "hash.toml": "public/hash"},
+			want:       2,
+		},
+		{
+			name:       "different sites, parallel builds off",
+			outputDirs: map[string]string{"api.toml": "public/api", "hash.toml": "public/hash"},
+			want:       1,
+		},
+		{
+			name:       "configs sharing a site",
+			parallel:   true,
+			outputDirs: map[string]string{"api.toml": "public", "hash.toml": "public/../public"},
+			want:       1,
+		},
+		{
+			name:       "configs sharing a site, one by its absolute path",
+			parallel:   true,
+			outputDirs: map[string]string{"api.toml": "public", "hash.toml": "$SOURCE/public"},
+			want:       1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t, "http://github.invalid")
+			e.hugoCmd = fakeHugo(t, concurrentHugo)
+			e.parallelBuilds = tc.parallel
+			e.repoConfigs = map[string]string{"api": "api.toml", "hash": "hash.toml"}
+			e.outputDirs = map[string]string{}
+			for config, dir := range tc.outputDirs {
+				e.outputDirs[config] = strings.Replace(dir, "$SOURCE", e.hugoSource, 1)
+			}
+			if err := e.build([]string{"api", "hash"}, &buildReport{}); err != nil {
+				t.Fatal(err)
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != 2 {
+				t.Fatalf("hugo ran %d times, want 2", len(runs))
+			}
+			if got := maxConcurrency(t, e.hugoCmd); got != tc.want {
+				t.Errorf("got %d builds at once, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSiteKey(t *testing.T) {
+	e := newTestEnv(t, "http://github.invalid")
+	e.hugoConfig = "config.toml"
+	e.outputDirs = map[string]string{
+		"api.toml":   "public/api",
+		"hash.toml":  "public/./api",
+		"abs.toml":   filepath.Join(e.hugoSource, "public", "api"),
+		"other.toml": "public/other",
+	}
+	for _, tc := range []struct {
+		config string
+		want   string
+	}{
+		{config: "api.toml", want: filepath.Join(e.hugoSource, "public", "api")},
+		{config: "hash.toml", want: filepath.Join(e.hugoSource, "public", "api")},
+		{config: "abs.toml", want: filepath.Join(e.hugoSource, "public", "api")},
+		{config: "other.toml", want: filepath.Join(e.hugoSource, "public", "other")},
+		{config: "config.toml", want: e.publishDir},
+		{config: "unknown.toml", want: filepath.Join(e.hugoSource, "unknown.toml")},
+	} {
+		t.Run(tc.config, func(t *testing.T) {
+			if got := e.siteKey(tc.config); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSameSiteBuildsSerialized(t *testing.T) {
+	e := newTestEnv(t, "http://github.invalid")
+	e.hugoCmd = fakeHugo(t, concurrentHugo)
+	e.parallelBuilds = true
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := e.build([]string{"api"}, &buildReport{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := maxConcurrency(t, e.hugoCmd); got != 1 {
+		t.Errorf("got %d builds of one site at once, want 1", got)
+	}
+}
+
+func TestContentSection(t *testing.T) {
+	for _, tc := range []struct {
+		dir  string
+		want string
+	}{
+		{dir: "content/project", want: "project"},
+		{dir: "/content/project/", want: "project"},
+		{dir: "content/docs/projects", want: "docs/projects"},
+		{dir: "content", want: "."},
+		{dir: "static/project", want: ""},
+	} {
+		t.Run(tc.dir, func(t *testing.T) {
+			e := env{dir: tc.dir}
+			if got := e.contentSection(); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSectionOnlyBuilds(t *testing.T) {
+	segment, err := writeSegmentConfig("project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(segment) })
+	b, err := ioutil.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[segments.readmesync]\n[[segments.readmesync.includes]]\npath = \"{/project,/project/**}\"\n"
+	if string(b) != want {
+		t.Errorf("got segment config\n%s\nwant\n%s", b, want)
+	}
+	for _, tc := range []struct {
+		name       string
+		segment    string
+		outputDirs map[string]string
+		want       []string
+	}{
+		{name: "whole site", want: []string{"--config", "config.toml"}},
+		{name: "section only", segment: segment, want: []string{"--config", "config.toml," + segment, "--renderSegments", "readmesync"}},
+		{
+			name:       "section only, with an output dir",
+			segment:    segment,
+			outputDirs: map[string]string{"config.toml": "public/site"},
+			want:       []string{"--config", "config.toml," + segment, "--renderSegments", "readmesync", "--destination", "public/site"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t, "http://github.invalid")
+			e.hugoConfig, e.segmentConfig, e.outputDirs = "config.toml", tc.segment, tc.outputDirs
+			if err := e.build([]string{"api"}, &buildReport{}); err != nil {
+				t.Fatal(err)
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != 1 || !reflect.DeepEqual(runs[0], tc.want) {
+				t.Errorf("got hugo runs %q, want one with %q", runs, tc.want)
+			}
+		})
+	}
+}
+
+// TestHugoArgv checks the exact arguments Hugo is run with: each flag and
+// value is its own argument, with no shell quoting, even for paths with
+// spaces in.
+func TestHugoArgv(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		config     string
+		outputDirs map[string]string
+		want       []string
+	}{
+		{name: "no config", want: []string{}},
+		{name: "config", config: "config.toml", want: []string{"--config", "config.toml"}},
+		{name: "config with spaces", config: "my site/config.toml", want: []string{"--config", "my site/config.toml"}},
+		{
+			name:       "output dir",
+			config:     "config.toml",
+			outputDirs: map[string]string{"config.toml": "public dir/site"},
+			want:       []string{"--config", "config.toml", "--destination", "public dir/site"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t, "http://github.invalid")
+			e.hugoConfig, e.outputDirs = tc.config, tc.outputDirs
+			if err := e.build([]string{"api"}, &buildReport{}); err != nil {
+				t.Fatal(err)
+			}
+			runs := hugoRuns(t, e.hugoCmd)
+			if len(runs) != 1 {
+				t.Fatalf("hugo ran %d times, want once", len(runs))
+			}
+			if len(runs[0]) != len(tc.want) || (len(tc.want) > 0 && !reflect.DeepEqual(runs[0], tc.want)) {
+				t.Errorf("got argv %q, want %q", runs[0], tc.want)
+			}
+			for _, arg := range runs[0] {
+				if strings.ContainsAny(arg, `"'`) {
+					t.Errorf("argument %q has quotes in", arg)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type cacheEntry struct {
+	ETag    string    `json:"etag,omitempty"`
+	Body    []byte    `json:"body,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+	Touched time.Time `json:"touched"`
+}
+
+// store persists conditional-request state so unchanged READMEs can be
+// served from a 304 instead of being downloaded again.
+type store interface {
+	get(key string) (cacheEntry, bool, error)
+	set(key string, entry cacheEntry) error
+	// sweep evicts entries last touched before cutoff, returning how
+	// many were evicted.
+	sweep(cutoff time.Time) (int, error)
+}
+
+type fileStore struct {
+	dir string
+}
+
+func (f fileStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (f fileStore) get(key string) (cacheEntry, bool, error) {
+	var entry cacheEntry
+	b, err := ioutil.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return entry, false, nil
+	}
+	if err != nil {
+		return entry, false, err
+	}
+	err = json.Unmarshal(b, &entry)
+	if err != nil {
+		return entry, false, err
+	}
+	return entry, true, nil
+}
+
+func (f fileStore) set(key string, entry cacheEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(f.dir, ".cache-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(key))
+}
+
+func (f fileStore) sweep(cutoff time.Time) (int, error) {
+	files, err := filepath.Glob(filepath.Join(f.dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	evicted := 0
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return evicted, err
+		}
+		var entry cacheEntry
+		if json.Unmarshal(b, &entry) == nil && !entry.Touched.Before(cutoff) {
+			continue
+		}
+		err = os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return evicted, err
+		}
+		evicted++
+	}
+	return evicted, nil
+}
+
+// warmCache fetches the README of every repo already synced, so their
+// ETags and content are cached before the first webhook arrives.
+func (e env) warmCache() {
+	repos := e.active.list()
+	start := time.Now()
+	readmes, _ := e.syncAll(context.Background(), repos)
+	warmed := len(readmes)
+	log.Println("cache: warmed " + strconv.Itoa(warmed) + "/" + strconv.Itoa(len(repos)) + " READMEs in " + time.Since(start).String())
+}
+
+// sweepCache evicts stale entries from e.cache now, and then again every
+// interval.
+func (e env) sweepCache(interval time.Duration) {
+	for {
+		evicted, err := e.cache.sweep(time.Now().Add(-e.cacheMaxAge))
+		if err != nil {
+			log.Println("cache:", err)
+		} else if evicted > 0 {
+			log.Println("cache: evicted " + strconv.Itoa(evicted) + " stale entries")
+		}
+		time.Sleep(interval)
+	}
+}
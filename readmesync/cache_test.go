@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a Redis server that understands AUTH, SELECT, GET and SET,
+// recording every command it's sent.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu       sync.Mutex
+	data     map[string]string
+	commands [][]string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{ln: ln, password: password, data: map[string]string{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	authed := r.password == ""
+	for {
+		args, err := readCommand(br)
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		r.commands = append(r.commands, args)
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			authed = args[1] == r.password
+			reply = "+OK\r\n"
+			if !authed {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case "SELECT":
+			reply = "+OK\r\n"
+		case "GET":
+			v, ok := r.data[args[1]]
+			reply = "$-1\r\n"
+			if ok {
+				reply = "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+			}
+		case "SET":
+			r.data[args[1]] = args[2]
+			reply = "+OK\r\n"
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		if !authed && strings.ToUpper(args[0]) != "AUTH" {
+			reply = "-NOAUTH Authentication required\r\n"
+		}
+		r.mu.Unlock()
+		conn.Write([]byte(reply))
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		arg, err := readReply(r)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
+
+func TestStores(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		store func(t *testing.T) store
+	}{
+		{
+			name:  "filesystem",
+			store: func(t *testing.T) store { return fileStore{dir: t.TempDir()} },
+		},
+		{
+			name: "redis",
+			store: func(t *testing.T) store {
+				s, err := newRedisStore("redis://" + newFakeRedis(t, "").ln.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+		},
+		{
+			name: "redis with auth and a database",
+			store: func(t *testing.T) store {
+				s, err := newRedisStore("redis://:hunter2@" + newFakeRedis(t, "hunter2").ln.Addr().String() + "/2")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := tc.store(t)
+			_, ok, err := s.get("darlinggo/api")
+			if err != nil || ok {
+				t.Fatalf("get of a missing key: got ok %v, err %v", ok, err)
+			}
+			entry := cacheEntry{ETag: `"abc"`, Body: []byte("# api\n"), Hash: "123", Touched: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+			if err := s.set("darlinggo/api", entry); err != nil {
+				t.Fatal(err)
+			}
+			got, ok, err := s.get("darlinggo/api")
+			if err != nil || !ok {
+				t.Fatalf("get: got ok %v, err %v", ok, err)
+			}
+			if !reflect.DeepEqual(got, entry) {
+				t.Errorf("got %+v, want %+v", got, entry)
+			}
+			entry.ETag = `"def"`
+			if err := s.set("darlinggo/api", entry); err != nil {
+				t.Fatal(err)
+			}
+			if got, _, _ := s.get("darlinggo/api"); got.ETag != entry.ETag {
+				t.Errorf("got ETag %s after overwriting, want %s", got.ETag, entry.ETag)
+			}
+			if _, ok, _ := s.get("darlinggo/hash"); ok {
+				t.Error("got an entry for a key that was never set")
+			}
+		})
+	}
+}
+
+func TestFileStoreSweep(t *testing.T) {
+	s := fileStore{dir: t.TempDir()}
+	now := time.Now()
+	for key, touched := range map[string]time.Time{
+		"old":   now.Add(-2 * time.Hour),
+		"older": now.Add(-48 * time.Hour),
+		"new":   now,
+	} {
+		if err := s.set(key, cacheEntry{ETag: key, Touched: touched}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	evicted, err := s.sweep(now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evicted != 2 {
+		t.Errorf("evicted %d entries, want 2", evicted)
+	}
+	for key, want := range map[string]bool{"old": false, "older": false, "new": true} {
+		if _, ok, _ := s.get(key); ok != want {
+			t.Errorf("%s cached: got %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestRedisStoreCommands(t *testing.T) {
+	r := newFakeRedis(t, "hunter2")
+	s, err := newRedisStore("redis://:hunter2@" + r.ln.Addr().String() + "/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ttl = time.Minute
+	if err := s.set("darlinggo/api", cacheEntry{ETag: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.commands) != 3 {
+		t.Fatalf("got commands %q, want AUTH, SELECT and SET", r.commands)
+	}
+	if want := []string{"AUTH", "hunter2"}; !reflect.DeepEqual(r.commands[0], want) {
+		t.Errorf("got %q, want %q", r.commands[0], want)
+	}
+	if want := []string{"SELECT", "3"}; !reflect.DeepEqual(r.commands[1], want) {
+		t.Errorf("got %q, want %q", r.commands[1], want)
+	}
+	set := r.commands[2]
+	if len(set) != 5 || set[0] != "SET" || set[1] != "readmesync:darlinggo/api" || set[3] != "PX" || set[4] != "60000" {
+		t.Errorf("got %q, want a SET of readmesync:darlinggo/api with PX 60000", set)
+	}
+}
+
+func TestNewRedisStore(t *testing.T) {
+	for _, tc := range []struct {
+		url          string
+		wantAddr     string
+		wantPassword string
+		wantDB       int
+		wantErr      bool
+	}{
+		{url: "redis://cache", wantAddr: "cache:6379"},
+		{url: "redis://cache:6380", wantAddr: "cache:6380"},
+		{url: "redis://:secret@cache/4", wantAddr: "cache:6379", wantPassword: "secret", wantDB: 4},
+		{url: "rediss://cache", wantErr: true},
+		{url: "redis://cache/db", wantErr: true},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			s, err := newRedisStore(tc.url)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.addr != tc.wantAddr || s.password != tc.wantPassword || s.db != tc.wantDB {
+				t.Errorf("got %s %q %d, want %s %q %d", s.addr, s.password, s.db, tc.wantAddr, tc.wantPassword, tc.wantDB)
+			}
+		})
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	gh.setReadme("darlinggo/hash", "# hash\n")
+	e := newTestEnv(t, gh.URL)
+	dir := t.TempDir()
+	e.cache = fileStore{dir: dir}
+	e.cacheMaxAge = time.Hour
+	if w := serve(e, newDelivery("sync-all", syncAllBody("api", "hash"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	// Age every entry past the window, as if the last sync were two hours
+	// ago, then fetch api again so its entry is touched by the 304.
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entry cacheEntry
+		if err := json.Unmarshal(b, &entry); err != nil {
+			t.Fatal(err)
+		}
+		entry.Touched = time.Now().Add(-2 * time.Hour)
+		b, _ = json.Marshal(entry)
+		if err := ioutil.WriteFile(file, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	// The first sweep runs as soon as sweeping starts. What's left should
+	// be api's README and the manifest of what was written for it.
+	go e.sweepCache(time.Hour)
+	var left []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		left, _ = filepath.Glob(filepath.Join(dir, "*.json"))
+		if len(left) <= 2 {
+			break
+		}
+	}
+	if len(left) != 2 {
+		t.Fatalf("got %d cache entries after sweeping, want 2", len(left))
+	}
+	for _, key := range []string{manifestKey("hash"), manifestKey("api")} {
+		_, ok, err := e.cache.get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := key == manifestKey("api"); ok != want {
+			t.Errorf("%s cached: got %v, want %v", key, ok, want)
+		}
+	}
+	for _, file := range left {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entry cacheEntry
+		if err := json.Unmarshal(b, &entry); err != nil {
+			t.Fatal(err)
+		}
+		if string(entry.Body) == "# hash\n" {
+			t.Error("kept hash's README")
+		}
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	gh.setReadme("darlinggo/hash", "# hash\n")
+	var conditional int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			atomic.AddInt32(&conditional, 1)
+		}
+		gh.serve(w, r)
+	}))
+	defer api.Close()
+	e := newTestEnv(t, api.URL)
+	writeTestPage(t, e, "api")
+	writeTestPage(t, e, "hash")
+	active, err := newRepoSet(filepath.Join(e.hugoSource, e.dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.active = active
+	e.cache = fileStore{dir: t.TempDir()}
+	logs := captureLog(t)
+	e.warmCache()
+	if !strings.Contains(logs.String(), "cache: warmed 2/2 READMEs") {
+		t.Errorf("logs don't report warming both READMEs:\n%s", logs)
+	}
+	for _, repo := range []string{"api", "hash"} {
+		entry, ok, err := e.cache.get("application/vnd.github.v3.raw /repos/darlinggo/" + repo + "/readme")
+		if err != nil || !ok {
+			t.Fatalf("%s: not cached: %v", repo, err)
+		}
+		if string(entry.Body) != "# "+repo+"\n" || entry.ETag == "" {
+			t.Errorf("%s: got entry %+v", repo, entry)
+		}
+	}
+	// The warmed ETag makes the first push a conditional request.
+	if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	if page := readPage(t, e, "api"); !strings.Contains(page, "+++\n\n# api\n") {
+		t.Errorf("got page\n%s", page)
+	}
+	if n := atomic.LoadInt32(&conditional); n != 1 {
+		t.Errorf("got %d conditional requests, want 1", n)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+func contentHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func manifestKey(repo string) string {
+	return "manifest:" + repo
+}
+
+// unchanged reports whether readme matches the content last written for
+// repo, according to the manifest in the cache.
+func (e env) unchanged(repo string, readme []byte) bool {
+	if e.cache == nil {
+		return false
+	}
+	entry, ok, err := e.cache.get(manifestKey(repo))
+	if err != nil {
+		log.Println("cache:", err)
+		return false
+	}
+	return ok && entry.Hash == contentHash(readme)
+}
+
+func (e env) recordContent(repo string, readme []byte) {
+	if e.cache == nil {
+		return
+	}
+	err := e.cache.set(manifestKey(repo), cacheEntry{Hash: contentHash(readme), Touched: time.Now()})
+	if err != nil {
+		log.Println("cache:", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForceRebuild(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		request    func(force bool) *http.Request
+		force      bool
+		wantBuilds int
+	}{
+		{
+			name:       "sync-all unchanged",
+			request:    func(force bool) *http.Request { return forced(newDelivery("sync-all", syncAllBody("api")), force) },
+			wantBuilds: 1,
+		},
+		{
+			name:       "sync-all forced",
+			request:    func(force bool) *http.Request { return forced(newDelivery("sync-all", syncAllBody("api")), force) },
+			force:      true,
+			wantBuilds: 2,
+		},
+		{
+			name: "push unchanged",
+			request: func(force bool) *http.Request {
+				return forced(newDelivery("push", pushBody("api", "refs/heads/master")), force)
+			},
+			wantBuilds: 1,
+		},
+		{
+			name: "push forced",
+			request: func(force bool) *http.Request {
+				return forced(newDelivery("push", pushBody("api", "refs/heads/master")), force)
+			},
+			force:      true,
+			wantBuilds: 2,
+		},
+		{
+			name:       "pattern sync unchanged",
+			request:    func(force bool) *http.Request { return forced(syncRequest("ap*"), force) },
+			wantBuilds: 1,
+		},
+		{
+			name:       "pattern sync forced",
+			request:    func(force bool) *http.Request { return forced(syncRequest("ap*"), force) },
+			force:      true,
+			wantBuilds: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.skipUnchanged = true
+			e.cache = fileStore{dir: t.TempDir()}
+			e.adminToken = "admin"
+			mux := http.NewServeMux()
+			mux.Handle("/hook", e)
+			mux.HandleFunc("/sync", e.syncPattern)
+			for i, force := range []bool{false, tc.force} {
+				if w := serve(mux, tc.request(force)); w.Code != http.StatusOK {
+					t.Fatalf("request %d: got status %d: %s", i+1, w.Code, w.Body.String())
+				}
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != tc.wantBuilds {
+				t.Errorf("hugo ran %d times, want %d", len(runs), tc.wantBuilds)
+			}
+		})
+	}
+}
+
+func TestSkipUnchangedChangedContent(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	e := newTestEnv(t, gh.URL)
+	e.skipUnchanged = true
+	e.cache = fileStore{dir: t.TempDir()}
+	for _, readme := range []string{"# api\n", "# api, updated\n"} {
+		gh.setReadme("darlinggo/api", readme)
+		if w := serve(e, newDelivery("sync-all", syncAllBody("api"))); w.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if runs := hugoRuns(t, e.hugoCmd); len(runs) != 2 {
+		t.Errorf("hugo ran %d times, want 2", len(runs))
+	}
+}
+
+// syncRequest returns an authorized request to /sync the repos matching
+// pattern.
+func syncRequest(pattern string) *http.Request {
+	req := httptest.NewRequest("POST", "/sync?pattern="+pattern, nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	return req
+}
+
+// forced adds force=1 to req's query if force is set.
+func forced(req *http.Request, force bool) *http.Request {
+	if force {
+		q := req.URL.Query()
+		q.Set("force", "1")
+		req.URL.RawQuery = q.Encode()
+	}
+	return req
+}
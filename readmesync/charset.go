@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+const (
+	charsetMetaStrip = "strip"
+	charsetMetaKeep  = "keep"
+)
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// charsetMeta matches HTML declarations of a page's charset, in either
+// the <meta charset> or the http-equiv form.
+var charsetMeta = regexp.MustCompile(`(?i)<meta\s+(?:charset\s*=\s*["']?[\w-]+["']?|http-equiv\s*=\s*["']?content-type["']?\s+content\s*=\s*["'][^"']*charset[^"']*["'])\s*/?>[ \t]*`)
+
+// stripBOM removes a leading UTF-8 byte order mark from readme; the page
+// it's embedded in isn't at the start of a file, so it would end up as a
+// stray character in the output.
+func stripBOM(readme []byte) []byte {
+	return bytes.TrimPrefix(readme, utf8BOM)
+}
+
+// stripCharsetMeta removes HTML charset declarations from readme. READMEs
+// are always UTF-8 by the time they're embedded, and the page's own
+// template declares that, so these would at best be redundant.
+func stripCharsetMeta(readme []byte) []byte {
+	return eachLine(readme, func(line string) string {
+		return charsetMeta.ReplaceAllString(line, "")
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStripBOM(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		readme string
+		want   string
+	}{
+		{name: "BOM", readme: "\xef\xbb\xbf# api\n", want: "# api\n"},
+		{name: "no BOM", readme: "# api\n", want: "# api\n"},
+		{name: "BOM later on", readme: "# api\n\xef\xbb\xbf\n", want: "# api\n\xef\xbb\xbf\n"},
+		{name: "empty", readme: "", want: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(stripBOM([]byte(tc.readme))); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStripCharsetMeta(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		readme string
+		want   string
+	}{
+		{name: "meta charset", readme: "<meta charset=\"utf-8\">\n# api\n", want: "\n# api\n"},
+		{name: "unquoted, self-closing", readme: "<META CHARSET=utf-8 />\n", want: "\n"},
+		{name: "http-equiv", readme: "<meta http-equiv=\"Content-Type\" content=\"text/html; charset=utf-8\">\n", want: "\n"},
+		{name: "other meta tags", readme: "<meta name=\"description\" content=\"api\">\n", want: "<meta name=\"description\" content=\"api\">\n"},
+		{name: "in the middle of a line", readme: "before <meta charset=\"utf-8\"> after\n", want: "before after\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(stripCharsetMeta([]byte(tc.readme))); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCharsetPages(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		readme   string
+		mode     string
+		wantMeta bool
+	}{
+		{name: "BOM", readme: "\xef\xbb\xbf# api\n\nAn API.\n", mode: charsetMetaStrip},
+		{name: "no BOM", readme: "# api\n\nAn API.\n", mode: charsetMetaStrip},
+		{name: "charset meta stripped", readme: "<meta charset=\"utf-8\">\n# api\n", mode: charsetMetaStrip},
+		{name: "charset meta kept", readme: "\xef\xbb\xbf<meta charset=\"utf-8\">\n# api\n", mode: charsetMetaKeep, wantMeta: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", tc.readme)
+			e := newTestEnv(t, gh.URL)
+			e.charsetMeta = tc.mode
+			if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			page := readPage(t, e, "api")
+			if strings.Contains(page, "\xef\xbb\xbf") {
+				t.Errorf("page has a BOM in:\n%q", page)
+			}
+			if !strings.Contains(page, "# api\n") {
+				t.Errorf("page is missing the README:\n%s", page)
+			}
+			if got := strings.Contains(page, "<meta charset"); got != tc.wantMeta {
+				t.Errorf("page has the charset meta: got %v, want %v:\n%s", got, tc.wantMeta, page)
+			}
+		})
+	}
+}
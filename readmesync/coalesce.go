@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+type delivery struct {
+	done chan struct{}
+	code int
+	at   time.Time
+}
+
+// coalescer makes repeated deliveries of the same push idempotent. A
+// delivery that arrives while an identical one is in flight waits for it
+// and gets its status; one that arrives within ttl of a successful
+// delivery is a no-op. Failed deliveries are forgotten, so they can be
+// retried.
+type coalescer struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	deliveries map[string]*delivery
+}
+
+func newCoalescer(ttl time.Duration) *coalescer {
+	return &coalescer{ttl: ttl, deliveries: map[string]*delivery{}}
+}
+
+// join returns a finish func if the caller should handle the delivery
+// for key, and must call finish with the status it responded with.
+// Otherwise, it returns the status of the delivery the caller joined.
+func (c *coalescer) join(key string) (finish func(code int), code int) {
+	c.mu.Lock()
+	d, ok := c.deliveries[key]
+	if ok && d.done != nil {
+		// finish clears d.done, so the channel has to be read while
+		// the lock is held.
+		done := d.done
+		c.mu.Unlock()
+		<-done
+		return nil, d.code
+	}
+	if ok && time.Since(d.at) < c.ttl {
+		c.mu.Unlock()
+		return nil, d.code
+	}
+	for k, old := range c.deliveries {
+		if old.done == nil && time.Since(old.at) >= c.ttl {
+			delete(c.deliveries, k)
+		}
+	}
+	d = &delivery{done: make(chan struct{})}
+	c.deliveries[key] = d
+	c.mu.Unlock()
+	return func(code int) {
+		c.mu.Lock()
+		done := d.done
+		d.code, d.at, d.done = code, time.Now(), nil
+		if code >= 300 {
+			delete(c.deliveries, key)
+		}
+		c.mu.Unlock()
+		close(done)
+	}, 0
+}
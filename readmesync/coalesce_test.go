@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalesceDeliveries(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		hugo       string
+		afters     []string
+		concurrent bool
+		wantCodes  []int
+		wantBuilds int
+	}{
+		{
+			name:       "concurrent retries share a build",
+			hugo:       "sleep 0.3\n",
+			afters:     []string{"abc", "abc", "abc"},
+			concurrent: true,
+			wantCodes:  []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			wantBuilds: 1,
+		},
+		{
+			name:       "retry after success",
+			afters:     []string{"abc", "abc"},
+			wantCodes:  []int{http.StatusOK, http.StatusOK},
+			wantBuilds: 1,
+		},
+		{
+			name:       "different pushes",
+			afters:     []string{"abc", "def"},
+			wantCodes:  []int{http.StatusOK, http.StatusOK},
+			wantBuilds: 2,
+		},
+		{
+			name:       "retry after failure",
+			hugo:       "exit 1\n",
+			afters:     []string{"abc", "abc"},
+			wantCodes:  []int{http.StatusInternalServerError, http.StatusInternalServerError},
+			wantBuilds: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.hugoCmd = fakeHugo(t, tc.hugo)
+			e.deliveries = newCoalescer(time.Minute)
+			codes := make([]int, len(tc.afters))
+			var wg sync.WaitGroup
+			for i, after := range tc.afters {
+				body := strings.Replace(pushBody("api", "refs/heads/master"), "0123456789abcdef", after, 1)
+				wg.Add(1)
+				push := func(i int) {
+					defer wg.Done()
+					codes[i] = serve(e, newDelivery("push", body)).Code
+				}
+				if tc.concurrent {
+					go push(i)
+				} else {
+					push(i)
+				}
+			}
+			wg.Wait()
+			for i, code := range codes {
+				if code != tc.wantCodes[i] {
+					t.Errorf("delivery %d: got status %d, want %d", i, code, tc.wantCodes[i])
+				}
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != tc.wantBuilds {
+				t.Errorf("hugo ran %d times, want %d", len(runs), tc.wantBuilds)
+			}
+		})
+	}
+}
+
+func TestCoalesceAsyncDeliveries(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		hugo       string
+		wantBuilds int
+	}{
+		{name: "retry after success", wantBuilds: 1},
+		{name: "retry after failure", hugo: "exit 1\n", wantBuilds: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.hugoCmd = fakeHugo(t, tc.hugo)
+			e.deliveries = newCoalescer(time.Minute)
+			e.worker = newBuildWorker(e.buildAndReport)
+			for i := 0; i < 2; i++ {
+				serve(e, newDelivery("push", pushBody("api", "refs/heads/master")))
+				if err := e.worker.wait(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != tc.wantBuilds {
+				t.Errorf("hugo ran %d times, want %d", len(runs), tc.wantBuilds)
+			}
+		})
+	}
+}
+
+func TestCoalescerExpiry(t *testing.T) {
+	c := newCoalescer(time.Millisecond)
+	finish, _ := c.join("darlinggo/api@abc")
+	finish(http.StatusOK)
+	time.Sleep(5 * time.Millisecond)
+	finish, _ = c.join("darlinggo/api@abc")
+	if finish == nil {
+		t.Fatal("delivery was still coalesced after the TTL")
+	}
+	finish(http.StatusOK)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+type commit struct {
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+}
+
+// isReadme reports whether file is somewhere GitHub looks for a repo's
+// README: the root of the repo, .github, or docs.
+func isReadme(file string) bool {
+	switch path.Dir(file) {
+	case ".", ".github", "docs":
+		return strings.HasPrefix(strings.ToLower(path.Base(file)), "readme")
+	}
+	return false
+}
+
+// touchesReadme reports whether any of commits added, changed, or removed
+// a README where GitHub would find it. Pushes that don't list their commits,
+// like force pushes to an existing commit, are assumed to.
+func touchesReadme(commits []commit) bool {
+	if len(commits) == 0 {
+		return true
+	}
+	for _, c := range commits {
+		for _, files := range [][]string{c.Added, c.Modified, c.Removed} {
+			for _, file := range files {
+				if isReadme(file) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
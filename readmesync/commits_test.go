@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTouchesReadme(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		commits []commit
+		want    bool
+	}{
+		{name: "no commits listed", want: true},
+		{name: "README modified", commits: []commit{{Modified: []string{"main.go", "README.md"}}}, want: true},
+		{name: "README added", commits: []commit{{Added: []string{"readme.rst"}}}, want: true},
+		{name: "README removed", commits: []commit{{Removed: []string{"README"}}}, want: true},
+		{name: "README in a later commit", commits: []commit{{Modified: []string{"main.go"}}, {Modified: []string{"README.md"}}}, want: true},
+		{name: "README in docs", commits: []commit{{Modified: []string{"docs/README.md"}}}, want: true},
+		{name: "README in .github", commits: []commit{{Modified: []string{".github/README.md"}}}, want: true},
+		{name: "other files", commits: []commit{{Modified: []string{"main.go"}, Added: []string{"go.sum"}}}},
+		{name: "README in another directory", commits: []commit{{Modified: []string{"cmd/tool/README.md"}}}},
+		{name: "README nested in docs", commits: []commit{{Modified: []string{"docs/api/README.md"}}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := touchesReadme(tc.commits); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadmeChangesOnly(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		modified []string
+		force    bool
+		wantSync bool
+	}{
+		{name: "push touching the README", modified: []string{"README.md"}, wantSync: true},
+		{name: "push not touching the README", modified: []string{"main.go"}},
+		{name: "forced push not touching the README", modified: []string{"main.go"}, force: true, wantSync: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.readmeChangesOnly = true
+			var payload map[string]interface{}
+			json.Unmarshal([]byte(pushBody("api", "refs/heads/master")), &payload)
+			payload["commits"] = []map[string]interface{}{{"modified": tc.modified}}
+			body, _ := json.Marshal(payload)
+			req := newDelivery("push", string(body))
+			if tc.force {
+				req.URL.RawQuery = "force=1"
+			}
+			if w := serve(e, req); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			_, err := os.Stat(filepath.Join(e.hugoSource, e.dir, "api.md"))
+			if got := err == nil; got != tc.wantSync {
+				t.Errorf("synced: got %v, want %v", got, tc.wantSync)
+			}
+			if runs := len(hugoRuns(t, e.hugoCmd)); (runs == 1) != tc.wantSync {
+				t.Errorf("hugo ran %d times", runs)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"sort"
+)
+
+const (
+	dedupeSkip = "skip"
+	dedupeLink = "link"
+)
+
+// dedupe finds READMEs in readmes with identical content. The first repo
+// by name keeps its README; in skip mode the others are dropped, and in
+// link mode their content is replaced with a link to the first repo's page.
+func dedupe(readmes map[string][]byte, mode string) {
+	names := make([]string, 0, len(readmes))
+	for name := range readmes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonical := map[string]string{}
+	for _, name := range names {
+		hash := contentHash(readmes[name])
+		first, ok := canonical[hash]
+		if !ok {
+			canonical[hash] = name
+			continue
+		}
+		switch mode {
+		case dedupeSkip:
+			log.Println(name + ": README identical to " + first + "'s, skipping")
+			delete(readmes, name)
+		case dedupeLink:
+			log.Println(name + ": README identical to " + first + "'s, linking")
+			readmes[name] = []byte("This project shares its README with [" + first + "](/" + first + ").\n")
+		}
+	}
+}
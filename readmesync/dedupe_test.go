@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDedupe(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		mode      string
+		wantPages map[string]string
+	}{
+		{
+			name:      "off",
+			wantPages: map[string]string{"api": "# template\n", "hash": "# template\n", "site": "# site\n"},
+		},
+		{
+			name:      "skip",
+			mode:      dedupeSkip,
+			wantPages: map[string]string{"api": "# template\n", "site": "# site\n"},
+		},
+		{
+			name: "link",
+			mode: dedupeLink,
+			wantPages: map[string]string{
+				"api":  "# template\n",
+				"hash": "This project shares its README with [api](/api).\n",
+				"site": "# site\n",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/hash", "# template\n")
+			gh.setReadme("darlinggo/api", "# template\n")
+			gh.setReadme("darlinggo/site", "# site\n")
+			e := newTestEnv(t, gh.URL)
+			e.dedupe = tc.mode
+			if w := serve(e, newDelivery("sync-all", syncAllBody("hash", "api", "site"))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			for _, repo := range []string{"api", "hash", "site"} {
+				want, ok := tc.wantPages[repo]
+				if !ok {
+					if _, err := os.Stat(filepath.Join(e.hugoSource, e.dir, repo+".md")); err == nil {
+						t.Errorf("%s: got a page, want none", repo)
+					}
+					continue
+				}
+				if page := readPage(t, e, repo); !strings.Contains(page, "+++\n\n"+want) {
+					t.Errorf("%s: got page\n%s\nwant one with the README\n%s", repo, page, want)
+				}
+			}
+		})
+	}
+}
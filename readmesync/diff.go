@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// maxDiffCells caps the size of the table diffLines compares lines with,
+// so two long, very different pages can't take unbounded memory. Past it,
+// the lines that differ are shown as removed and added wholesale.
+const maxDiffCells = 1 << 22
+
+// diffLines returns a line diff turning a into b, with removed lines
+// prefixed by "-" and added lines by "+". Unchanged lines are left out,
+// so identical inputs give an empty diff.
+func diffLines(a, b []string) string {
+	// Lines the two start and end with are the same either way, and
+	// usually most of a page, so they're left out of the comparison.
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	var out strings.Builder
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, line := range a {
+			out.WriteString("-" + line + "\n")
+		}
+		for _, line := range b {
+			out.WriteString("+" + line + "\n")
+		}
+		return out.String()
+	}
+	// lcs[i*width+j] is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	width := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+			} else if down, right := lcs[(i+1)*width+j], lcs[i*width+j+1]; down >= right {
+				lcs[i*width+j] = down
+			} else {
+				lcs[i*width+j] = right
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[(i+1)*width+j] >= lcs[i*width+j+1]):
+			out.WriteString("-" + a[i] + "\n")
+			i++
+		default:
+			out.WriteString("+" + b[j] + "\n")
+			j++
+		}
+	}
+	return out.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffPages returns a diff of each page in old and new, by path, that
+// differs between them.
+func diffPages(old, new map[string]string) string {
+	paths := make([]string, 0, len(old)+len(new))
+	for path := range old {
+		paths = append(paths, path)
+	}
+	for path := range new {
+		if _, ok := old[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	var out strings.Builder
+	for _, path := range paths {
+		diff := diffLines(splitLines(old[path]), splitLines(new[path]))
+		if diff != "" {
+			out.WriteString("--- " + path + "\n+++ " + path + "\n" + diff)
+		}
+	}
+	return out.String()
+}
+
+// pagesOnDisk returns the contents of the page or pages written for repo,
+// by their paths relative to the output directory.
+func (e env) pagesOnDisk(repo string) (map[string]string, error) {
+	content := filepath.Join(e.hugoSource, e.dir)
+	paths := []string{filepath.Join(content, repo+".md")}
+	split, err := filepath.Glob(filepath.Join(content, repo, "*.md"))
+	if err != nil {
+		return nil, err
+	}
+	if isSplitPage(filepath.Join(content, repo)) {
+		paths = append(paths, split...)
+	}
+	pages := map[string]string{}
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rel, _ := filepath.Rel(content, path)
+		pages[filepath.ToSlash(rel)] = string(b)
+	}
+	return pages, nil
+}
+
+// renderPages renders data the way writePage would write it for repo,
+// split into parts if SPLIT_README is set, returning the contents of each
+// page by its path relative to the output directory.
+func (e env) renderPages(repo string, data pageData) (map[string]string, error) {
+	pages := map[string]string{}
+	render := func(path string, t *template.Template, data interface{}) error {
+		var b bytes.Buffer
+		err := t.Execute(&b, data)
+		pages[path] = b.String()
+		return err
+	}
+	if e.splitLevel > 0 {
+		intro, parts := splitReadme(data.Readme, e.splitLevel)
+		if len(parts) > 0 {
+			data.Readme = intro
+			err := render(repo+"/_index.md", e.pageTmpl, data)
+			for i, part := range parts {
+				if err != nil {
+					break
+				}
+				err = render(repo+"/"+part.Slug+".md", partTemplate, newPartData(data, i, part))
+			}
+			return pages, err
+		}
+	}
+	return pages, render(repo+".md", e.pageTmpl, data)
+}
+
+// diffRepo serves GET /repos/{repo}/diff, rendering the repo's current
+// README and returning how it differs from the page on disk, without
+// writing anything.
+func (e env) diffRepo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !e.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	repo := strings.TrimPrefix(r.URL.Path, "/repos/")
+	if !strings.HasSuffix(repo, "/diff") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	repo = strings.TrimSuffix(repo, "/diff")
+	if !validRepoRef(repo) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("repo must be a repo name, optionally as owner/repo"))
+		return
+	}
+	// Only repos we sync have a page to compare against.
+	repo, err := e.resolveRepo(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	current, err := e.pagesOnDisk(repo)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	raw, err := e.readme(r.Context(), repo, "")
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	hooked, err := e.runRepoHook(repo, raw)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	data := e.newPageData(repo, e.transform(r.Context(), repo, "", hooked))
+	// Keep the date from the page on disk, so the diff only shows
+	// changes to the content.
+	for _, path := range []string{repo + ".md", repo + "/_index.md"} {
+		if fields, err := parseFrontMatter(filepath.Join(e.hugoSource, e.dir, path)); err == nil {
+			if date, ok := fields["date"].(string); ok {
+				data.Date = date
+			}
+		}
+	}
+	err = e.completePageData(r.Context(), &data, raw)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rendered, err := e.renderPages(repo, data)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(diffPages(current, rendered)))
+}
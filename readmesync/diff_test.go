@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{name: "identical", a: "a\nb\nc", b: "a\nb\nc", want: ""},
+		{name: "changed line", a: "a\nb\nc", b: "a\nB\nc", want: "-b\n+B\n"},
+		{name: "added line", a: "a\nc", b: "a\nb\nc", want: "+b\n"},
+		{name: "removed line", a: "a\nb\nc", b: "a\nc", want: "-b\n"},
+		{name: "from nothing", b: "a\nb", want: "+a\n+b\n"},
+		{name: "to nothing", a: "a\nb", want: "-a\n-b\n"},
+		{name: "moved line", a: "a\nb\nc\nd", b: "b\nc\na\nd", want: "-a\n+a\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := diffLines(splitLines(tc.a), splitLines(tc.b)); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesCapped(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 2100; i++ {
+		a = append(a, "old "+strconv.Itoa(i))
+		b = append(b, "new "+strconv.Itoa(i))
+	}
+	if (len(a)+1)*(len(b)+1) <= maxDiffCells {
+		t.Fatal("inputs aren't past the cap")
+	}
+	a = append([]string{"same"}, append(a, "same")...)
+	b = append([]string{"same"}, append(b, "same")...)
+	got := diffLines(a, b)
+	if removed := strings.Count("\n"+got, "\n-"); removed != 2100 {
+		t.Errorf("got %d removed lines, want 2100", removed)
+	}
+	if added := strings.Count("\n"+got, "\n+"); added != 2100 {
+		t.Errorf("got %d added lines, want 2100", added)
+	}
+	if strings.Contains(got, "same") {
+		t.Error("shared lines are in the diff")
+	}
+}
+
+func diffRequest(repo string) *http.Request {
+	req := httptest.NewRequest("GET", "/repos/"+repo+"/diff", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	return req
+}
+
+func TestDiffRepo(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		synced    string
+		current   string
+		split     bool
+		hook      string
+		want      []string
+		wantEmpty bool
+	}{
+		{name: "unchanged", synced: "# api\n\nIntro.\n", current: "# api\n\nIntro.\n", wantEmpty: true},
+		{name: "changed", synced: "# api\n\nIntro.\n", current: "# api\n\nNew intro.\n", want: []string{"--- api.md\n+++ api.md\n", "-Intro.\n", "+New intro.\n"}},
+		{name: "never synced", current: "# api\n", want: []string{"--- api.md\n+++ api.md\n", "+# api\n"}},
+		{
+			name:      "split page unchanged",
+			split:     true,
+			synced:    "# api\n\nIntro.\n\n## Install\n\nGo get it.\n\n## Usage\n\nRun it.\n",
+			current:   "# api\n\nIntro.\n\n## Install\n\nGo get it.\n\n## Usage\n\nRun it.\n",
+			wantEmpty: true,
+		},
+		{
+			name:    "split page changed",
+			split:   true,
+			synced:  "# api\n\nIntro.\n\n## Install\n\nGo get it.\n\n## Usage\n\nRun it.\n",
+			current: "# api\n\nIntro.\n\n## Install\n\nGo install it.\n\n## Usage\n\nRun it.\n",
+			want:    []string{"--- api/install.md\n+++ api/install.md\n", "-Go get it.\n", "+Go install it.\n"},
+		},
+		{
+			name:    "section added to a split page",
+			split:   true,
+			synced:  "# api\n\nIntro.\n\n## Install\n\nGo get it.\n",
+			current: "# api\n\nIntro.\n\n## Install\n\nGo get it.\n\n## Usage\n\nRun it.\n",
+			want:    []string{"--- api/usage.md\n+++ api/usage.md\n", "+Run it.\n"},
+		},
+		{name: "repo hook unchanged", hook: "tr a-z A-Z", synced: "# api\n", current: "# api\n", wantEmpty: true},
+		{name: "repo hook changed", hook: "tr a-z A-Z", synced: "# api\n", current: "# api, updated\n", want: []string{"-# API\n", "+# API, UPDATED\n"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			e := newTestEnv(t, gh.URL)
+			e.adminToken = "admin"
+			if tc.split {
+				e.splitLevel = 2
+			}
+			if tc.hook != "" {
+				e.repoHooks = map[string]string{"api": tc.hook}
+			}
+			if tc.synced != "" {
+				gh.setReadme("darlinggo/api", tc.synced)
+				if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+					t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+				}
+			}
+			before, err := e.pagesOnDisk("api")
+			if err != nil {
+				t.Fatal(err)
+			}
+			gh.setReadme("darlinggo/api", tc.current)
+			w := httptest.NewRecorder()
+			e.diffRepo(w, diffRequest("api"))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			diff := w.Body.String()
+			if tc.wantEmpty && diff != "" {
+				t.Errorf("got a diff for unchanged content:\n%s", diff)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(diff, want) {
+					t.Errorf("diff doesn't contain %q:\n%s", want, diff)
+				}
+			}
+			after, err := e.pagesOnDisk("api")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(after) != len(before) {
+				t.Errorf("diff changed the pages on disk from %d to %d", len(before), len(after))
+			}
+			for path, page := range before {
+				if after[path] != page {
+					t.Errorf("diff rewrote %s", path)
+				}
+			}
+			wantRuns := 0
+			if tc.synced != "" {
+				wantRuns = 1
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != wantRuns {
+				t.Errorf("hugo ran %d times, want %d", len(runs), wantRuns)
+			}
+		})
+	}
+}
+
+func TestDiffRepoRequests(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		orgs []string
+		req  func() *http.Request
+		want int
+	}{
+		{name: "unauthorized", req: func() *http.Request { return httptest.NewRequest("GET", "/repos/api/diff", nil) }, want: http.StatusUnauthorized},
+		{name: "wrong method", req: func() *http.Request { r := diffRequest("api"); r.Method = "POST"; return r }, want: http.StatusMethodNotAllowed},
+		{name: "not a diff", req: func() *http.Request { r := diffRequest("api"); r.URL.Path = "/repos/api"; return r }, want: http.StatusNotFound},
+		{name: "traversal", req: func() *http.Request { return diffRequest("..%2f..%2fetc") }, want: http.StatusBadRequest},
+		{name: "invalid name", req: func() *http.Request { return diffRequest("api%3Fx=1") }, want: http.StatusBadRequest},
+		{name: "another org", req: func() *http.Request { return diffRequest("octo/api") }, want: http.StatusNotFound},
+		{name: "bare name with several orgs", orgs: []string{"darlinggo", "acme"}, req: func() *http.Request { return diffRequest("api") }, want: http.StatusNotFound},
+		{name: "owner/repo", req: func() *http.Request { return diffRequest("darlinggo/api") }, want: http.StatusOK},
+		{name: "missing README", req: func() *http.Request { return diffRequest("hash") }, want: http.StatusBadGateway},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.adminToken = "admin"
+			if tc.orgs != nil {
+				e.orgs = tc.orgs
+			}
+			w := httptest.NewRecorder()
+			e.diffRepo(w, tc.req())
+			if w.Code != tc.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			// Repos outside GITHUB_ORGS aren't fetched at all.
+			for _, path := range gh.requested() {
+				if !strings.HasPrefix(path, "/repos/darlinggo/") {
+					t.Errorf("requested %s", path)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	emojiConvert = "convert"
+	emojiStrip   = "strip"
+)
+
+var emojiShortcodePattern = regexp.MustCompile(`:[a-z0-9_+\-]+:`)
+
+var emojiShortcodes = map[string]string{
+	"+1":                       "👍",
+	"-1":                       "👎",
+	"100":                      "💯",
+	"arrow_down":               "⬇️",
+	"arrow_left":               "⬅️",
+	"arrow_right":              "➡️",
+	"arrow_up":                 "⬆️",
+	"art":                      "🎨",
+	"beer":                     "🍺",
+	"bell":                     "🔔",
+	"book":                     "📖",
+	"books":                    "📚",
+	"boom":                     "💥",
+	"bug":                      "🐛",
+	"bulb":                     "💡",
+	"calendar":                 "📆",
+	"chart_with_upwards_trend": "📈",
+	"check":                    "✔️",
+	"clap":                     "👏",
+	"clipboard":                "📋",
+	"cloud":                    "☁️",
+	"coffee":                   "☕",
+	"construction":             "🚧",
+	"cool":                     "🆒",
+	"copyright":                "©️",
+	"dart":                     "🎯",
+	"email":                    "📧",
+	"eyes":                     "👀",
+	"fire":                     "🔥",
+	"gear":                     "⚙️",
+	"gift":                     "🎁",
+	"globe_with_meridians":     "🌐",
+	"hammer":                   "🔨",
+	"heart":                    "❤️",
+	"heavy_check_mark":         "✔️",
+	"heavy_minus_sign":         "➖",
+	"heavy_plus_sign":          "➕",
+	"hourglass":                "⌛",
+	"house":                    "🏠",
+	"information_source":       "ℹ️",
+	"key":                      "🔑",
+	"lock":                     "🔒",
+	"mag":                      "🔍",
+	"memo":                     "📝",
+	"package":                  "📦",
+	"pencil":                   "📝",
+	"pencil2":                  "✏️",
+	"point_right":              "👉",
+	"pushpin":                  "📌",
+	"question":                 "❓",
+	"recycle":                  "♻️",
+	"rocket":                   "🚀",
+	"rotating_light":           "🚨",
+	"scroll":                   "📜",
+	"shield":                   "🛡️",
+	"smile":                    "😄",
+	"smiley":                   "😃",
+	"sparkles":                 "✨",
+	"star":                     "⭐",
+	"tada":                     "🎉",
+	"thumbsdown":               "👎",
+	"thumbsup":                 "👍",
+	"trophy":                   "🏆",
+	"truck":                    "🚚",
+	"warning":                  "⚠️",
+	"wave":                     "👋",
+	"white_check_mark":         "✅",
+	"wink":                     "😉",
+	"wrench":                   "🔧",
+	"x":                        "❌",
+	"zap":                      "⚡",
+}
+
+// replaceEmoji converts or strips the known GitHub emoji shortcodes in
+// line, leaving inline code and unknown shortcodes alone.
+func replaceEmoji(line, mode string) string {
+	parts := strings.Split(line, "`")
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = emojiShortcodePattern.ReplaceAllStringFunc(parts[i], func(code string) string {
+			emoji, ok := emojiShortcodes[strings.Trim(code, ":")]
+			if !ok {
+				return code
+			}
+			if mode == emojiStrip {
+				return ""
+			}
+			return emoji
+		})
+	}
+	return strings.Join(parts, "`")
+}
+
+func transformEmoji(readme []byte, mode string) []byte {
+	return eachLine(readme, func(line string) string {
+		return replaceEmoji(line, mode)
+	})
+}
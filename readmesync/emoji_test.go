@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestTransformEmoji(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		mode   string
+		readme string
+		want   string
+	}{
+		{name: "known shortcodes", mode: emojiConvert, readme: "Ship it :rocket: :tada:\n", want: "Ship it 🚀 🎉\n"},
+		{name: "adjacent shortcodes", mode: emojiConvert, readme: ":+1::-1:\n", want: "👍👎\n"},
+		{name: "unknown shortcodes", mode: emojiConvert, readme: "Not an emoji :not_an_emoji: :rocket:\n", want: "Not an emoji :not_an_emoji: 🚀\n"},
+		{name: "times", mode: emojiConvert, readme: "Runs at 10:30:45.\n", want: "Runs at 10:30:45.\n"},
+		{name: "inline code", mode: emojiConvert, readme: "Type `:rocket:` for :rocket:\n", want: "Type `:rocket:` for 🚀\n"},
+		{name: "code fences", mode: emojiConvert, readme: "```\n:rocket:\n```\n:rocket:\n", want: "```\n:rocket:\n```\n🚀\n"},
+		{name: "strip known", mode: emojiStrip, readme: "Ship it :rocket:\n", want: "Ship it \n"},
+		{name: "strip leaves unknown", mode: emojiStrip, readme: ":not_an_emoji: :tada:\n", want: ":not_an_emoji: \n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(transformEmoji([]byte(tc.readme), tc.mode)); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
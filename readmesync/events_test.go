@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestHandledEvents(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		event         string
+		body          string
+		installations bool
+		want          int
+		wantRuns      int
+	}{
+		{name: "push", event: "push", body: pushBody("api", "refs/heads/master"), want: http.StatusOK, wantRuns: 1},
+		{name: "ping", event: "ping", body: `{"zen": "Design for failure."}`, want: http.StatusOK},
+		{name: "sync-all", event: "sync-all", body: syncAllBody("api"), want: http.StatusOK, wantRuns: 1},
+		{name: "unhandled event", event: "issues", body: `{"action": "opened"}`, want: http.StatusBadRequest},
+		{name: "no event", body: `{}`, want: http.StatusBadRequest},
+		{name: "installation events off", event: "installation", body: `{"action": "created"}`, want: http.StatusBadRequest},
+		{name: "installation events on", event: "installation", body: `{"action": "created"}`, installations: true, want: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.installationEvents = tc.installations
+			w := serve(e, newDelivery(tc.event, tc.body))
+			if w.Code != tc.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != tc.wantRuns {
+				t.Errorf("hugo ran %d times, want %d", len(runs), tc.wantRuns)
+			}
+		})
+	}
+}
+
+// TestSyncAllReachesSync checks a signed sync-all is synced, rather than
+// rejected as an unknown event.
+func TestSyncAllReachesSync(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	gh.setReadme("darlinggo/hash", "# hash\n")
+	e := newTestEnv(t, gh.URL)
+	w := serve(e, newDelivery("sync-all", syncAllBody("api", "hash")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	var s syncSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+	if s.Succeeded != 2 || s.Failed != 0 {
+		t.Errorf("got %+v, want 2 synced and none failed", s)
+	}
+}
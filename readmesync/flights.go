@@ -0,0 +1,40 @@
+package main
+
+import "sync"
+
+type flight struct {
+	done chan struct{}
+	body []byte
+	err  error
+}
+
+// fetchGroup shares README fetches between overlapping sync-all requests:
+// a fetch for a repo that's already being fetched waits for that one
+// instead of starting another.
+type fetchGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFetchGroup() *fetchGroup {
+	return &fetchGroup{flights: map[string]*flight{}}
+}
+
+func (g *fetchGroup) do(key string, fetch func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		<-f.done
+		return f.body, f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	f.body, f.err = fetch()
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+	return f.body, f.err
+}
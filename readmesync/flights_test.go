@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalesceSyncAll(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		coalesce      bool
+		wantHashFetch int
+		wantBuilds    int
+	}{
+		{name: "coalesced", coalesce: true, wantHashFetch: 1, wantBuilds: 1},
+		{name: "not coalesced", wantHashFetch: 2, wantBuilds: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Slow fetches keep the two sync-alls overlapping.
+				if strings.HasSuffix(r.URL.Path, "/readme") {
+					time.Sleep(200 * time.Millisecond)
+				}
+				gh.serve(w, r)
+			})
+			for _, repo := range []string{"api", "hash", "site"} {
+				gh.setReadme("darlinggo/"+repo, "# "+repo+"\n")
+			}
+			e := newTestEnv(t, gh.URL)
+			if tc.coalesce {
+				e.fetches = newFetchGroup()
+				e.syncAllBatcher = &batcher{window: 300 * time.Millisecond, run: e.buildAndReport}
+			}
+			var wg sync.WaitGroup
+			codes := make([]int, 2)
+			for i, repos := range [][]string{{"api", "hash"}, {"hash", "site"}} {
+				wg.Add(1)
+				go func(i int, repos []string) {
+					defer wg.Done()
+					codes[i] = serve(e, newDelivery("sync-all", syncAllBody(repos...))).Code
+				}(i, repos)
+			}
+			wg.Wait()
+			for i, code := range codes {
+				if code != http.StatusOK {
+					t.Errorf("sync-all %d: got status %d", i, code)
+				}
+			}
+			hashFetches := 0
+			for _, path := range gh.requested() {
+				if strings.HasPrefix(path, "/repos/darlinggo/hash/readme") {
+					hashFetches++
+				}
+			}
+			if hashFetches != tc.wantHashFetch {
+				t.Errorf("hash's README fetched %d times, want %d", hashFetches, tc.wantHashFetch)
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != tc.wantBuilds {
+				t.Errorf("hugo ran %d times, want %d", len(runs), tc.wantBuilds)
+			}
+			for _, repo := range []string{"api", "hash", "site"} {
+				if _, err := os.Stat(filepath.Join(e.hugoSource, e.dir, repo+".md")); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchGroup(t *testing.T) {
+	g := newFetchGroup()
+	release := make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	fetch := func() ([]byte, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		return []byte("# api\n"), nil
+	}
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body, err := g.do("api", fetch)
+			if err != nil {
+				t.Error(err)
+			}
+			bodies[i] = string(body)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("fetched %d times, want once", calls)
+	}
+	for i, body := range bodies {
+		if body != "# api\n" {
+			t.Errorf("caller %d got %q", i, body)
+		}
+	}
+	// Once it's done, the next fetch starts afresh.
+	if _, err := g.do("api", func() ([]byte, error) { calls++; return nil, nil }); err != nil || calls != 2 {
+		t.Errorf("got %d fetches after the first finished, want 2", calls)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// errEmptyRepo is returned for a repo with no commits yet, which has no
+// README to sync.
+var errEmptyRepo = errors.New("repo is empty")
+
+type statusError struct {
+	repo   string
+	code   int
+	status string
+}
+
+func (s statusError) Error() string {
+	return s.repo + ": non-200 status: " + s.status
+}
+
+type repository struct {
+	Description   string   `json:"description"`
+	Homepage      string   `json:"homepage"`
+	Language      string   `json:"language"`
+	Topics        []string `json:"topics"`
+	Stars         int      `json:"stargazers_count"`
+	Forks         int      `json:"forks_count"`
+	Fork          bool     `json:"fork"`
+	DefaultBranch string   `json:"default_branch"`
+	Size          int      `json:"size"`
+	Parent        *struct {
+		FullName string `json:"full_name"`
+	} `json:"parent"`
+}
+
+// githubDo sends req, making up to GITHUB_MAX_ATTEMPTS attempts when it
+// fails with a network error, a 5xx, or rate limiting. Retries back off
+// exponentially from GITHUB_RETRY_DELAY, or wait as long as Retry-After
+// asks. Once limit has been used up, requests wait for it to reset rather
+// than failing. Only each attempt is bounded by REQUEST_TIMEOUT, not the
+// waits between them. Retrying stops once req's context is done.
+func (e env) githubDo(req *http.Request, limit *rateLimit) (*http.Response, error) {
+	delay := e.retryDelay
+	for attempt := 1; ; attempt++ {
+		if err := limit.wait(req.Context()); err != nil {
+			return nil, err
+		}
+		resp, err := e.roundTrip(req)
+		if err == nil {
+			limit.update(resp)
+		}
+		if attempt >= e.maxAttempts || (err == nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests) {
+			return resp, err
+		}
+		wait := delay
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && resp.StatusCode == http.StatusTooManyRequests {
+				wait = time.Duration(secs) * time.Second
+			}
+			resp.Body.Close()
+		}
+		log.Println(req.URL.Path + ": " + reason + ", retrying in " + wait.String())
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		delay *= 2
+	}
+}
+
+// roundTrip sends req once, giving up after REQUEST_TIMEOUT. The timeout
+// covers reading the response body too, until it's closed.
+func (e env) roundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), e.requestTimeout)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelOnClose is a response body that cancels its request's context once
+// it's closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (e env) githubGet(ctx context.Context, path, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", e.githubAPI+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	token, limit := e.credentialsFor(path)
+	req.Header.Set("Authorization", "token "+token)
+	key := accept + " " + path
+	var cached cacheEntry
+	var hit bool
+	if e.cache != nil {
+		cached, hit, err = e.cache.get(key)
+		if err != nil {
+			log.Println("cache:", err)
+		}
+		if hit && cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+	}
+	resp, err := e.githubDo(req, limit)
+	if err != nil {
+		if e.allowStale && hit {
+			log.Println(path + ": GitHub unreachable, using stale cached content: " + err.Error())
+			return cached.Body, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && hit {
+		cached.Touched = time.Now()
+		if err := e.cache.set(key, cached); err != nil {
+			log.Println("cache:", err)
+		}
+		return cached.Body, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 500 && e.allowStale && hit {
+		log.Println(path + ": GitHub returned " + resp.Status + ", using stale cached content")
+		return cached.Body, nil
+	}
+	if resp.StatusCode != 200 {
+		return body, statusError{repo: path, code: resp.StatusCode, status: resp.Status}
+	}
+	if etag := resp.Header.Get("ETag"); e.cache != nil && etag != "" {
+		err = e.cache.set(key, cacheEntry{ETag: etag, Body: body, Touched: time.Now()})
+		if err != nil {
+			log.Println("cache:", err)
+		}
+	}
+	return body, nil
+}
+
+func (e env) pullReadme(ctx context.Context, fullName, ref string) ([]byte, error) {
+	path := "/repos/" + fullName + "/readme"
+	if ref != "" {
+		path += "?ref=" + url.QueryEscape(ref)
+	}
+	body, err := e.githubGet(ctx, path, "application/vnd.github.v3.raw")
+	e.metrics.fetch(err)
+	if serr, ok := err.(statusError); ok {
+		serr.repo = fullName
+		return body, serr
+	}
+	if err != nil {
+		return body, err
+	}
+	return body, checkText(fullName, body)
+}
+
+// checkText returns an error unless body, fullName's README, is UTF-8
+// text.
+func checkText(fullName string, body []byte) error {
+	if ctype := http.DetectContentType(body); !strings.HasPrefix(ctype, "text/") || !utf8.Valid(body) {
+		return errors.New(fullName + ": README is not UTF-8 text, detected " + ctype)
+	}
+	return nil
+}
+
+func (e env) pullRepo(ctx context.Context, fullName string) (repository, error) {
+	var repo repository
+	body, err := e.githubGet(ctx, "/repos/"+fullName, "application/vnd.github.v3+json")
+	if err != nil {
+		return repo, err
+	}
+	err = json.Unmarshal(body, &repo)
+	return repo, err
+}
+
+// pullLanguages returns the number of bytes of each language in a repo.
+func (e env) pullLanguages(ctx context.Context, fullName string) (map[string]int, error) {
+	var languages map[string]int
+	body, err := e.githubGet(ctx, "/repos/"+fullName+"/languages", "application/vnd.github.v3+json")
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &languages)
+	return languages, err
+}
+
+// readmeMissing reports whether a README fetch found nothing. GitHub
+// answers 409 Conflict, rather than 404, for repos with no commits.
+func readmeMissing(body []byte, err error) bool {
+	if serr, ok := err.(statusError); ok {
+		return serr.code == http.StatusNotFound || serr.code == http.StatusConflict
+	}
+	return err == nil && len(body) == 0
+}
+
+// readme fetches pkg's README at ref, or at the default branch if ref is
+// empty. With USE_RAW_HOST set, the raw host is tried first.
+func (e env) readme(ctx context.Context, pkg, ref string) ([]byte, error) {
+	defer track(&e.activity.fetches)()
+	if e.useRawHost {
+		if body, ok := e.rawReadme(ctx, pkg, ref); ok {
+			return body, nil
+		}
+	}
+	fullName := e.fullName(pkg)
+	body, err := e.pullReadme(ctx, fullName, ref)
+	if !(e.forkFallback || e.skipEmpty) || !readmeMissing(body, err) {
+		return body, err
+	}
+	repo, rerr := e.pullRepo(ctx, fullName)
+	if rerr != nil {
+		log.Println(rerr)
+		return body, err
+	}
+	if e.skipEmpty && (repo.Size == 0 || repo.DefaultBranch == "") {
+		return nil, errEmptyRepo
+	}
+	if !e.forkFallback || !repo.Fork || repo.Parent == nil {
+		return body, err
+	}
+	log.Println(pkg + ": README missing, falling back to " + repo.Parent.FullName)
+	return e.pullReadme(ctx, repo.Parent.FullName, ref)
+}
+
+type result struct {
+	repo string
+	body []byte
+	err  error
+}
+
+// fetchAll fetches the READMEs for repos concurrently, sending each one,
+// or the error fetching it, on the returned channel as soon as it
+// arrives. Empty repos are left out when SKIP_EMPTY is set. At most
+// SYNC_CONCURRENCY fetches run at once. The channel is closed once every
+// fetch has finished.
+func (e env) fetchAll(ctx context.Context, repos []string) <-chan result {
+	resultChan := make(chan result, len(repos))
+	slots := make(chan struct{}, e.syncConcurrency)
+	var wg sync.WaitGroup
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(r string, wg *sync.WaitGroup, ch chan result) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			var resp []byte
+			var err error
+			if e.fetches != nil {
+				// The fetch is shared with other requests, so it shouldn't
+				// be cancelled just because this one is.
+				resp, err = e.fetches.do(r, func() ([]byte, error) { return e.readme(context.WithoutCancel(ctx), r, "") })
+			} else {
+				resp, err = e.readme(ctx, r, "")
+			}
+			if err == errEmptyRepo {
+				return
+			}
+			if err != nil {
+				log.Println(err)
+			}
+			ch <- result{body: resp, repo: r, err: err}
+		}(repo, &wg, resultChan)
+	}
+	go func(wg *sync.WaitGroup, ch chan result) {
+		wg.Wait()
+		close(ch)
+	}(&wg, resultChan)
+	return resultChan
+}
+
+// syncAll fetches the READMEs for repos, returning the ones that were
+// fetched and the errors for the ones that couldn't be.
+func (e env) syncAll(ctx context.Context, repos []string) (map[string][]byte, map[string]error) {
+	results := map[string][]byte{}
+	failed := map[string]error{}
+	for result := range e.fetchAll(ctx, repos) {
+		if result.err != nil {
+			failed[result.repo] = result.err
+			continue
+		}
+		results[result.repo] = result.body
+	}
+	return results, failed
+}
+
+// resultsOf sends readmes on the returned channel in order of repo name,
+// so they're written in the same order every time.
+func resultsOf(readmes map[string][]byte) <-chan result {
+	repos := make([]string, 0, len(readmes))
+	for repo := range readmes {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	ch := make(chan result, len(readmes))
+	for _, repo := range repos {
+		ch <- result{repo: repo, body: readmes[repo]}
+	}
+	close(ch)
+	return ch
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForkReadmeFallback(t *testing.T) {
+	const forkRepo = `{"fork": true, "default_branch": "master", "size": 1, "parent": {"full_name": "upstream/lib"}}`
+	for _, tc := range []struct {
+		name     string
+		fallback bool
+		readme   *string
+		repo     string
+		want     string
+		wantErr  bool
+	}{
+		{name: "fork with no README", fallback: true, repo: forkRepo, want: "# upstream lib\n"},
+		{name: "fork with an empty README", fallback: true, readme: strPtr(""), repo: forkRepo, want: "# upstream lib\n"},
+		{name: "fork with its own README", fallback: true, readme: strPtr("# fork\n"), repo: forkRepo, want: "# fork\n"},
+		{name: "fallback off", repo: forkRepo, wantErr: true},
+		{name: "not a fork", fallback: true, repo: `{"fork": false, "default_branch": "master", "size": 1}`, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setRepo("darlinggo/lib", tc.repo)
+			if tc.readme != nil {
+				gh.setReadme("darlinggo/lib", *tc.readme)
+			}
+			gh.setReadme("upstream/lib", "# upstream lib\n")
+			e := newTestEnv(t, gh.URL)
+			e.forkFallback = tc.fallback
+			got, err := e.readme(context.Background(), "lib", "")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got README %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("got README %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPipelinedSync(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		pipeline     bool
+		wantOverlaps bool
+	}{
+		{name: "pipelined", pipeline: true, wantOverlaps: true},
+		{name: "not pipelined"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/fast", "# fast\n")
+			gh.setReadme("darlinggo/slow", "# slow\n")
+			e := newTestEnv(t, "")
+			e.pipeline = tc.pipeline
+			// Every page has to be on disk by the time Hugo runs.
+			e.hugoCmd = fakeHugo(t, `ls `+e.dir+`/*.md | wc -l >> "$0.pages"`+"\n")
+			fastPage := filepath.Join(e.hugoSource, e.dir, "fast.md")
+			// The slow README isn't served until the fast one's page has
+			// been written, or it's clear that it won't be until every
+			// README has been fetched.
+			overlapped := make(chan bool, 1)
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/repos/darlinggo/slow/readme" {
+					written := false
+					for deadline := time.Now().Add(500 * time.Millisecond); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+						if _, err := os.Stat(fastPage); err == nil {
+							written = true
+							break
+						}
+					}
+					overlapped <- written
+				}
+				gh.serve(w, r)
+			}))
+			defer api.Close()
+			e.githubAPI = api.URL
+			if w := serve(e, newDelivery("sync-all", syncAllBody("fast", "slow"))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			if got := <-overlapped; got != tc.wantOverlaps {
+				t.Errorf("fast page written while slow README was fetched: got %v, want %v", got, tc.wantOverlaps)
+			}
+			b, err := ioutil.ReadFile(e.hugoCmd + ".pages")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Fields(string(b)); len(got) != 1 || got[0] != "2" {
+				t.Errorf("got Hugo runs seeing %v pages, want one run seeing 2", got)
+			}
+		})
+	}
+}
+
+func TestCheckText(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{name: "markdown", body: "# api\n\nDocs.\n"},
+		{name: "non-ASCII markdown", body: "# café ☕\n"},
+		{name: "HTML", body: "<h1>api</h1>\n"},
+		{name: "empty", body: ""},
+		{name: "PDF", body: "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n", wantErr: true},
+		{name: "PNG", body: "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", wantErr: true},
+		{name: "NUL bytes", body: "# api\x00\x00\x00\n", wantErr: true},
+		{name: "gzip", body: "\x1f\x8b\x08\x00\x00\x00\x00\x00", wantErr: true},
+		{name: "Latin-1", body: "# caf\xe9\n", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkText("darlinggo/api", []byte(tc.body))
+			if got := err != nil; got != tc.wantErr {
+				t.Errorf("got error %v, want one: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestBinaryReadmeRejected(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	gh.setReadme("darlinggo/hash", "# hash\n")
+	e := newTestEnv(t, gh.URL)
+	serve(e, newDelivery("sync-all", syncAllBody("api", "hash")))
+	if _, err := os.Stat(filepath.Join(e.hugoSource, e.dir, "api.md")); err == nil {
+		t.Error("binary README was written")
+	}
+	if page := readPage(t, e, "hash"); !strings.Contains(page, "# hash\n") {
+		t.Errorf("text README wasn't written alongside it:\n%s", page)
+	}
+	if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code == http.StatusOK {
+		t.Errorf("push of a binary README: got status %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(e.hugoSource, e.dir, "api.md")); err == nil {
+		t.Error("binary README was written by a push")
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestEmptyRepos(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		repo      string
+		status    int
+		skipEmpty bool
+		wantSkip  bool
+	}{
+		{name: "no size", repo: `{"default_branch": "master", "size": 0}`, status: http.StatusNotFound, skipEmpty: true, wantSkip: true},
+		{name: "no default branch", repo: `{"size": 0}`, status: http.StatusNotFound, skipEmpty: true, wantSkip: true},
+		{name: "readme conflict", repo: `{"size": 0}`, status: http.StatusConflict, skipEmpty: true, wantSkip: true},
+		{name: "repo without a README", repo: `{"default_branch": "master", "size": 12}`, status: http.StatusNotFound, skipEmpty: true},
+		{name: "skipping off", repo: `{"size": 0}`, status: http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/hash", "# hash\n")
+			gh.setRepo("darlinggo/empty", tc.repo)
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/repos/darlinggo/empty/readme" {
+					w.WriteHeader(tc.status)
+					return
+				}
+				gh.serve(w, r)
+			}))
+			defer api.Close()
+			e := newTestEnv(t, api.URL)
+			e.skipEmpty = tc.skipEmpty
+			logs := captureLog(t)
+
+			w := serve(e, newDelivery("sync-all", syncAllBody("empty", "hash")))
+			var summary struct {
+				Errors map[string]string `json:"errors"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &summary)
+			if _, failed := summary.Errors["empty"]; failed == tc.wantSkip {
+				t.Errorf("sync-all: got status %d with %s, want empty skipped: %v", w.Code, w.Body.String(), tc.wantSkip)
+			}
+			if page := readPage(t, e, "hash"); !strings.Contains(page, "# hash\n") {
+				t.Errorf("hash wasn't synced alongside empty:\n%s", page)
+			}
+
+			w = serve(e, newDelivery("push", pushBody("empty", "refs/heads/master")))
+			if got := w.Code == http.StatusOK; got != tc.wantSkip {
+				t.Errorf("push: got status %d, want a 200: %v", w.Code, tc.wantSkip)
+			}
+			if _, err := os.Stat(filepath.Join(e.hugoSource, e.dir, "empty.md")); err == nil {
+				t.Error("wrote a page for the empty repo")
+			}
+			if tc.wantSkip && strings.Contains(logs.String(), "empty: non-200") {
+				t.Errorf("logged an error for the empty repo:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestGitHubAPIURL(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		prefix string
+	}{
+		{name: "public API", prefix: ""},
+		{name: "GitHub Enterprise", prefix: "/api/v3"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var requested []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requested = append(requested, r.URL.Path)
+				if r.URL.Path != tc.prefix+"/repos/darlinggo/api/readme" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Write([]byte("# canned api\n"))
+			}))
+			defer srv.Close()
+			e := newTestEnv(t, srv.URL+tc.prefix)
+			got, err := e.readme(context.Background(), "api", "")
+			if err != nil {
+				t.Fatalf("%v, requested %q", err, requested)
+			}
+			if string(got) != "# canned api\n" {
+				t.Errorf("got %q, want the canned README", got)
+			}
+		})
+	}
+}
+
+func TestGitHubRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		statuses     []int
+		retryAfter   string
+		wantCode     int
+		wantRequests int
+		minWait      time.Duration
+	}{
+		{name: "fails twice then succeeds", statuses: []int{502, 503, 200}, wantCode: 200, wantRequests: 3},
+		{name: "rate limited then succeeds", statuses: []int{429, 200}, retryAfter: "1", wantCode: 200, wantRequests: 2, minWait: time.Second},
+		{name: "not found isn't retried", statuses: []int{404, 200}, wantCode: 404, wantRequests: 1},
+		{name: "gives up after max attempts", statuses: []int{500, 500, 500, 200}, wantCode: 500, wantRequests: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				code := tc.statuses[requests]
+				requests++
+				mu.Unlock()
+				if code == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(code)
+				w.Write([]byte("# api\n"))
+			}))
+			defer srv.Close()
+			e := newTestEnv(t, srv.URL)
+			e.maxAttempts = 3
+			start := time.Now()
+			_, err := e.pullReadme(context.Background(), "darlinggo/api", "")
+			code := 200
+			if serr, ok := err.(statusError); ok {
+				code = serr.code
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != tc.wantCode {
+				t.Errorf("got status %d, want %d", code, tc.wantCode)
+			}
+			if requests != tc.wantRequests {
+				t.Errorf("got %d requests, want %d", requests, tc.wantRequests)
+			}
+			if waited := time.Since(start); waited < tc.minWait {
+				t.Errorf("retried after %s, want at least %s", waited, tc.minWait)
+			}
+		})
+	}
+}
+
+func TestGitHubRetriesNetworkErrors(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	e := newTestEnv(t, srv.URL)
+	e.maxAttempts = 2
+	logs := captureLog(t)
+	if _, err := e.pullReadme(context.Background(), "darlinggo/api", ""); err == nil {
+		t.Fatal("expected an error from a closed server")
+	}
+	if got := strings.Count(logs.String(), "retrying in"); got != 1 {
+		t.Errorf("logged %d retries, want 1:\n%s", got, logs.String())
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	for _, tc := range []struct {
+		name  string
+		fetch func(e env) error
+	}{
+		{name: "pullReadme", fetch: func(e env) error {
+			_, err := e.pullReadme(context.Background(), "darlinggo/api", "")
+			return err
+		}},
+		{name: "syncAll", fetch: func(e env) error {
+			_, failed := e.syncAll(context.Background(), []string{"api"})
+			return failed["api"]
+		}},
+		{name: "cancelled webhook request", fetch: func(e env) error {
+			e.requestTimeout = time.Minute
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, failed := e.syncAll(ctx, []string{"api"})
+			return failed["api"]
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t, srv.URL)
+			e.requestTimeout = 50 * time.Millisecond
+			start := time.Now()
+			err := tc.fetch(e)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+			}
+			if took := time.Since(start); took > time.Second {
+				t.Errorf("took %s to time out", took)
+			}
+		})
+	}
+}
+
+// TestRequestTimeoutPerAttempt checks that REQUEST_TIMEOUT bounds each
+// attempt, reading the body included, but not the waits between them.
+func TestRequestTimeoutPerAttempt(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		serve   func(w http.ResponseWriter, r *http.Request, attempt int)
+		wantErr error
+	}{
+		{
+			name: "Retry-After longer than the timeout",
+			serve: func(w http.ResponseWriter, r *http.Request, attempt int) {
+				if attempt == 1 {
+					w.Header().Set("Retry-After", "1")
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.Write([]byte("# api\n"))
+			},
+		},
+		{
+			name: "slow attempt retried",
+			serve: func(w http.ResponseWriter, r *http.Request, attempt int) {
+				if attempt == 1 {
+					select {
+					case <-r.Context().Done():
+					case <-time.After(5 * time.Second):
+					}
+					return
+				}
+				w.Write([]byte("# api\n"))
+			},
+		},
+		{
+			name: "stalled body",
+			serve: func(w http.ResponseWriter, r *http.Request, attempt int) {
+				w.Write([]byte("# api\n"))
+				w.(http.Flusher).Flush()
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempts++
+				attempt := attempts
+				mu.Unlock()
+				tc.serve(w, r, attempt)
+			}))
+			defer srv.Close()
+			e := newTestEnv(t, srv.URL)
+			e.maxAttempts, e.requestTimeout = 2, 200*time.Millisecond
+			body, err := e.pullReadme(context.Background(), "darlinggo/api", "")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("got error %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || string(body) != "# api\n" {
+				t.Errorf("got %q, %v, want the README", body, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestContentHashFrontMatter(t *testing.T) {
+	sha := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	for _, tc := range []struct {
+		name    string
+		embed   bool
+		emoji   string
+		readmes []string
+	}{
+		{name: "off", readmes: []string{"# api\n"}},
+		{name: "on", embed: true, readmes: []string{"# api\n"}},
+		{name: "changed content", embed: true, readmes: []string{"# api\n", "# api, updated\n"}},
+		{name: "hash of the raw README", embed: true, emoji: emojiConvert, readmes: []string{"# api :tada:\n"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			e := newTestEnv(t, gh.URL)
+			e.embedContentHash, e.emoji = tc.embed, tc.emoji
+			for _, readme := range tc.readmes {
+				gh.setReadme("darlinggo/api", readme)
+				if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+					t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+				}
+				page := readPage(t, e, "api")
+				want := `content_hash = "` + sha(readme) + `"`
+				if got := strings.Contains(page, want); got != tc.embed {
+					t.Errorf("page contains %s: got %v, want %v\n%s", want, got, tc.embed, page)
+				}
+				if !tc.embed && strings.Contains(page, "content_hash") {
+					t.Errorf("page has a content hash:\n%s", page)
+				}
+			}
+		})
+	}
+}
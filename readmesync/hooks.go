@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// runRepoHook pipes readme through the command REPO_HOOKS_FILE maps repo
+// to, if there is one, and returns what the command printed as the new
+// README. The command is run with sh in HUGO_SOURCE, with REPO set.
+func (e env) runRepoHook(repo string, readme []byte) ([]byte, error) {
+	command, ok := e.repoHooks[repo]
+	if !ok {
+		return readme, nil
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = e.hugoSource
+	cmd.Env = append(os.Environ(), "REPO="+repo)
+	cmd.Stdin = bytes.NewReader(readme)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.New(repo + ": hook failed: " + err.Error() + ": " + stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
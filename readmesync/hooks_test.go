@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepoHooks(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	gh.setReadme("darlinggo/hash", "# hash\n")
+	gh.setReadme("darlinggo/site", "# site\n")
+	e := newTestEnv(t, gh.URL)
+	e.repoHooks = map[string]string{
+		"api":  `tr a-z A-Z; echo "generated for $REPO"`,
+		"site": "echo broken >&2; exit 3",
+	}
+	w := serve(e, newDelivery("sync-all", syncAllBody("api", "hash", "site")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	var s syncSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+	if s.Succeeded != 2 || s.Failed != 1 {
+		t.Errorf("got %+v, want 2 synced and 1 failed", s)
+	}
+	if msg := s.Errors["site"]; !strings.Contains(msg, "hook failed") || !strings.Contains(msg, "broken") {
+		t.Errorf("got error %q for site, want its hook's failure", msg)
+	}
+	if page := readPage(t, e, "api"); !strings.Contains(page, "# API\ngenerated for api\n") {
+		t.Errorf("api's hook wasn't applied:\n%s", page)
+	}
+	if page := readPage(t, e, "hash"); !strings.Contains(page, "# hash\n") {
+		t.Errorf("hash without a hook changed:\n%s", page)
+	}
+	if _, err := os.Stat(filepath.Join(e.hugoSource, e.dir, "site.md")); !os.IsNotExist(err) {
+		t.Errorf("site was written despite its failed hook: %v", err)
+	}
+	if runs := hugoRuns(t, e.hugoCmd); len(runs) != 1 {
+		t.Errorf("hugo ran %d times, want once", len(runs))
+	}
+}
+
+func TestRepoHookRunsInSource(t *testing.T) {
+	e := newTestEnv(t, "http://github.invalid")
+	e.repoHooks = map[string]string{"api": "pwd"}
+	got, err := e.runRepoHook("api", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := filepath.EvalSymlinks(e.hugoSource)
+	if strings.TrimSpace(string(got)) != dir {
+		t.Errorf("hook ran in %q, want %q", strings.TrimSpace(string(got)), dir)
+	}
+}
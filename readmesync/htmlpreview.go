@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+)
+
+// previewMarkdown renders READMEs for previews that don't need Hugo, with
+// GitHub's extensions, like tables. Heading IDs are generated the way
+// GitHub's are, or taken from {#id} attributes. Raw HTML in the README is
+// left out, as is any link that could run script.
+var previewMarkdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID(), parser.WithAttribute()),
+)
+
+func renderMarkdown(readme []byte) (string, error) {
+	var out bytes.Buffer
+	err := previewMarkdown.Convert(readme, &out)
+	return out.String(), err
+}
+
+const htmlPreviewPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{TITLE}}</title>
+</head>
+<body>
+{{BODY}}</body>
+</html>
+`
+
+// previewHTML serves GET /preview/html?repo=...&ref=..., rendering a
+// repo's README at ref straight to HTML, with the README transforms
+// applied but without Hugo or the site's theme.
+func (e env) previewHTML(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !e.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	repo, ref := r.URL.Query().Get("repo"), r.URL.Query().Get("ref")
+	if repo == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("repo is required"))
+		return
+	}
+	if !validRepoRef(repo) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("repo must be a repo name, optionally as owner/repo"))
+		return
+	}
+	repo, err := e.resolveRepo(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	readme, err := e.readme(r.Context(), repo, ref)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	body, err := renderMarkdown(e.transform(r.Context(), repo, ref, readme))
+	if err != nil {
+		log.Println(repo+": can't render preview:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	page := strings.Replace(htmlPreviewPage, "{{TITLE}}", html.EscapeString(repo), 1)
+	page = strings.Replace(page, "{{BODY}}", body, 1)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "script-src 'none'; object-src 'none'; frame-src 'none'")
+	w.Write([]byte(page))
+}
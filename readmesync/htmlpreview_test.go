@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		readme string
+		want   string
+	}{
+		{name: "ATX headings", readme: "# api\n## Getting Started\n", want: "<h1 id=\"api\">api</h1>\n<h2 id=\"getting-started\">Getting Started</h2>\n"},
+		{name: "setext heading", readme: "api\n===\n", want: "<h1 id=\"api\">api</h1>\n"},
+		{name: "explicit heading ID", readme: "## Install {#setup}\n", want: "<h2 id=\"setup\">Install</h2>\n"},
+		{name: "repeated headings", readme: "## Foo\n## Foo\n", want: "<h2 id=\"foo\">Foo</h2>\n<h2 id=\"foo-1\">Foo</h2>\n"},
+		{name: "paragraphs", readme: "One\nline.\n\nTwo.\n", want: "<p>One\nline.</p>\n<p>Two.</p>\n"},
+		{name: "emphasis", readme: "**bold**, *em*, ~~gone~~\n", want: "<p><strong>bold</strong>, <em>em</em>, <del>gone</del></p>\n"},
+		{name: "inline code", readme: "Run `go test <pkg>`.\n", want: "<p>Run <code>go test &lt;pkg&gt;</code>.</p>\n"},
+		{name: "links and images", readme: "[Go](https://go.dev) ![logo](logo.png)\n", want: "<p><a href=\"https://go.dev\">Go</a> <img src=\"logo.png\" alt=\"logo\"></p>\n"},
+		{name: "autolink", readme: "See https://go.dev.\n", want: "<p>See <a href=\"https://go.dev\">https://go.dev</a>.</p>\n"},
+		{name: "code fence", readme: "```go\nif a < b {\n```\n", want: "<pre><code class=\"language-go\">if a &lt; b {\n</code></pre>\n"},
+		{name: "nested list", readme: "- one\n  - nested\n- two\n", want: "<ul>\n<li>one\n<ul>\n<li>nested</li>\n</ul>\n</li>\n<li>two</li>\n</ul>\n"},
+		{name: "ordered list", readme: "1. one\n2. two\n", want: "<ol>\n<li>one</li>\n<li>two</li>\n</ol>\n"},
+		{name: "table", readme: "| a | b |\n|---|---|\n| 1 | 2 |\n", want: "<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>\n"},
+		{name: "block quote", readme: "> quoted\n", want: "<blockquote>\n<p>quoted</p>\n</blockquote>\n"},
+		{name: "thematic break", readme: "one\n\n***\n\ntwo\n", want: "<p>one</p>\n<hr>\n<p>two</p>\n"},
+		{name: "escaped text", readme: "a < b & c\n", want: "<p>a &lt; b &amp; c</p>\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := renderMarkdown([]byte(tc.readme))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestRenderMarkdownUnsafe checks that nothing in a README can run script
+// in its preview.
+func TestRenderMarkdownUnsafe(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		readme string
+		want   string
+	}{
+		{name: "script block", readme: "<script>alert(1)</script>\n", want: "<!-- raw HTML omitted -->\n"},
+		{name: "iframe", readme: "<iframe src=\"https://evil.example\"></iframe>\n", want: "<!-- raw HTML omitted -->\n"},
+		{name: "inline HTML", readme: "Hi <img src=x onerror=alert(1)> there\n", want: "<p>Hi <!-- raw HTML omitted --> there</p>\n"},
+		{name: "unterminated tag", readme: "<img src=x onerror=alert(1)\n\ntext\n", want: "<p>&lt;img src=x onerror=alert(1)</p>\n<p>text</p>\n"},
+		{name: "javascript link", readme: "[click](javascript:alert(1))\n", want: "<p><a href=\"\">click</a></p>\n"},
+		{name: "mixed case javascript link", readme: "[click](JaVaScRiPt:alert(1))\n", want: "<p><a href=\"\">click</a></p>\n"},
+		{name: "data image", readme: "![x](data:text/html;base64,PHNjcmlwdD4=)\n", want: "<p><img src=\"\" alt=\"x\"></p>\n"},
+		{name: "event handler attribute", readme: "## Foo {onclick=\"alert(1)\"}\n", want: "<h2 id=\"foo\">Foo</h2>\n"},
+		{name: "code isn't HTML", readme: "```html\n<script>alert(1)</script>\n```\n", want: "<pre><code class=\"language-html\">&lt;script&gt;alert(1)&lt;/script&gt;\n</code></pre>\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := renderMarkdown([]byte(tc.readme))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func previewHTMLRequest(query url.Values) *http.Request {
+	req := httptest.NewRequest("GET", "/preview/html?"+query.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	return req
+}
+
+func TestPreviewHTML(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n\nAn *API*.\n\n<img src=x onerror=alert(1)><script>alert(2)</script>\n")
+	gh.setReadme("darlinggo/api@feature", "# api on a branch\n")
+	for _, tc := range []struct {
+		name     string
+		query    url.Values
+		orgs     []string
+		unauthed bool
+		method   string
+		want     int
+		wantBody []string
+		wantNone []string
+	}{
+		{
+			name:     "README",
+			query:    url.Values{"repo": {"api"}},
+			want:     http.StatusOK,
+			wantBody: []string{"<title>api</title>", `<h1 id="api">api</h1>`, "<p>An <em>API</em>.</p>", "<!-- raw HTML omitted -->"},
+			wantNone: []string{"onerror", "<script"},
+		},
+		{name: "ref", query: url.Values{"repo": {"api"}, "ref": {"feature"}}, want: http.StatusOK, wantBody: []string{"api on a branch"}},
+		{name: "owner/repo", query: url.Values{"repo": {"darlinggo/api"}}, want: http.StatusOK, wantBody: []string{`<h1 id="api">api</h1>`}},
+		{name: "no repo", query: url.Values{}, want: http.StatusBadRequest, wantBody: []string{"repo is required"}},
+		{name: "traversal", query: url.Values{"repo": {"../../etc/passwd"}}, want: http.StatusBadRequest, wantBody: []string{"repo must be a repo name"}},
+		{name: "invalid name", query: url.Values{"repo": {"api?x=1"}}, want: http.StatusBadRequest, wantBody: []string{"repo must be a repo name"}},
+		{name: "another org", query: url.Values{"repo": {"octo/api"}}, want: http.StatusBadRequest, wantBody: []string{"octo is not one of GITHUB_ORGS"}},
+		{name: "bare name with several orgs", query: url.Values{"repo": {"api"}}, orgs: []string{"darlinggo", "acme"}, want: http.StatusBadRequest, wantBody: []string{"repos must be given as org/repo"}},
+		{name: "missing README", query: url.Values{"repo": {"missing"}}, want: http.StatusBadGateway},
+		{name: "unauthorized", query: url.Values{"repo": {"api"}}, unauthed: true, want: http.StatusUnauthorized},
+		{name: "POST", query: url.Values{"repo": {"api"}}, method: "POST", want: http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t, gh.URL)
+			e.adminToken = "admin"
+			if tc.orgs != nil {
+				e.orgs = tc.orgs
+			}
+			req := previewHTMLRequest(tc.query)
+			if tc.unauthed {
+				req.Header.Del("Authorization")
+			}
+			if tc.method != "" {
+				req.Method = tc.method
+			}
+			w := httptest.NewRecorder()
+			e.previewHTML(w, req)
+			if w.Code != tc.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			for _, want := range tc.wantBody {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("body doesn't contain %s:\n%s", want, w.Body.String())
+				}
+			}
+			for _, unwanted := range tc.wantNone {
+				if strings.Contains(w.Body.String(), unwanted) {
+					t.Errorf("body contains %s:\n%s", unwanted, w.Body.String())
+				}
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if csp := w.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "script-src 'none'") {
+				t.Errorf("got Content-Security-Policy %q, want script-src 'none'", csp)
+			}
+		})
+	}
+	// Nothing outside GITHUB_ORGS, or that isn't a repo name, is fetched.
+	for _, path := range gh.requested() {
+		if !strings.HasPrefix(path, "/repos/darlinggo/") {
+			t.Errorf("requested %s", path)
+		}
+	}
+}
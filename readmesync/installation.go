@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type repoSet struct {
+	sync.RWMutex
+	repos map[string]struct{}
+}
+
+func newRepoSet(dir string) (*repoSet, error) {
+	s := &repoSet{repos: map[string]struct{}{}}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, f := range files {
+		if filepath.Ext(f.Name()) == ".md" && !f.IsDir() {
+			s.repos[strings.TrimSuffix(f.Name(), ".md")] = struct{}{}
+			continue
+		}
+		if !f.IsDir() {
+			continue
+		}
+		// Repos from several orgs are namespaced in a directory per org.
+		nested, err := ioutil.ReadDir(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		// Split READMEs are a directory of pages per repo.
+		if isSplitPage(filepath.Join(dir, f.Name())) {
+			s.repos[f.Name()] = struct{}{}
+			continue
+		}
+		for _, n := range nested {
+			if n.IsDir() && isSplitPage(filepath.Join(dir, f.Name(), n.Name())) {
+				s.repos[f.Name()+"/"+n.Name()] = struct{}{}
+				continue
+			}
+			if !n.IsDir() && filepath.Ext(n.Name()) == ".md" {
+				s.repos[f.Name()+"/"+strings.TrimSuffix(n.Name(), ".md")] = struct{}{}
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *repoSet) add(repos ...string) {
+	s.Lock()
+	defer s.Unlock()
+	for _, repo := range repos {
+		s.repos[repo] = struct{}{}
+	}
+}
+
+func (s *repoSet) remove(repos ...string) {
+	s.Lock()
+	defer s.Unlock()
+	for _, repo := range repos {
+		delete(s.repos, repo)
+	}
+}
+
+func (s *repoSet) has(repo string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.repos[repo]
+	return ok
+}
+
+func (s *repoSet) list() []string {
+	s.RLock()
+	defer s.RUnlock()
+	repos := make([]string, 0, len(s.repos))
+	for repo := range s.repos {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	return repos
+}
+
+type installationRepo struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+}
+
+type installationEvent struct {
+	Action       string             `json:"action"`
+	Repositories []installationRepo `json:"repositories"`
+	Added        []installationRepo `json:"repositories_added"`
+	Removed      []installationRepo `json:"repositories_removed"`
+}
+
+func repoNames(repos []installationRepo) []string {
+	names := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		name := repo.FullName
+		if name == "" {
+			name = repo.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// installationChanges returns the full names of the repos an installation
+// or installation_repositories event adds to and removes from the active
+// set.
+func installationChanges(event string, body []byte) (added, removed []string, err error) {
+	var payload installationEvent
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	if event == "installation" {
+		switch payload.Action {
+		case "created":
+			return repoNames(payload.Repositories), nil, nil
+		case "deleted":
+			return nil, repoNames(payload.Repositories), nil
+		}
+		return nil, nil, nil
+	}
+	return repoNames(payload.Added), repoNames(payload.Removed), nil
+}
+
+// checkRepoNames returns an error if any of names isn't safe to use in a
+// path.
+func checkRepoNames(names ...[]string) error {
+	for _, list := range names {
+		for _, name := range list {
+			if !validRepoRef(name) {
+				return errors.New(name + ": not a valid repo name")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstallationEvents(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		event      string
+		body       string
+		sync       bool
+		remove     bool
+		wantActive map[string]bool
+		wantPages  map[string]bool
+		wantBuilds int
+	}{
+		{
+			name:       "repo added",
+			event:      "installation_repositories",
+			body:       `{"action": "added", "repositories_added": [{"name": "api", "full_name": "darlinggo/api"}]}`,
+			wantActive: map[string]bool{"api": true, "hash": true},
+			wantPages:  map[string]bool{"api": false, "hash": true},
+		},
+		{
+			name:       "repo added and synced",
+			event:      "installation_repositories",
+			body:       `{"action": "added", "repositories_added": [{"name": "api", "full_name": "darlinggo/api"}]}`,
+			sync:       true,
+			wantActive: map[string]bool{"api": true, "hash": true},
+			wantPages:  map[string]bool{"api": true, "hash": true},
+			wantBuilds: 1,
+		},
+		{
+			name:       "repo removed",
+			event:      "installation_repositories",
+			body:       `{"action": "removed", "repositories_removed": [{"name": "hash", "full_name": "darlinggo/hash"}]}`,
+			wantActive: map[string]bool{"api": false, "hash": false},
+			wantPages:  map[string]bool{"api": false, "hash": true},
+		},
+		{
+			name:       "repo removed with its page",
+			event:      "installation_repositories",
+			body:       `{"action": "removed", "repositories_removed": [{"name": "hash", "full_name": "darlinggo/hash"}]}`,
+			remove:     true,
+			wantActive: map[string]bool{"api": false, "hash": false},
+			wantPages:  map[string]bool{"api": false, "hash": false},
+			wantBuilds: 1,
+		},
+		{
+			name:       "app installed",
+			event:      "installation",
+			body:       `{"action": "created", "repositories": [{"name": "api", "full_name": "darlinggo/api"}]}`,
+			sync:       true,
+			wantActive: map[string]bool{"api": true, "hash": true},
+			wantPages:  map[string]bool{"api": true, "hash": true},
+			wantBuilds: 1,
+		},
+		{
+			name:       "other orgs ignored",
+			event:      "installation_repositories",
+			body:       `{"action": "added", "repositories_added": [{"name": "api", "full_name": "someone/api"}]}`,
+			sync:       true,
+			wantActive: map[string]bool{"api": false, "hash": true},
+			wantPages:  map[string]bool{"api": false, "hash": true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.installationEvents, e.installationSync, e.installationRemove = true, tc.sync, tc.remove
+			writeTestPage(t, e, "hash")
+			e.active.add("hash")
+			w := serve(e, newDelivery(tc.event, tc.body))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			for repo, want := range tc.wantActive {
+				if got := e.active.has(repo); got != want {
+					t.Errorf("%s active: got %v, want %v", repo, got, want)
+				}
+			}
+			for repo, want := range tc.wantPages {
+				_, err := os.Stat(filepath.Join(e.hugoSource, e.dir, repo+".md"))
+				if got := err == nil; got != want {
+					t.Errorf("%s page exists: got %v, want %v", repo, got, want)
+				}
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != tc.wantBuilds {
+				t.Errorf("hugo ran %d times, want %d", len(runs), tc.wantBuilds)
+			}
+		})
+	}
+}
+
+func TestInstallationEventsVerified(t *testing.T) {
+	e := newTestEnv(t, "http://github.invalid")
+	e.installationEvents = true
+	req := newDelivery("installation_repositories", `{"action": "removed", "repositories_removed": [{"name": "hash", "full_name": "darlinggo/hash"}]}`)
+	signDelivery(req, []byte("{}"), []byte(testSecret))
+	e.active.add("hash")
+	if w := serve(e, req); w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if !e.active.has("hash") {
+		t.Error("unverified event removed hash from the active set")
+	}
+}
+
+// TestPushesToSyncedRepos checks that repos whose pages were written by
+// something other than an installation event, like a sync-all, are
+// active, so their pushes aren't ignored.
+func TestPushesToSyncedRepos(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	e := newTestEnv(t, gh.URL)
+	e.installationEvents = true
+	if w := serve(e, newDelivery("sync-all", syncAllBody("api"))); w.Code != http.StatusOK {
+		t.Fatalf("sync-all: got status %d: %s", w.Code, w.Body.String())
+	}
+	if !e.active.has("api") {
+		t.Fatal("synced repo isn't active")
+	}
+	gh.setReadme("darlinggo/api", "# api, updated\n")
+	if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+		t.Fatalf("push: got status %d: %s", w.Code, w.Body.String())
+	}
+	if runs := hugoRuns(t, e.hugoCmd); len(runs) != 2 {
+		t.Errorf("hugo ran %d times, want 2", len(runs))
+	}
+}
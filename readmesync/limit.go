@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// limitConcurrency runs at most n concurrent requests through h, turning
+// away any more with a 503 that asks the sender to retry after retryAfter.
+func limitConcurrency(h http.Handler, n int, retryAfter time.Duration) http.Handler {
+	sem := make(chan struct{}, n)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+		default:
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-sem }()
+		h.ServeHTTP(w, r)
+	})
+}
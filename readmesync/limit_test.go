@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimitConcurrency(t *testing.T) {
+	for _, n := range []int{1, 3} {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		h := limitConcurrency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+		}), n, 5*time.Second)
+
+		// Fill the semaphore.
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if w := serve(h, httptest.NewRequest("POST", "/hook", nil)); w.Code != http.StatusOK {
+					t.Errorf("got status %d for a request within the limit", w.Code)
+				}
+			}()
+			<-started
+		}
+
+		w := serve(h, httptest.NewRequest("POST", "/hook", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("limit %d: got status %d when saturated, want %d", n, w.Code, http.StatusServiceUnavailable)
+		}
+		if got := w.Header().Get("Retry-After"); got != "5" {
+			t.Errorf("limit %d: got Retry-After %q, want 5", n, got)
+		}
+
+		close(release)
+		wg.Wait()
+		go func() { <-started }()
+		if w := serve(h, httptest.NewRequest("POST", "/hook", nil)); w.Code != http.StatusOK {
+			t.Errorf("limit %d: got status %d once the semaphore drained, want %d", n, w.Code, http.StatusOK)
+		}
+	}
+}
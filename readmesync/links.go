@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	deadLinksAnnotate = "annotate"
+	deadLinksDrop     = "drop"
+)
+
+var markdownLink = regexp.MustCompile(`!?\[([^\]]*)\]\((https?://[^)\s]+)(?:\s+"[^"]*")?\)`)
+
+// newLinkClient returns the client links are checked with. So that a
+// README can't have readmesync probe the network it runs on, it won't
+// connect to loopback, private or link-local addresses, and only follows
+// redirects to links it would have checked on host anyway.
+func newLinkClient(host string) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: publicOnly}
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !underHost(host, req.URL) {
+				return errors.New("not following redirect off " + host + " to " + req.URL.String())
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+}
+
+// publicOnly refuses to connect to address unless it's a public one. It's
+// called once the host has been resolved, so a public name for a private
+// address is refused too.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return errors.New(host + " isn't a public address")
+	}
+	return nil
+}
+
+// underHost reports whether u is an http or https URL on host, or on a
+// subdomain of it.
+func underHost(host string, u *url.URL) bool {
+	name := strings.ToLower(u.Hostname())
+	return (u.Scheme == "http" || u.Scheme == "https") && (name == host || strings.HasSuffix(name, "."+host))
+}
+
+// linkDead reports whether link definitely doesn't exist, as far as the
+// public is concerned. Private GitHub repos 404, just like missing ones.
+// Errors and other failures aren't treated as dead, so a flaky server
+// doesn't lose its links.
+func (e env) linkDead(link string) bool {
+	resp, err := e.linkClient.Head(link)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, err = e.linkClient.Get(link)
+	}
+	if err != nil {
+		log.Println("checking link:", err)
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone
+}
+
+type linkResult struct {
+	dead    bool
+	checked time.Time
+}
+
+// linkCache remembers whether links were dead for ttl, so the same links
+// aren't checked again on every sync.
+type linkCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	results map[string]linkResult
+}
+
+func newLinkCache(ttl time.Duration) *linkCache {
+	return &linkCache{ttl: ttl, results: map[string]linkResult{}}
+}
+
+func (c *linkCache) get(url string) (dead, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	result, ok := c.results[url]
+	if !ok || time.Since(result.checked) >= c.ttl {
+		delete(c.results, url)
+		return false, false
+	}
+	return result.dead, true
+}
+
+func (c *linkCache) set(url string, dead bool) {
+	c.Lock()
+	c.results[url] = linkResult{dead: dead, checked: time.Now()}
+	c.Unlock()
+}
+
+// checkLinks checks every absolute link in readme to GitHub, at most
+// LINK_CHECK_CONCURRENCY at a time across all READMEs, and drops or
+// annotates the dead ones, according to DEAD_LINKS. Results are cached
+// for LINK_CACHE_TTL. Links anywhere else are left alone.
+func (e env) checkLinks(readme []byte) []byte {
+	var urls []string
+	seen := map[string]bool{}
+	eachLine(readme, func(line string) string {
+		for _, m := range markdownLink.FindAllStringSubmatch(line, -1) {
+			u, err := url.Parse(m[2])
+			if err != nil || !underHost(e.linkHost, u) {
+				continue
+			}
+			if !seen[m[2]] {
+				seen[m[2]] = true
+				urls = append(urls, m[2])
+			}
+		}
+		return line
+	})
+	// Cached results are filled in before any checks start, so only the
+	// checks need the lock.
+	dead := map[string]bool{}
+	var unchecked []string
+	for _, url := range urls {
+		if isDead, ok := e.linkCache.get(url); ok {
+			dead[url] = isDead
+			continue
+		}
+		unchecked = append(unchecked, url)
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, url := range unchecked {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			e.linkSlots <- struct{}{}
+			isDead := e.linkDead(url)
+			<-e.linkSlots
+			e.linkCache.set(url, isDead)
+			mu.Lock()
+			dead[url] = isDead
+			mu.Unlock()
+		}(url)
+	}
+	wg.Wait()
+	return eachLine(readme, func(line string) string {
+		return markdownLink.ReplaceAllStringFunc(line, func(link string) string {
+			m := markdownLink.FindStringSubmatch(link)
+			if !dead[m[2]] {
+				return link
+			}
+			log.Println("dead link: " + m[2])
+			if e.deadLinks == deadLinksDrop {
+				return m[1]
+			}
+			return m[1] + " (link unavailable)"
+		})
+	})
+}
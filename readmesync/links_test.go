@@ -0,0 +1,272 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newLinkServer serves /live, 404s /private, 410s /gone, 500s /flaky, and
+// only answers GETs to /get-only, counting the requests for each path.
+func newLinkServer(t *testing.T) (*httptest.Server, func(path string) int) {
+	var mu sync.Mutex
+	counts := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		counts[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/live":
+		case "/private":
+			w.WriteHeader(http.StatusNotFound)
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/flaky":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/get-only":
+			if r.Method != "GET" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return counts[path]
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	srv, _ := newLinkServer(t)
+	for _, tc := range []struct {
+		name      string
+		deadLinks string
+		readme    string
+		want      string
+	}{
+		{
+			name:   "live link intact",
+			readme: "See [the docs](" + srv.URL + "/live).\n",
+			want:   "See [the docs](" + srv.URL + "/live).\n",
+		},
+		{
+			name:      "dead link annotated",
+			deadLinks: deadLinksAnnotate,
+			readme:    "See [the source](" + srv.URL + "/private).\n",
+			want:      "See the source (link unavailable).\n",
+		},
+		{
+			name:      "dead link dropped",
+			deadLinks: deadLinksDrop,
+			readme:    "See [the source](" + srv.URL + "/private \"Source\").\n",
+			want:      "See the source.\n",
+		},
+		{
+			name:      "gone",
+			deadLinks: deadLinksAnnotate,
+			readme:    "[old](" + srv.URL + "/gone)\n",
+			want:      "old (link unavailable)\n",
+		},
+		{
+			name:      "server errors aren't dead",
+			deadLinks: deadLinksAnnotate,
+			readme:    "[flaky](" + srv.URL + "/flaky)\n",
+			want:      "[flaky](" + srv.URL + "/flaky)\n",
+		},
+		{
+			name:      "HEAD not allowed falls back to GET",
+			deadLinks: deadLinksAnnotate,
+			readme:    "[api](" + srv.URL + "/get-only)\n",
+			want:      "api (link unavailable)\n",
+		},
+		{
+			name:      "relative links left alone",
+			deadLinks: deadLinksAnnotate,
+			readme:    "[license](LICENSE)\n",
+			want:      "[license](LICENSE)\n",
+		},
+		{
+			name:      "mixed",
+			deadLinks: deadLinksAnnotate,
+			readme:    "[a](" + srv.URL + "/live) and [b](" + srv.URL + "/private), [a](" + srv.URL + "/live) again\n",
+			want:      "[a](" + srv.URL + "/live) and b (link unavailable), [a](" + srv.URL + "/live) again\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t, "http://github.invalid")
+			e.deadLinks = tc.deadLinks
+			if got := string(e.checkLinks([]byte(tc.readme))); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckLinksCached(t *testing.T) {
+	srv, count := newLinkServer(t)
+	e := newTestEnv(t, "http://github.invalid")
+	readme := []byte("[a](" + srv.URL + "/private) [b](" + srv.URL + "/live)\n")
+	first := string(e.checkLinks(readme))
+	second := string(e.checkLinks(readme))
+	if first != second {
+		t.Errorf("cached result %q differs from %q", second, first)
+	}
+	if !strings.Contains(second, "a (link unavailable)") {
+		t.Errorf("cached dead link wasn't annotated: %q", second)
+	}
+	for _, path := range []string{"/private", "/live"} {
+		if n := count(path); n != 1 {
+			t.Errorf("%s checked %d times, want 1", path, n)
+		}
+	}
+}
+
+// TestCheckLinksMixedCache mixes cached links in among ones still to be
+// checked, so that, run with -race, results being filled in from the
+// cache don't race with the checks.
+func TestCheckLinksMixedCache(t *testing.T) {
+	srv, count := newLinkServer(t)
+	e := newTestEnv(t, "http://github.invalid")
+	var readme, want strings.Builder
+	for i := 0; i < 20; i++ {
+		n := strconv.Itoa(i)
+		cached := srv.URL + "/cached?" + n
+		e.linkCache.set(cached, i%2 == 0)
+		readme.WriteString("[miss" + n + "](" + srv.URL + "/private?" + n + ") [hit" + n + "](" + cached + ")\n")
+		want.WriteString("miss" + n + " (link unavailable) ")
+		if i%2 == 0 {
+			want.WriteString("hit" + n + " (link unavailable)\n")
+		} else {
+			want.WriteString("[hit" + n + "](" + cached + ")\n")
+		}
+	}
+	if got := string(e.checkLinks([]byte(readme.String()))); got != want.String() {
+		t.Errorf("got\n%s\nwant\n%s", got, want.String())
+	}
+	if n := count("/private"); n != 20 {
+		t.Errorf("checked %d uncached links, want 20", n)
+	}
+	if n := count("/cached"); n != 0 {
+		t.Errorf("checked %d cached links, want none", n)
+	}
+}
+
+// TestCheckLinksRestricted checks that links are only checked on GitHub,
+// and never on addresses that aren't public, so a README can't use
+// readmesync to probe the network it runs on.
+func TestCheckLinksRestricted(t *testing.T) {
+	srv, count := newLinkServer(t)
+	readme := "[design](" + srv.URL + "/private)\n"
+	for _, tc := range []struct {
+		name   string
+		host   string
+		client func(host string) *http.Client
+	}{
+		{name: "off GitHub", host: "github.com"},
+		{name: "loopback address", host: "127.0.0.1", client: newLinkClient},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t, "http://github.invalid")
+			e.linkHost = tc.host
+			if tc.client != nil {
+				e.linkClient = tc.client(tc.host)
+			}
+			if got := string(e.checkLinks([]byte(readme))); got != readme {
+				t.Errorf("got %q, want the link left alone", got)
+			}
+			if n := count("/private"); n != 0 {
+				t.Errorf("link checked %d times", n)
+			}
+		})
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	for _, tc := range []struct {
+		address string
+		want    bool
+	}{
+		{address: "140.82.112.3:443", want: true},
+		{address: "[2606:50c0:8000::153]:443", want: true},
+		{address: "127.0.0.1:80"},
+		{address: "[::1]:80"},
+		{address: "10.0.0.1:443"},
+		{address: "172.16.0.1:443"},
+		{address: "192.168.1.1:80"},
+		{address: "169.254.169.254:80"},
+		{address: "[fd00::1]:443"},
+		{address: "[fe80::1]:443"},
+		{address: "0.0.0.0:80"},
+		{address: "[::ffff:127.0.0.1]:80"},
+	} {
+		if got := publicOnly("tcp", tc.address, nil) == nil; got != tc.want {
+			t.Errorf("%s: got allowed %v, want %v", tc.address, got, tc.want)
+		}
+	}
+}
+
+func TestLinkRedirects(t *testing.T) {
+	c := newLinkClient("github.com")
+	for _, tc := range []struct {
+		url  string
+		want bool
+	}{
+		{url: "https://github.com/darlinggo/api", want: true},
+		{url: "https://gist.github.com/paddycarver/1", want: true},
+		{url: "https://notgithub.com/"},
+		{url: "http://169.254.169.254/latest/meta-data/"},
+		{url: "ftp://github.com/"},
+	} {
+		req, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.CheckRedirect(req, nil) == nil; got != tc.want {
+			t.Errorf("%s: got followed %v, want %v", tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestLinkCacheTTL(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		ttl    time.Duration
+		wantOK bool
+	}{
+		{name: "within the TTL", ttl: time.Hour, wantOK: true},
+		{name: "expired", ttl: time.Nanosecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newLinkCache(tc.ttl)
+			c.set("https://example.com", true)
+			time.Sleep(time.Millisecond)
+			dead, ok := c.get("https://example.com")
+			if ok != tc.wantOK || dead != tc.wantOK {
+				t.Errorf("got dead %v, ok %v, want %v, %v", dead, ok, tc.wantOK, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateLinksPage(t *testing.T) {
+	srv, _ := newLinkServer(t)
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n\n[docs]("+srv.URL+"/live) [design]("+srv.URL+"/private)\n")
+	e := newTestEnv(t, gh.URL)
+	e.validateLinks = true
+	if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	page := readPage(t, e, "api")
+	want := "[docs](" + srv.URL + "/live) design (link unavailable)"
+	if !strings.Contains(page, want) {
+		t.Errorf("page doesn't contain %q:\n%s", want, page)
+	}
+}
@@ -1,229 +1,943 @@
 package main
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
-	"sync"
 	"text/template"
 	"time"
 )
 
 const projectTmpl = `
 +++
-date = "{{ .Date }}"
-title = "{{ .Name }}"
-repo = "{{ .Name }}"
-url = "/{{ .Name }}"
+date = {{ toml .Date }}
+title = {{ toml .Title }}
+repo = {{ toml .Repo }}
+url = {{ toml .URL }}
+{{- with .Version }}
+version = {{ toml . }}
+{{- end }}
+{{- with .Description }}
+description = {{ toml . }}
+{{- end }}
+{{- with .Homepage }}
+homepage = {{ toml . }}
+{{- end }}
+{{- with .Topics }}
+topics = {{ toml . }}
+{{- end }}
+{{- with .Stars }}
+stars = {{ . }}
+{{- end }}
+{{- with .Forks }}
+forks = {{ . }}
+{{- end }}
+{{- with .Language }}
+language = {{ toml . }}
+{{- end }}
+{{- with .Languages }}
+languages = {{ toml . }}
+{{- end }}
+{{- with .ContentHash }}
+content_hash = {{ toml . }}
+{{- end }}
+{{- range $key, $value := .Params }}
+{{ tomlKey $key }} = {{ toml $value }}
+{{- end }}
 +++
 
-{{ .Readme }}
+{{ with .Header }}{{ . }}
+
+{{ end }}{{ .Readme }}{{ with .Footer }}
+
+{{ . }}{{ end }}
 `
 
 var (
-	tmpl = template.Must(template.New("project").Parse(projectTmpl))
+	tmplFuncs = template.FuncMap{"toml": tomlValue, "tomlKey": tomlKey}
+	tmpl      = template.Must(template.New("project").Funcs(tmplFuncs).Parse(projectTmpl))
 )
 
+type pageData struct {
+	Name    string
+	Repo    string
+	Version string
+	Readme  string
+	Date    string
+	Title   string
+	URL     string
+
+	Description string
+	Homepage    string
+	Topics      []string
+	Stars       int
+	Forks       int
+	Language    string
+	Languages   map[string]int
+	ContentHash string
+	Params      map[string]interface{}
+
+	Header string
+	Footer string
+}
+
 type env struct {
+	githubAPI   string
 	githubToken string
+	maxAttempts int
+	retryDelay  time.Duration
+	rateLimit   *rateLimit
+	orgTokens   map[string]orgToken
+	orgs        []string
+
+	// requestTimeout bounds each attempt at a request to the GitHub API.
+	// Waits for the rate limit to reset, and between retries, aren't
+	// included.
+	requestTimeout time.Duration
+
+	// maxBodyBytes bounds the size of a delivery's body, GitHub's own
+	// 25MB cap by default. Zero means no limit.
+	maxBodyBytes int64
+
 	hookSecret  []byte
+	repoSecrets map[string]string
 	dir         string
 	hugoCmd     string
 	hugoSource  string
-}
+	hugoConfig  string
+	repoConfigs map[string]string
+	outputDirs  map[string]string
+	repoHooks   map[string]string
+	siteLocks   *keyedMutex
+	reports     *reportKeeper
+	index       *repoIndex
+	deliveries  *coalescer
+	activity    *activity
+	audit       *auditLog
+	reportPath  string
+	adminToken  string
 
-func pullReadme(pkg, accessToken string) ([]byte, error) {
-	req, err := http.NewRequest("GET", "https://api.github.com/repos/darlinggo/"+pkg+"/readme", nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Accept", "application/vnd.github.v3.raw")
-	req.Header.Set("Authorization", "token "+accessToken)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode != 200 {
-		return body, errors.New(pkg + ": non-200 status: " + resp.Status)
-	}
-	return body, nil
-}
+	// segmentConfig is the Hugo config file restricting builds to the
+	// generated section, when BUILD_SECTION_ONLY is set.
+	segmentConfig string
 
-type result struct {
-	repo string
-	body []byte
-}
+	previewDir     string
+	previewBaseURL string
+	previewTTL     time.Duration
 
-func syncAll(repos []string, token string) map[string][]byte {
-	results := map[string][]byte{}
-	resultChan := make(chan result)
-	var wg sync.WaitGroup
-	for _, repo := range repos {
-		wg.Add(1)
-		go func(r string, wg *sync.WaitGroup, ch chan result) {
-			defer wg.Done()
-			resp, err := pullReadme(r, token)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			ch <- result{body: resp, repo: r}
-		}(repo, &wg, resultChan)
-	}
-	go func(wg *sync.WaitGroup, ch chan result) {
-		wg.Wait()
-		close(ch)
-	}(&wg, resultChan)
-	for result := range resultChan {
-		results[result.repo] = result.body
-	}
-	return results
+	pageTmpl   *template.Template
+	bodyHeader *template.Template
+	bodyFooter *template.Template
+
+	allowUnsignedPing bool
+	requireBothSigs   bool
+	streamVerify      bool
+	checkSigLength    bool
+	forkFallback      bool
+	skipEmpty         bool
+	skipBadRenders    bool
+	readmeChangesOnly bool
+	headingAnchors    bool
+	stripLeadingH1    bool
+	absoluteLinks     bool
+	tocMarker         string
+	splitLevel        int
+	emoji             string
+	validateLinks     bool
+	altTextPolicy     string
+	deadLinks         string
+	charsetMeta       string
+	linkSlots         chan struct{}
+	linkCache         *linkCache
+	// linkHost is the host that VALIDATE_LINKS checks links on, along with
+	// its subdomains, with linkClient.
+	linkHost          string
+	linkClient        *http.Client
+	readyTimeout      time.Duration
+	exitAfterFailures int
+	cache             store
+	cacheMaxAge       time.Duration
+	skipUnchanged     bool
+	allowStale        bool
+	dateFormat        string
+	parallelBuilds    bool
+	syncConcurrency   int
+	pipeline          bool
+	progressEvery     int
+	progressBytes     int64
+	verifyWrites      bool
+	embedContentHash  bool
+	dedupe            string
+	repoMetadata      bool
+	repoLanguages     bool
+	staticMetadata    map[string]map[string]interface{}
+	allowOverrides    []string
+
+	publishDir      string
+	precompress     bool
+	precompressExts []string
+	reposJSON       string
+
+	notifier *notifier
+	// With BUILD_BATCH_WINDOW set, builds requested within the window of
+	// each other are batched into one, and deliveries are answered as
+	// soon as they've joined the batch.
+	batcher *batcher
+
+	// With COALESCE_SYNC_ALL set, overlapping sync-all requests share
+	// their fetches and their build.
+	fetches        *fetchGroup
+	syncAllBatcher *batcher
+
+	// With BUILD_ASYNC set, deliveries are answered with a 202 as soon as
+	// their build is queued, instead of once it's finished, and builds
+	// run one at a time on worker.
+	worker *buildWorker
+	// waitForBuilds holds off answering every delivery until its build
+	// has finished, even with BUILD_ASYNC or BUILD_BATCH_WINDOW set.
+	waitForBuilds bool
+
+	// With PAYLOAD_STATS set, payload sizes are logged, with a warning
+	// for any that are out of the ordinary.
+	payloads *payloadStats
+
+	// metrics is nil unless METRICS is set.
+	metrics *metrics
+
+	// With QUEUE_DIR set, deliveries are queued on disk once they've been
+	// verified, and processed from there.
+	queue *deliveryQueue
+
+	purgeURL      string
+	purgeAuth     string
+	purgeProvider string
+	purgeBaseURL  string
+
+	branchFromAPI bool
+	branches      *branchCache
+
+	useRawHost bool
+	rawHost    string
+
+	syncTags    bool
+	tagPageTmpl *template.Template
+
+	installationEvents bool
+	installationSync   bool
+	installationRemove bool
+	active             *repoSet
+
+	allowedSenders []string
+	deniedSenders  []string
 }
 
-func verifyWebhook(mac, body, secret []byte) (bool, error) {
-	h := hmac.New(sha1.New, secret)
-	_, err := h.Write(body)
-	if err != nil {
-		return false, err
-	}
-	expectedMac := hex.EncodeToString(h.Sum(nil))
-	return hmac.Equal(mac, []byte(expectedMac)), nil
+// handledEvents are the events ServeHTTP always acts on. Installation
+// events are handled too, but only when INSTALLATION_EVENTS is set.
+var handledEvents = map[string]bool{
+	"push":     true,
+	"ping":     true,
+	"sync-all": true,
 }
 
 type request struct {
-	Ref        string `json:"ref"`
+	Ref        string   `json:"ref"`
+	After      string   `json:"after"`
+	Commits    []commit `json:"commits"`
 	Repository struct {
-		Name string `json:"name"`
-		URL  string `json:"url"`
+		Name     string `json:"name"`
+		FullName string `json:"full_name"`
+		URL      string `json:"url"`
+		Owner    struct {
+			Login string `json:"login"`
+		} `json:"owner"`
 	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
 	Repos []string `json:"repos"`
 }
 
+// secretFor picks the webhook secret for the repo named in the (not yet
+// verified) payload, trying the full name, the repo name, then the owner,
+// before falling back to the global secret.
+func (e env) secretFor(body []byte) []byte {
+	if len(e.repoSecrets) == 0 {
+		return e.hookSecret
+	}
+	var req request
+	if json.Unmarshal(body, &req) != nil {
+		return e.hookSecret
+	}
+	for _, key := range []string{req.Repository.FullName, req.Repository.Name, req.Repository.Owner.Login} {
+		if secret, ok := e.repoSecrets[key]; ok && key != "" {
+			return []byte(secret)
+		}
+	}
+	return e.hookSecret
+}
+
 func (e env) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	event := r.Header.Get("X-Github-Event")
-	if event != "push" && event != "ping" && event != "sync-all" {
+	forge := providerFor(r, e.requireBothSigs)
+	event := forge.event(r)
+	audit := auditEntry{Time: time.Now(), Delivery: deliveryID(r), Event: event, Remote: r.RemoteAddr}
+	if e.audit != nil {
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		w = rec
+		defer func() {
+			audit.Status = rec.code
+			if err := e.audit.record(audit); err != nil {
+				log.Println("audit:", err)
+			}
+		}()
+	}
+	installation := event == "installation" || event == "installation_repositories"
+	if !handledEvents[event] && !(installation && e.installationEvents) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	e.metrics.webhook(event)
 
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if event == "ping" && e.allowUnsignedPing {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("pong"))
 		return
 	}
 
-	ok, err := verifyWebhook([]byte(r.Header.Get("X-Hub-Signature")[5:]), body, e.hookSecret)
+	if lc, ok := forge.verifier(e.hookSecret).(lengthChecker); ok && e.checkSigLength {
+		if err := lc.checkLength(r); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	// With a single secret, the signature can be checked while the body
+	// is read, rather than hashing it again afterwards. Per-repo secrets
+	// depend on the body, so they can't.
+	var check func() bool
+	var src io.Reader = r.Body
+	if e.maxBodyBytes > 0 {
+		src = http.MaxBytesReader(w, r.Body, e.maxBodyBytes)
+	}
+	sv, streaming := forge.verifier(e.hookSecret).(streamVerifier)
+	if streaming && e.streamVerify && len(e.repoSecrets) == 0 {
+		var sink io.Writer
+		sink, check = sv.stream(r)
+		src = io.TeeReader(src, sink)
+	}
+	raw, err := ioutil.ReadAll(src)
+	if _, ok := err.(*http.MaxBytesError); ok {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	body, err := forge.normalize(raw)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var ok bool
+	if check != nil {
+		ok = check()
+	} else {
+		ok, err = forge.verifier(e.secretFor(body)).verify(r, raw)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
 	if !ok {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	if e.payloads != nil {
+		e.payloads.observe(event, len(raw))
+	}
+
 	if event == "ping" {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("pong"))
 		return
 	}
 
+	err = validateRequest(event, body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if e.queue != nil {
+		var req request
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		audit.Sender = req.Sender.Login
+		err = e.queue.enqueue(queuedDelivery{Event: event, Delivery: deliveryID(r), URL: r.URL.String(), Repo: req.Repository.FullName, Body: body})
+		if err != nil {
+			log.Println("queue:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"queued":true}`))
+		return
+	}
+	e.handle(w, r, event, body, &audit)
+}
+
+// handle processes a delivery of event that's been verified and
+// validated, whether it's only just arrived, or been queued and is being
+// replayed. What it finds out about the delivery is added to audit.
+func (e env) handle(w http.ResponseWriter, r *http.Request, event string, body []byte, audit *auditEntry) {
 	var req request
-	err = json.Unmarshal(body, &req)
+	err := json.Unmarshal(body, &req)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	audit.Sender = req.Sender.Login
+
+	if event != "sync-all" && !e.senderAllowed(req.Sender.Login) {
+		log.Println("ignoring " + event + " sent by " + req.Sender.Login)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// finish records how the delivery went, so retries of it can be
+	// answered without another build. A build left running in the
+	// background takes it over, and calls it once it knows.
+	var finish func(code int)
+	if event == "push" && req.After != "" && e.deliveries != nil {
+		var code int
+		finish, code = e.deliveries.join(req.Repository.FullName + "@" + req.After)
+		if finish == nil {
+			log.Println(req.Repository.FullName + ": already handled " + req.After)
+			w.WriteHeader(code)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		defer func() {
+			if finish != nil {
+				finish(rec.code)
+			}
+		}()
+		w = rec
+	}
 
 	var readmes map[string][]byte
+	var stream <-chan result
+	var removed []string
+	failed := map[string]error{}
+	versions := map[string]tagPage{}
+	total := 0
+	start := time.Now()
 	if event == "sync-all" {
-		readmes = syncAll(req.Repos, e.githubToken)
+		repos := make([]string, 0, len(req.Repos))
+		for _, repo := range req.Repos {
+			name, err := e.resolveRepo(repo)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			repos = append(repos, name)
+		}
+		audit.Repos = repos
+		if e.pipeline {
+			stream, total = e.fetchAll(r.Context(), repos), len(repos)
+		} else {
+			readmes, failed = e.syncAll(r.Context(), repos)
+		}
+	} else if event == "installation" || event == "installation_repositories" {
+		var added []string
+		added, removed, err = installationChanges(event, body)
+		if err == nil {
+			err = checkRepoNames(added, removed)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		added, removed = e.repoNames(added), e.repoNames(removed)
+		audit.Repos = append(append([]string{}, added...), removed...)
+		e.active.add(added...)
+		e.active.remove(removed...)
+		if !e.installationSync {
+			added = nil
+		}
+		if !e.installationRemove {
+			removed = nil
+		}
+		if len(added) == 0 && len(removed) == 0 {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		readmes, failed = e.syncAll(r.Context(), added)
 	} else {
-		ref := strings.Split(req.Ref, "/")
-		if len(ref) != 3 {
+		owner := req.Repository.Owner.Login
+		if req.Repository.FullName != "" {
+			owner, _ = splitFullName(req.Repository.FullName)
+		}
+		audit.Repos = []string{owner + "/" + req.Repository.Name}
+		repo, ok := e.repoName(owner, req.Repository.Name)
+		if !ok {
+			log.Println(owner + "/" + req.Repository.Name + ": not one of GITHUB_ORGS, ignoring push")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		kind, name, ok := parseRef(req.Ref)
+		if !ok {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		branch := ref[2]
-		if branch != "master" {
+		if kind == refTag && !e.syncTags {
+			log.Println(repo + ": ignoring push of tag " + name)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if kind == refBranch {
+			branch, err := e.defaultBranch(r.Context(), repo)
+			if err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if name != branch {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		if kind == refBranch && e.readmeChangesOnly && r.URL.Query().Get("force") != "1" && !touchesReadme(req.Commits) {
+			log.Println(repo + ": README not changed by push, skipping")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if e.installationEvents && !e.active.has(repo) {
+			log.Println(repo + ": not in the active set, ignoring push")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		page, ref := repo, ""
+		if kind == refTag {
+			page, err = e.tagPageName(repo, name)
+			if err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			ref = name
+			versions[page] = tagPage{repo: repo, tag: name}
+		}
+		readme, err := e.readme(r.Context(), repo, ref)
+		if err == errEmptyRepo {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
-		readme, err := pullReadme(req.Repository.Name, e.githubToken)
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		readmes = map[string][]byte{req.Repository.Name: readme}
+		readmes = map[string][]byte{page: readme}
 	}
 
-	for repo, readme := range readmes {
-		f, err := os.Create(filepath.Join(e.hugoSource, e.dir, repo+".md"))
+	if stream == nil {
+		if e.dedupe != "" {
+			dedupe(readmes, e.dedupe)
+		}
+		stream, total = resultsOf(readmes), len(readmes)
+	}
+	force := r.URL.Query().Get("force") == "1"
+	written := map[string][]byte{}
+	var bytesWritten int64
+	var synced []string
+	for fetched := range stream {
+		if fetched.err != nil {
+			failed[fetched.repo] = fetched.err
+			continue
+		}
+		hookRepo, ref := fetched.repo, ""
+		if v, ok := versions[fetched.repo]; ok {
+			hookRepo, ref = v.repo, v.tag
+		}
+		hooked, err := e.runRepoHook(hookRepo, fetched.body)
+		if err != nil {
+			log.Println(err)
+			failed[fetched.repo] = err
+			continue
+		}
+		synced = append(synced, fetched.repo)
+		repo, readme := fetched.repo, e.transform(r.Context(), hookRepo, ref, hooked)
+		if e.skipUnchanged && !force && e.unchanged(repo, readme) {
+			log.Println(repo + ": README unchanged, skipping")
+			continue
+		}
+		if e.altTextPolicy != "" {
+			missing := missingAltText(readme)
+			for _, src := range missing {
+				log.Println(repo + ": image " + src + " has no alt text")
+			}
+			if len(missing) > 0 && e.altTextPolicy == altTextFail {
+				failed[repo] = altTextError{repo: repo, missing: missing}
+				synced = synced[:len(synced)-1]
+				continue
+			}
+		}
+		data := e.newPageData(repo, readme)
+		if v, ok := versions[repo]; ok {
+			data.Repo, data.Version = v.repo, v.tag
+		}
+		err = e.completePageData(r.Context(), &data, fetched.body)
+		var n int64
+		if err == nil {
+			n, err = e.writePage(repo, data)
+		}
+		if err != nil && e.skipBadRenders && renderFailed(err) {
+			log.Println(repo+": couldn't render, skipping:", err)
+			failed[repo] = err
+			synced = synced[:len(synced)-1]
+			continue
+		}
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		defer f.Close()
-		err = tmpl.Execute(f, struct {
-			Name   string
-			Readme string
-			Date   string
-		}{
-			Name:   repo,
-			Readme: string(readme),
-			Date:   time.Now().Format(time.RFC3339),
-		})
-		if err != nil {
+		written[repo] = readme
+		e.active.add(hookRepo)
+		bytesWritten += n
+		logBatchProgress(len(written), total, e.progressEvery)
+	}
+	if total > 1 {
+		log.Println("fetched and wrote " + strconv.Itoa(len(written)) + " READMEs in " + time.Since(start).String())
+	}
+	e.rateLimit.log()
+	if len(written) == 0 && len(removed) == 0 {
+		writeSyncSummary(w, event, synced, failed, http.StatusOK)
+		return
+	}
+	defer e.index.invalidate()
+	for _, repo := range removed {
+		err = os.Remove(filepath.Join(e.hugoSource, e.dir, repo+".md"))
+		if err == nil || os.IsNotExist(err) {
+			err = os.RemoveAll(filepath.Join(e.hugoSource, e.dir, repo))
+		}
+		if err != nil && !os.IsNotExist(err) {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 	}
-	cmd := exec.Command(e.hugoCmd)
-	cmd.Dir = e.hugoSource
-	output, err := cmd.CombinedOutput()
+	repos := make([]string, 0, len(written)+len(removed))
+	for repo := range written {
+		if v, ok := versions[repo]; ok {
+			repo = v.repo
+		}
+		repos = append(repos, repo)
+	}
+	repos = append(repos, removed...)
+	sort.Strings(repos)
+	published := func() {
+		for repo, readme := range written {
+			e.recordContent(repo, readme)
+		}
+		if e.purgeURL != "" {
+			pages := make([]string, 0, len(written)+len(removed))
+			for page := range written {
+				pages = append(pages, page)
+			}
+			pages = append(pages, removed...)
+			sort.Strings(pages)
+			if err := e.purge(pages); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	batched := e.batcher != nil && !(event == "sync-all" && e.syncAllBatcher != nil)
+	build := func() error {
+		var err error
+		if event == "sync-all" && e.syncAllBatcher != nil {
+			_, err = e.syncAllBatcher.build(event, repos, bytesWritten)
+		} else if batched {
+			_, err = e.batcher.build(event, repos, bytesWritten)
+		} else if e.worker != nil {
+			_, err = e.worker.build(event, repos, bytesWritten)
+		} else {
+			_, err = e.buildAndReport(event, repos, bytesWritten)
+		}
+		if err != nil {
+			return err
+		}
+		published()
+		return nil
+	}
+	// built takes over finish for a build that's still to run once the
+	// delivery has been answered.
+	built := func() func(error) {
+		finishBuild := finish
+		finish = nil
+		return func(err error) {
+			if finishBuild == nil {
+				return
+			}
+			code := http.StatusOK
+			if err != nil {
+				code = http.StatusInternalServerError
+			}
+			finishBuild(code)
+		}
+	}
+	code := http.StatusOK
+	switch {
+	case e.waitForBuilds || !batched && e.worker == nil:
+		if err := build(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	case batched:
+		// The delivery is answered as soon as it's joined the batch,
+		// rather than once the batch's window closes and it's built.
+		done := built()
+		e.batcher.add(event, repos, bytesWritten, func(_ buildReport, err error) {
+			if err == nil {
+				published()
+			}
+			done(err)
+		})
+	default:
+		// The build's outcome is still reported and notified, just not
+		// to whoever sent the delivery.
+		done := built()
+		e.worker.background(func() error {
+			err := build()
+			done(err)
+			return err
+		})
+		code = http.StatusAccepted
+	}
+	writeSyncSummary(w, event, synced, failed, code)
+}
+
+type syncSummary struct {
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Synced    []string          `json:"synced"`
+	Errors    map[string]string `json:"errors"`
+}
+
+// writeSyncSummary responds to a request that got as far as syncing. For
+// sync-all, the body says which repos were synced and which failed; a push
+// is an error if its repo failed, a 422 if that's because of missing alt
+// text. Otherwise, the response has code.
+func writeSyncSummary(w http.ResponseWriter, event string, synced []string, failed map[string]error, code int) {
+	if event == "push" && len(failed) > 0 {
+		for _, err := range failed {
+			var altErr altTextError
+			if errors.As(err, &altErr) {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if event != "sync-all" {
+		w.WriteHeader(code)
+		return
+	}
+	summary := syncSummary{
+		Succeeded: len(synced),
+		Failed:    len(failed),
+		Synced:    synced,
+		Errors:    map[string]string{},
+	}
+	sort.Strings(summary.Synced)
+	for repo, err := range failed {
+		summary.Errors[repo] = err.Error()
+	}
+	b, err := json.Marshal(summary)
 	if err != nil {
-		log.Println(err)
-		log.Println(string(output))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	log.Println(string(output))
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
 }
 
 func health(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("ok"))
 }
 
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func loadJSONFile(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func validateDateFormat(layout string) error {
+	formatted := time.Date(2009, 11, 10, 23, 4, 5, 0, time.UTC).Format(layout)
+	if formatted == layout {
+		return errors.New("layout contains no date or time elements")
+	}
+	_, err := time.Parse(layout, formatted)
+	return err
+}
+
+func intEnv(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		log.Println(key + " must be a non-negative integer.")
+		os.Exit(1)
+	}
+	return i
+}
+
+// templateFailed reports a custom template that couldn't be loaded. With
+// TEMPLATE_FALLBACK set, the caller carries on with the built-in template
+// instead; otherwise, it exits.
+func templateFailed(msg string, err error) {
+	log.Println(msg, err)
+	if os.Getenv("TEMPLATE_FALLBACK") != "true" {
+		os.Exit(1)
+	}
+	log.Println("TEMPLATE_FALLBACK is set, using the built-in template instead.")
+}
+
+func durationEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Println(key + " must be a positive duration, like \"5s\".")
+		os.Exit(1)
+	}
+	return d
+}
+
 func main() {
 	environment := env{
 		dir:         os.ExpandEnv(os.Getenv("OUTPUT_DIR")),
 		hookSecret:  []byte(os.Getenv("WEBHOOK_SECRET")),
+		githubAPI:   strings.TrimRight(os.Getenv("GITHUB_API_URL"), "/"),
 		githubToken: os.Getenv("GITHUB_TOKEN"),
+		maxAttempts: intEnv("GITHUB_MAX_ATTEMPTS", 3),
+		retryDelay:  durationEnv("GITHUB_RETRY_DELAY", 500*time.Millisecond),
+		rateLimit:   &rateLimit{},
+		orgs:        splitList(os.Getenv("GITHUB_ORGS")),
 		hugoCmd:     os.ExpandEnv(os.Getenv("HUGO_CMD")),
 		hugoSource:  os.ExpandEnv(os.Getenv("HUGO_SOURCE")),
+		hugoConfig:  os.ExpandEnv(os.Getenv("HUGO_CONFIG")),
+		siteLocks:   newKeyedMutex(),
+		reports:     &reportKeeper{},
+		activity:    &activity{},
+		reportPath:  os.ExpandEnv(os.Getenv("BUILD_REPORT_PATH")),
+		adminToken:  os.Getenv("ADMIN_TOKEN"),
+
+		previewDir:     os.ExpandEnv(os.Getenv("PREVIEW_DIR")),
+		previewBaseURL: os.Getenv("PREVIEW_BASE_URL"),
+		previewTTL:     durationEnv("PREVIEW_TTL", time.Hour),
+
+		allowUnsignedPing: os.Getenv("ALLOW_UNSIGNED_PING") == "true",
+		requireBothSigs:   os.Getenv("REQUIRE_BOTH_SIGNATURES") == "true",
+		streamVerify:      os.Getenv("STREAM_VERIFY") == "true",
+		checkSigLength:    os.Getenv("CHECK_SIGNATURE_LENGTH") == "true",
+		forkFallback:      os.Getenv("FORK_README_FALLBACK") == "true",
+		skipEmpty:         os.Getenv("SKIP_EMPTY_REPOS") == "true",
+		skipBadRenders:    os.Getenv("SKIP_RENDER_FAILURES") == "true",
+		readmeChangesOnly: os.Getenv("README_CHANGES_ONLY") == "true",
+		headingAnchors:    os.Getenv("HEADING_ANCHORS") == "true",
+		stripLeadingH1:    os.Getenv("STRIP_LEADING_H1") == "true",
+		absoluteLinks:     os.Getenv("ABSOLUTE_LINKS") == "true",
+		emoji:             os.Getenv("EMOJI_SHORTCODES"),
+		validateLinks:     os.Getenv("VALIDATE_LINKS") == "true",
+		altTextPolicy:     os.Getenv("ALT_TEXT_POLICY"),
+		deadLinks:         os.Getenv("DEAD_LINKS"),
+		charsetMeta:       os.Getenv("CHARSET_META"),
+		readyTimeout:      durationEnv("READY_TIMEOUT", 5*time.Second),
+		cacheMaxAge:       durationEnv("CACHE_MAX_AGE", 0),
+		skipUnchanged:     os.Getenv("SKIP_UNCHANGED") == "true",
+		allowStale:        os.Getenv("ALLOW_STALE") == "true",
+		dateFormat:        os.Getenv("DATE_FORMAT"),
+		parallelBuilds:    os.Getenv("PARALLEL_BUILDS") == "true",
+		syncConcurrency:   intEnv("SYNC_CONCURRENCY", 8),
+		exitAfterFailures: intEnv("EXIT_AFTER_FAILURES", 0),
+		pipeline:          os.Getenv("PIPELINE_SYNC") == "true",
+		progressEvery:     intEnv("PROGRESS_EVERY", 0),
+		progressBytes:     int64(intEnv("PROGRESS_BYTES", 0)),
+		verifyWrites:      os.Getenv("VERIFY_WRITES") == "true",
+		embedContentHash:  os.Getenv("EMBED_CONTENT_HASH") == "true",
+		dedupe:            os.Getenv("DEDUPE_READMES"),
+		repoMetadata:      os.Getenv("REPO_METADATA") == "true",
+		repoLanguages:     os.Getenv("REPO_LANGUAGES") == "true",
+		allowOverrides:    splitList(os.Getenv("ALLOW_FRONT_MATTER_OVERRIDES")),
+
+		publishDir:      os.ExpandEnv(os.Getenv("PUBLISH_DIR")),
+		precompress:     os.Getenv("PRECOMPRESS") == "true",
+		precompressExts: splitList(os.Getenv("PRECOMPRESS_EXTENSIONS")),
+
+		purgeURL:      os.Getenv("CDN_PURGE_URL"),
+		purgeAuth:     os.Getenv("CDN_PURGE_AUTH"),
+		purgeProvider: os.Getenv("CDN_PURGE_PROVIDER"),
+		purgeBaseURL:  os.Getenv("CDN_BASE_URL"),
+
+		branchFromAPI: os.Getenv("DEFAULT_BRANCH_FROM_API") == "true",
+		branches:      newBranchCache(durationEnv("DEFAULT_BRANCH_TTL", 10*time.Minute)),
+
+		useRawHost: os.Getenv("USE_RAW_HOST") == "true",
+		rawHost:    strings.TrimRight(os.Getenv("RAW_HOST_URL"), "/"),
+
+		syncTags:    os.Getenv("SYNC_TAGS") == "true",
+		tagPageTmpl: template.Must(template.New("tag page").Parse(defaultTagPageName)),
+
+		installationEvents: os.Getenv("INSTALLATION_EVENTS") == "true",
+		installationSync:   os.Getenv("INSTALLATION_SYNC") == "true",
+		installationRemove: os.Getenv("INSTALLATION_REMOVE") == "true",
+
+		allowedSenders: splitList(os.Getenv("ALLOWED_SENDERS")),
+		deniedSenders:  splitList(os.Getenv("DENIED_SENDERS")),
 	}
 	if environment.hookSecret == nil || len(environment.hookSecret) < 1 {
 		log.Println("WEBHOOK_SECRET must be set to the secret used to verify webhook requests.")
@@ -233,6 +947,51 @@ func main() {
 		log.Println("GITHUB_TOKEN must be set to a personal access token for Github.")
 		os.Exit(1)
 	}
+	if environment.publishDir == "" {
+		environment.publishDir = filepath.Join(environment.hugoSource, "public")
+	}
+	if len(environment.precompressExts) == 0 {
+		environment.precompressExts = []string{".html", ".css", ".js", ".xml", ".svg", ".json"}
+	}
+	if environment.githubAPI == "" {
+		environment.githubAPI = "https://api.github.com"
+	}
+	if u, err := url.Parse(environment.githubAPI); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Println("GITHUB_API_URL must be an http or https URL, like \"https://github.example.com/api/v3\".")
+		os.Exit(1)
+	}
+	if environment.rawHost == "" {
+		environment.rawHost = "https://raw.githubusercontent.com"
+	}
+	if u, err := url.Parse(environment.rawHost); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Println("RAW_HOST_URL must be an http or https URL.")
+		os.Exit(1)
+	}
+	// Raw host URLs need the real branch, which isn't always master.
+	if environment.useRawHost {
+		environment.branchFromAPI = true
+	}
+	if environment.syncConcurrency < 1 {
+		log.Println("SYNC_CONCURRENCY must be at least 1.")
+		os.Exit(1)
+	}
+	if environment.maxAttempts < 1 {
+		log.Println("GITHUB_MAX_ATTEMPTS must be at least 1.")
+		os.Exit(1)
+	}
+	environment.requestTimeout = durationEnv("REQUEST_TIMEOUT", 30*time.Second)
+	environment.maxBodyBytes = int64(intEnv("MAX_BODY_BYTES", 25<<20))
+	if owner := os.Getenv("GITHUB_OWNER"); owner != "" {
+		if len(environment.orgs) > 0 {
+			log.Println("Only one of GITHUB_OWNER and GITHUB_ORGS can be set.")
+			os.Exit(1)
+		}
+		environment.orgs = []string{owner}
+	}
+	if len(environment.orgs) == 0 {
+		log.Println("GITHUB_OWNER must be set to the user or org that owns the repos, or GITHUB_ORGS to a list of them.")
+		os.Exit(1)
+	}
 	if environment.hugoCmd == "" {
 		log.Println("HUGO_CMD must be set to the path to the hugo command.")
 		os.Exit(1)
@@ -245,10 +1004,328 @@ func main() {
 		log.Println("OUTPUT_DIR must be set to the directory within " + environment.hugoSource + " to store the project READMEs in.")
 		os.Exit(1)
 	}
+	if environment.dateFormat == "" {
+		environment.dateFormat = time.RFC3339
+	}
+	if err := validateDateFormat(environment.dateFormat); err != nil {
+		log.Println("DATE_FORMAT must be a Go time layout, like \"2006-01-02T15:04:05Z07:00\":", err)
+		os.Exit(1)
+	}
+	if environment.dedupe != "" && environment.dedupe != dedupeSkip && environment.dedupe != dedupeLink {
+		log.Println("DEDUPE_READMES must be \"skip\" or \"link\" if set.")
+		os.Exit(1)
+	}
+	if environment.emoji != "" && environment.emoji != emojiConvert && environment.emoji != emojiStrip {
+		log.Println("EMOJI_SHORTCODES must be \"convert\" or \"strip\" if set.")
+		os.Exit(1)
+	}
+	if os.Getenv("BUILD_SECTION_ONLY") == "true" {
+		section := environment.contentSection()
+		if section == "" || section == "." || environment.hugoConfig == "" {
+			log.Println("BUILD_SECTION_ONLY needs OUTPUT_DIR to be a section under content and HUGO_CONFIG to be set.")
+			os.Exit(1)
+		}
+		path, err := writeSegmentConfig(section)
+		if err != nil {
+			log.Println("Unable to write the Hugo segment config for BUILD_SECTION_ONLY:", err)
+			os.Exit(1)
+		}
+		environment.segmentConfig = path
+	}
+	if os.Getenv("SPLIT_README") == "true" {
+		environment.splitLevel = intEnv("SPLIT_LEVEL", 2)
+		if environment.splitLevel < 1 || environment.splitLevel > 6 {
+			log.Println("SPLIT_LEVEL must be a heading level from 1 to 6.")
+			os.Exit(1)
+		}
+	}
+	if os.Getenv("TABLE_OF_CONTENTS") == "true" {
+		environment.tocMarker = os.Getenv("TOC_MARKER")
+		if environment.tocMarker == "" {
+			environment.tocMarker = defaultTOCMarker
+		}
+	}
+	if environment.altTextPolicy != "" && environment.altTextPolicy != altTextWarn && environment.altTextPolicy != altTextFail {
+		log.Println("ALT_TEXT_POLICY must be \"warn\" or \"fail\" if set.")
+		os.Exit(1)
+	}
+	if environment.deadLinks == "" {
+		environment.deadLinks = deadLinksAnnotate
+	}
+	if environment.deadLinks != deadLinksAnnotate && environment.deadLinks != deadLinksDrop {
+		log.Println("DEAD_LINKS must be \"annotate\" or \"drop\" if set.")
+		os.Exit(1)
+	}
+	if environment.charsetMeta == "" {
+		environment.charsetMeta = charsetMetaStrip
+	}
+	if environment.charsetMeta != charsetMetaStrip && environment.charsetMeta != charsetMetaKeep {
+		log.Println("CHARSET_META must be \"strip\" or \"keep\" if set.")
+		os.Exit(1)
+	}
+	linkChecks := intEnv("LINK_CHECK_CONCURRENCY", 4)
+	if linkChecks < 1 {
+		log.Println("LINK_CHECK_CONCURRENCY must be at least 1.")
+		os.Exit(1)
+	}
+	environment.linkSlots = make(chan struct{}, linkChecks)
+	// Links on GitHub are checked, whether that's github.com or the GitHub
+	// Enterprise host the API is on.
+	if u, err := url.Parse(environment.githubAPI); err == nil {
+		environment.linkHost = strings.TrimPrefix(strings.ToLower(u.Hostname()), "api.")
+	}
+	environment.linkClient = newLinkClient(environment.linkHost)
+	environment.linkCache = newLinkCache(durationEnv("LINK_CACHE_TTL", time.Hour))
+	if environment.pipeline && environment.dedupe != "" {
+		log.Println("DEDUPE_READMES needs every README before writing, so it can't be used with PIPELINE_SYNC.")
+		os.Exit(1)
+	}
+	switch environment.purgeProvider {
+	case "":
+		environment.purgeProvider = purgeGeneric
+	case purgeGeneric:
+	case purgeCloudflare:
+		if environment.purgeBaseURL == "" {
+			log.Println("CDN_BASE_URL must be set to the site's public URL to purge Cloudflare.")
+			os.Exit(1)
+		}
+	default:
+		log.Println("CDN_PURGE_PROVIDER must be \"generic\" or \"cloudflare\" if set.")
+		os.Exit(1)
+	}
+	if name := os.Getenv("TAG_PAGE_NAME"); name != "" {
+		t, err := template.New("tag page").Parse(name)
+		if err != nil {
+			templateFailed("TAG_PAGE_NAME must be a template for versioned page names, like \"{{ .Repo }}-{{ .Tag }}\":", err)
+		} else {
+			environment.tagPageTmpl = t
+		}
+	}
+	active, err := newRepoSet(filepath.Join(environment.hugoSource, environment.dir))
+	if err != nil {
+		log.Println("Unable to list the repos already in "+environment.dir+":", err)
+		os.Exit(1)
+	}
+	environment.active = active
+	environment.pageTmpl = tmpl
+	if path := os.ExpandEnv(os.Getenv("TEMPLATE_PATH")); path != "" {
+		t, err := loadPageTemplate(path)
+		if err != nil {
+			templateFailed("TEMPLATE_PATH must be the path to a template for each README's page:", err)
+		} else {
+			environment.pageTmpl = t
+		}
+	}
+	environment.bodyHeader, err = loadBodyTemplate("header", os.ExpandEnv(os.Getenv("BODY_HEADER_TEMPLATE")))
+	if err != nil {
+		templateFailed("BODY_HEADER_TEMPLATE must be the path to a template for the text above each README:", err)
+	}
+	environment.bodyFooter, err = loadBodyTemplate("footer", os.ExpandEnv(os.Getenv("BODY_FOOTER_TEMPLATE")))
+	if err != nil {
+		templateFailed("BODY_FOOTER_TEMPLATE must be the path to a template for the text below each README:", err)
+	}
+	if path := os.ExpandEnv(os.Getenv("AUDIT_LOG")); path != "" {
+		environment.audit, err = openAuditLog(path)
+		if err != nil {
+			log.Println("AUDIT_LOG must be the path to a file to append audit records to:", err)
+			os.Exit(1)
+		}
+	}
+	if os.Getenv("COALESCE_DELIVERIES") == "true" {
+		environment.deliveries = newCoalescer(durationEnv("COALESCE_TTL", 10*time.Minute))
+	}
+	environment.index = &repoIndex{
+		ttl:     durationEnv("REPOS_CACHE_TTL", 30*time.Second),
+		workers: intEnv("SCAN_WORKERS", 8),
+	}
+	if environment.index.workers < 1 {
+		log.Println("SCAN_WORKERS must be at least 1.")
+		os.Exit(1)
+	}
+	if redisURL := os.Getenv("CACHE_REDIS_URL"); redisURL != "" {
+		cache, err := newRedisStore(redisURL)
+		if err != nil {
+			log.Println("CACHE_REDIS_URL must be a redis:// URL:", err)
+			os.Exit(1)
+		}
+		cache.ttl = environment.cacheMaxAge
+		environment.cache = cache
+	} else if cacheDir := os.ExpandEnv(os.Getenv("CACHE_DIR")); cacheDir != "" {
+		err = os.MkdirAll(cacheDir, 0755)
+		if err != nil {
+			log.Println("CACHE_DIR must be a directory readmesync can write to:", err)
+			os.Exit(1)
+		}
+		environment.cache = fileStore{dir: cacheDir}
+	}
+	if os.Getenv("WARM_CACHE") == "true" {
+		if environment.cache == nil {
+			log.Println("WARM_CACHE requires CACHE_DIR or CACHE_REDIS_URL to be set.")
+			os.Exit(1)
+		}
+		go environment.warmCache()
+	}
+	if environment.cache != nil && environment.cacheMaxAge > 0 {
+		go environment.sweepCache(durationEnv("CACHE_SWEEP_INTERVAL", time.Hour))
+	}
+	if environment.skipUnchanged && environment.cache == nil {
+		log.Println("SKIP_UNCHANGED requires CACHE_DIR or CACHE_REDIS_URL to be set.")
+		os.Exit(1)
+	}
+	if environment.allowStale && environment.cache == nil {
+		log.Println("ALLOW_STALE requires CACHE_DIR or CACHE_REDIS_URL to be set.")
+		os.Exit(1)
+	}
+	if path := os.ExpandEnv(os.Getenv("WEBHOOK_SECRETS_FILE")); path != "" {
+		err := loadJSONFile(path, &environment.repoSecrets)
+		if err != nil {
+			log.Println("WEBHOOK_SECRETS_FILE must be the path to a JSON file mapping repos or orgs to webhook secrets:", err)
+			os.Exit(1)
+		}
+	}
+	if path := os.ExpandEnv(os.Getenv("GITHUB_TOKENS_FILE")); path != "" {
+		var tokens map[string]string
+		err := loadJSONFile(path, &tokens)
+		if err != nil {
+			log.Println("GITHUB_TOKENS_FILE must be the path to a JSON file mapping orgs to GitHub tokens:", err)
+			os.Exit(1)
+		}
+		environment.orgTokens = newOrgTokens(tokens)
+	}
+	if path := os.ExpandEnv(os.Getenv("METADATA_FILE")); path != "" {
+		err := loadJSONFile(path, &environment.staticMetadata)
+		if err != nil {
+			log.Println("METADATA_FILE must be the path to a JSON file mapping repos to front matter metadata:", err)
+			os.Exit(1)
+		}
+		err = checkReservedKeys(environment.staticMetadata, environment.allowOverrides)
+		if err != nil {
+			log.Println("METADATA_FILE sets front matter readmesync generates; list the keys in ALLOW_FRONT_MATTER_OVERRIDES to allow it:", err)
+			os.Exit(1)
+		}
+	}
+	if url := os.Getenv("NOTIFY_URL"); url != "" {
+		n := &notifier{url: url, contentType: os.Getenv("NOTIFY_CONTENT_TYPE")}
+		if n.contentType == "" {
+			n.contentType = "application/json"
+		}
+		n.success, err = loadNotifyTemplate("success", os.ExpandEnv(os.Getenv("NOTIFY_SUCCESS_TEMPLATE")), defaultSuccessNotification)
+		if err != nil {
+			templateFailed("NOTIFY_SUCCESS_TEMPLATE must be the path to a notification template:", err)
+			n.success, _ = loadNotifyTemplate("success", "", defaultSuccessNotification)
+		}
+		n.failure, err = loadNotifyTemplate("failure", os.ExpandEnv(os.Getenv("NOTIFY_FAILURE_TEMPLATE")), defaultFailureNotification)
+		if err != nil {
+			templateFailed("NOTIFY_FAILURE_TEMPLATE must be the path to a notification template:", err)
+			n.failure, _ = loadNotifyTemplate("failure", "", defaultFailureNotification)
+		}
+		environment.notifier = n
+	}
+	if path := os.ExpandEnv(os.Getenv("HUGO_CONFIG_MAP")); path != "" {
+		err := loadJSONFile(path, &environment.repoConfigs)
+		if err != nil {
+			log.Println("HUGO_CONFIG_MAP must be the path to a JSON file mapping repos to Hugo config files:", err)
+			os.Exit(1)
+		}
+	}
+	if path := os.ExpandEnv(os.Getenv("OUTPUT_DIRS_FILE")); path != "" {
+		err := loadJSONFile(path, &environment.outputDirs)
+		if err != nil {
+			log.Println("OUTPUT_DIRS_FILE must be the path to a JSON file mapping Hugo config files to output dirs:", err)
+			os.Exit(1)
+		}
+	}
+	if path := os.ExpandEnv(os.Getenv("REPO_HOOKS_FILE")); path != "" {
+		err := loadJSONFile(path, &environment.repoHooks)
+		if err != nil {
+			log.Println("REPO_HOOKS_FILE must be the path to a JSON file mapping repos to commands:", err)
+			os.Exit(1)
+		}
+	}
+	if os.Getenv("REPOS_JSON") == "true" {
+		environment.reposJSON = os.ExpandEnv(os.Getenv("REPOS_JSON_PATH"))
+		if environment.reposJSON == "" {
+			environment.reposJSON = filepath.Join(environment.publishDir, "repos.json")
+		}
+	}
+	if os.Getenv("METRICS") == "true" {
+		environment.metrics = newMetrics()
+		http.Handle("/metrics", environment.metrics)
+	}
+	run := environment.buildAndReport
+	if os.Getenv("BUILD_ASYNC") == "true" {
+		environment.worker = newBuildWorker(environment.buildAndReport)
+		run = environment.worker.build
+	}
+	if window := durationEnv("BUILD_BATCH_WINDOW", 0); window > 0 {
+		environment.batcher = &batcher{window: window, run: run}
+	}
+	if os.Getenv("PAYLOAD_STATS") == "true" {
+		environment.payloads = newPayloadStats(float64(intEnv("PAYLOAD_ANOMALY_STDDEVS", 3)))
+	}
+	if os.Getenv("COALESCE_SYNC_ALL") == "true" {
+		environment.fetches = newFetchGroup()
+		environment.syncAllBatcher = &batcher{window: durationEnv("SYNC_ALL_WINDOW", time.Second), run: run}
+	}
 	http.HandleFunc("/health", health)
-	http.Handle("/hook", environment)
-	err := http.ListenAndServe("0.0.0.0:9001", nil)
+	http.HandleFunc("/ready", environment.ready)
+	http.HandleFunc("/build-status", environment.buildStatus)
+	http.HandleFunc("/repos", environment.listRepos)
+	http.HandleFunc("/repos/", environment.diffRepo)
+	http.HandleFunc("/status", environment.status)
+	http.HandleFunc("/sync", environment.syncPattern)
+	if environment.previewDir != "" {
+		if environment.adminToken == "" {
+			log.Println("ADMIN_TOKEN must be set to use PREVIEW_DIR.")
+			os.Exit(1)
+		}
+		if err := environment.sweepPreviews(); err != nil {
+			log.Println("PREVIEW_DIR must be a directory to build previews in:", err)
+			os.Exit(1)
+		}
+		http.HandleFunc("/preview", environment.preview)
+	}
+	if os.Getenv("PREVIEW_HTML") == "true" {
+		if environment.adminToken == "" {
+			log.Println("ADMIN_TOKEN must be set to use PREVIEW_HTML.")
+			os.Exit(1)
+		}
+		http.HandleFunc("/preview/html", environment.previewHTML)
+	}
+	if dir := os.ExpandEnv(os.Getenv("QUEUE_DIR")); dir != "" {
+		queue, err := newDeliveryQueue(dir, intEnv("QUEUE_MAX_ATTEMPTS", 5), durationEnv("QUEUE_RETRY_DELAY", 10*time.Second))
+		if err != nil {
+			log.Println("QUEUE_DIR must be a directory to queue deliveries in:", err)
+			os.Exit(1)
+		}
+		if queue.attempts < 1 {
+			log.Println("QUEUE_MAX_ATTEMPTS must be at least 1.")
+			os.Exit(1)
+		}
+		environment.queue = queue
+		go environment.queue.run(environment.replay)
+	}
+	var hook http.Handler = environment
+	if limit := intEnv("MAX_CONCURRENT_HOOKS", 0); limit > 0 {
+		hook = limitConcurrency(hook, limit, durationEnv("HOOK_RETRY_AFTER", 30*time.Second))
+	}
+	http.Handle("/hook", hook)
+	addr, err := listenAddr(os.Getenv("LISTEN_ADDR"))
 	if err != nil {
+		log.Println("LISTEN_ADDR must be a host:port to listen on, like \"127.0.0.1:9001\" or \":9001\":", err)
+		os.Exit(1)
+	}
+	server := newServer(addr, http.DefaultServeMux, serverTimeouts{
+		readHeader: durationEnv("READ_HEADER_TIMEOUT", 5*time.Second),
+		read:       durationEnv("READ_TIMEOUT", 30*time.Second),
+		write:      durationEnv("WRITE_TIMEOUT", 5*time.Minute),
+		idle:       durationEnv("IDLE_TIMEOUT", 2*time.Minute),
+	})
+	stopped := make(chan struct{})
+	go shutdownOnSignal(server, durationEnv("SHUTDOWN_TIMEOUT", 5*time.Minute), stopped, environment.batcher, environment.syncAllBatcher, environment.worker)
+	err = server.ListenAndServe()
+	if err != http.ErrServerClosed {
 		panic(err)
 	}
+	<-stopped
 }
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+const testSecret = "secret"
+
+// fakeHugo writes a stand-in for the hugo command that appends its
+// arguments, one per line and followed by a blank line, to a log beside
+// it, and then runs script.
+func fakeHugo(t testing.TB, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hugo")
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\nprintf '%s\\n' \"$@\" \"\" >> \"$0.log\"\n"+script+"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// hugoRuns returns the arguments of each run of the fakeHugo at cmd.
+func hugoRuns(t *testing.T, cmd string) [][]string {
+	t.Helper()
+	b, err := ioutil.ReadFile(cmd + ".log")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs [][]string
+	args := []string{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		if line == "" {
+			runs = append(runs, args)
+			args = []string{}
+			continue
+		}
+		args = append(args, line)
+	}
+	return runs
+}
+
+// newTestEnv returns an env for a site in a temporary HUGO_SOURCE, with a
+// fakeHugo, syncing the darlinggo org from the API at githubURL.
+func newTestEnv(t testing.TB, githubURL string) env {
+	t.Helper()
+	source := t.TempDir()
+	return env{
+		githubAPI:       githubURL,
+		githubToken:     "token",
+		maxAttempts:     1,
+		retryDelay:      time.Millisecond,
+		rateLimit:       &rateLimit{},
+		orgs:            []string{"darlinggo"},
+		requestTimeout:  5 * time.Second,
+		hookSecret:      []byte(testSecret),
+		dir:             "content/project",
+		hugoCmd:         fakeHugo(t, ""),
+		hugoSource:      source,
+		siteLocks:       newKeyedMutex(),
+		reports:         &reportKeeper{},
+		index:           &repoIndex{ttl: time.Minute, workers: 2},
+		activity:        &activity{},
+		active:          &repoSet{repos: map[string]struct{}{}},
+		pageTmpl:        tmpl,
+		tagPageTmpl:     template.Must(template.New("tag page").Parse(defaultTagPageName)),
+		deadLinks:       deadLinksAnnotate,
+		charsetMeta:     charsetMetaStrip,
+		linkSlots:       make(chan struct{}, 4),
+		linkCache:       newLinkCache(time.Hour),
+		linkHost:        "127.0.0.1",
+		linkClient:      &http.Client{Timeout: 10 * time.Second},
+		dateFormat:      time.RFC3339,
+		syncConcurrency: 8,
+		publishDir:      filepath.Join(source, "public"),
+		branches:        newBranchCache(time.Minute),
+		rawHost:         "https://raw.githubusercontent.com",
+	}
+}
+
+// fakeGitHub is a stub of the GitHub API serving READMEs, with ETags, and
+// repo metadata, and recording the paths it was asked for.
+type fakeGitHub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	readmes  map[string]string
+	repos    map[string]string
+	requests []string
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	g := &fakeGitHub{readmes: map[string]string{}, repos: map[string]string{}}
+	g.Server = httptest.NewServer(http.HandlerFunc(g.serve))
+	t.Cleanup(g.Close)
+	return g
+}
+
+// setReadme makes fullName's README readme, and gives it a repo with a
+// default branch of master unless it already has one. The README at a ref
+// is set with a fullName of "owner/repo@ref".
+func (g *fakeGitHub) setReadme(fullName, readme string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.readmes[fullName] = readme
+	if _, ok := g.repos[fullName]; !ok && !strings.Contains(fullName, "@") {
+		g.repos[fullName] = `{"default_branch": "master", "size": 1}`
+	}
+}
+
+// setRepo makes the repo API's response for fullName body.
+func (g *fakeGitHub) setRepo(fullName, body string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.repos[fullName] = body
+}
+
+func (g *fakeGitHub) requested() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string{}, g.requests...)
+}
+
+func (g *fakeGitHub) serve(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.requests = append(g.requests, r.URL.RequestURI())
+	if org := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/orgs/"), "/repos"); org != r.URL.Path {
+		var repos []map[string]string
+		for fullName := range g.repos {
+			if owner, name := splitFullName(fullName); owner == org && r.URL.Query().Get("page") == "1" {
+				repos = append(repos, map[string]string{"name": name})
+			}
+		}
+		sort.Slice(repos, func(i, j int) bool { return repos[i]["name"] < repos[j]["name"] })
+		b, _ := json.Marshal(repos)
+		w.Write(b)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/repos/")
+	if readme := strings.TrimSuffix(name, "/readme"); readme != name {
+		if ref := r.URL.Query().Get("ref"); ref != "" {
+			readme += "@" + ref
+		}
+		body, ok := g.readmes[readme]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		etag := `"` + contentHash([]byte(body))[:16] + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(body))
+		return
+	}
+	body, ok := g.repos[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write([]byte(body))
+}
+
+// newDelivery returns a webhook delivery of event with body, signed with
+// testSecret.
+func newDelivery(event, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+	req.Header.Set("X-Github-Event", event)
+	signDelivery(req, []byte(body), []byte(testSecret))
+	return req
+}
+
+// pushBody returns the payload of a push of ref to darlinggo/repo.
+func pushBody(repo, ref string) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"ref":   ref,
+		"after": "0123456789abcdef",
+		"repository": map[string]interface{}{
+			"name":      repo,
+			"full_name": "darlinggo/" + repo,
+			"owner":     map[string]string{"login": "darlinggo"},
+		},
+		"sender": map[string]string{"login": "octocat"},
+	})
+	return string(b)
+}
+
+// syncAllBody returns the payload of a sync-all of repos.
+func syncAllBody(repos ...string) string {
+	b, _ := json.Marshal(map[string][]string{"repos": repos})
+	return string(b)
+}
+
+func serve(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// readPage returns the content of the page for repo in e's output dir.
+func readPage(t *testing.T, e env, repo string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join(e.hugoSource, e.dir, repo+".md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestPingSignature(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		allow    bool
+		event    string
+		signed   bool
+		wantCode int
+		wantPong bool
+	}{
+		{name: "signed ping, verifying", event: "ping", signed: true, wantCode: http.StatusOK, wantPong: true},
+		{name: "unsigned ping, verifying", event: "ping", wantCode: http.StatusBadRequest},
+		{name: "signed ping, allowing unsigned", allow: true, event: "ping", signed: true, wantCode: http.StatusOK, wantPong: true},
+		{name: "unsigned ping, allowing unsigned", allow: true, event: "ping", wantCode: http.StatusOK, wantPong: true},
+		{name: "unsigned push, allowing unsigned pings", allow: true, event: "push", wantCode: http.StatusBadRequest},
+		{name: "unsigned sync-all, allowing unsigned pings", allow: true, event: "sync-all", wantCode: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t, "http://github.invalid")
+			e.allowUnsignedPing = tc.allow
+			body := `{"zen": "Keep it logically awesome."}`
+			if tc.event == "push" {
+				body = pushBody("api", "refs/heads/master")
+			} else if tc.event == "sync-all" {
+				body = syncAllBody("api")
+			}
+			req := newDelivery(tc.event, body)
+			if !tc.signed {
+				req.Header.Del("X-Hub-Signature")
+				req.Header.Del("X-Hub-Signature-256")
+			}
+			w := serve(e, req)
+			if w.Code != tc.wantCode {
+				t.Errorf("got status %d, want %d", w.Code, tc.wantCode)
+			}
+			if (w.Body.String() == "pong") != tc.wantPong {
+				t.Errorf("got body %q", w.Body.String())
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != 0 {
+				t.Errorf("hugo ran %d times, want none", len(runs))
+			}
+		})
+	}
+}
+
+// writeTestPage writes a placeholder page for repo to e's output dir, as
+// an earlier sync would have.
+func writeTestPage(t *testing.T, e env, repo string) {
+	t.Helper()
+	dir := filepath.Join(e.hugoSource, e.dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, repo+".md"), []byte("# "+repo+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoSecrets(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		secrets map[string]string
+		repo    string
+		secret  string
+		want    int
+	}{
+		{name: "no mapping", repo: "api", secret: testSecret, want: http.StatusOK},
+		{name: "full name", secrets: map[string]string{"darlinggo/api": "api-secret"}, repo: "api", secret: "api-secret", want: http.StatusOK},
+		{name: "repo name", secrets: map[string]string{"api": "api-secret"}, repo: "api", secret: "api-secret", want: http.StatusOK},
+		{name: "owner", secrets: map[string]string{"darlinggo": "org-secret"}, repo: "api", secret: "org-secret", want: http.StatusOK},
+		{name: "full name before owner", secrets: map[string]string{"darlinggo": "org-secret", "darlinggo/api": "api-secret"}, repo: "api", secret: "api-secret", want: http.StatusOK},
+		{name: "global secret for a mapped repo", secrets: map[string]string{"darlinggo/api": "api-secret"}, repo: "api", secret: testSecret, want: http.StatusBadRequest},
+		{name: "another repo's secret", secrets: map[string]string{"darlinggo/api": "api-secret"}, repo: "hash", secret: "api-secret", want: http.StatusBadRequest},
+		{name: "unmapped repo falls back", secrets: map[string]string{"darlinggo/api": "api-secret"}, repo: "hash", secret: testSecret, want: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/"+tc.repo, "# "+tc.repo+"\n")
+			e := newTestEnv(t, gh.URL)
+			e.repoSecrets = tc.secrets
+			body := pushBody(tc.repo, "refs/heads/master")
+			req := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+			req.Header.Set("X-Github-Event", "push")
+			signDelivery(req, []byte(body), []byte(tc.secret))
+			if w := serve(e, req); w.Code != tc.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+		})
+	}
+}
+
+// logBuffer collects log output, safe to read while goroutines are still
+// logging.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *logBuffer) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(b)
+}
+
+func (l *logBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+// captureLog sends the log to a buffer for the rest of the test.
+func captureLog(t *testing.T) *logBuffer {
+	l := &logBuffer{}
+	log.SetOutput(l)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return l
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+func isFence(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")
+}
+
+// eachLine calls fn with each line of readme outside of code fences,
+// replacing the line with what fn returns.
+func eachLine(readme []byte, fn func(line string) string) []byte {
+	var out bytes.Buffer
+	inFence := false
+	scanner := bufio.NewScanner(bytes.NewReader(readme))
+	scanner.Buffer(nil, len(readme)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isFence(line) {
+			inFence = !inFence
+		} else if !inFence {
+			line = fn(line)
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+func atxHeading(line string) (level int, text string, ok bool) {
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 {
+		return 0, "", false
+	}
+	if level < len(line) && line[level] != ' ' && line[level] != '\t' {
+		return 0, "", false
+	}
+	text = strings.TrimSpace(line[level:])
+	text = strings.TrimSpace(strings.TrimRight(text, "#"))
+	return level, text, true
+}
+
+// setextH1 matches the underline of a setext level 1 heading.
+var setextH1 = regexp.MustCompile(`^ {0,3}=+[ \t]*$`)
+
+// stripLeadingH1 removes the level 1 heading that starts readme, if it
+// starts with one, in either the ATX or the setext style. It's usually the
+// project's name, which the page's title already shows.
+func stripLeadingH1(readme []byte) []byte {
+	lines := strings.SplitAfter(string(readme), "\n")
+	i := 0
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	switch {
+	case i == len(lines):
+		return readme
+	case isATXH1(lines[i]):
+		i++
+	case i+1 < len(lines) && !strings.HasPrefix(lines[i], "    ") && setextH1.MatchString(strings.TrimRight(lines[i+1], "\r\n")):
+		i += 2
+	default:
+		return readme
+	}
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	return []byte(strings.Join(lines[i:], ""))
+}
+
+func isATXH1(line string) bool {
+	level, _, ok := atxHeading(strings.TrimRight(line, "\r\n"))
+	return ok && level == 1
+}
+
+func slugify(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			dash = false
+		case r == ' ' || r == '-' || r == '_':
+			if !dash && b.Len() > 0 {
+				b.WriteRune('-')
+				dash = true
+			}
+		}
+	}
+	return strings.TrimRight(b.String(), "-")
+}
+
+// headingAnchor splits the explicit anchor off the end of a heading's
+// text, as in "Install {#install}".
+func headingAnchor(text string) (string, string, bool) {
+	i := strings.LastIndex(text, "{#")
+	if i < 0 || !strings.HasSuffix(text, "}") {
+		return text, "", false
+	}
+	return strings.TrimSpace(text[:i]), text[i+2 : len(text)-1], true
+}
+
+// slugger hands out slugs that are unique within a page, numbering
+// repeats like GitHub does.
+type slugger map[string]bool
+
+// newSlugger returns a slugger for readme, with the anchors its headings
+// already set for themselves taken.
+func newSlugger(readme []byte) slugger {
+	s := slugger{}
+	eachLine(readme, func(line string) string {
+		if _, text, ok := atxHeading(line); ok {
+			if _, id, ok := headingAnchor(text); ok {
+				s[id] = true
+			}
+		}
+		return line
+	})
+	return s
+}
+
+func (s slugger) unique(text string) string {
+	base := slugify(text)
+	if base == "" {
+		base = "section"
+	}
+	slug := base
+	for n := 1; s[slug]; n++ {
+		slug = base + "-" + strconv.Itoa(n)
+	}
+	s[slug] = true
+	return slug
+}
+
+func addHeadingAnchors(readme []byte) []byte {
+	var out bytes.Buffer
+	slugs := newSlugger(readme)
+	inFence := false
+	scanner := bufio.NewScanner(bytes.NewReader(readme))
+	scanner.Buffer(nil, len(readme)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isFence(line) {
+			inFence = !inFence
+		}
+		if !inFence {
+			level, text, ok := atxHeading(line)
+			if _, _, explicit := headingAnchor(text); ok && !explicit {
+				line = strings.Repeat("#", level) + " " + text + " {#" + slugs.unique(text) + "}"
+			}
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAddHeadingAnchors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		readme string
+		want   string
+	}{
+		{
+			name:   "headings",
+			readme: "# Project\n\nIntro.\n\n## Getting Started\n\n### API & CLI\n",
+			want:   "# Project {#project}\n\nIntro.\n\n## Getting Started {#getting-started}\n\n### API & CLI {#api-cli}\n",
+		},
+		{
+			name:   "repeated headings",
+			readme: "## Foo\n## Foo\n## Foo 1\n",
+			want:   "## Foo {#foo}\n## Foo {#foo-1}\n## Foo 1 {#foo-1-1}\n",
+		},
+		{
+			name:   "explicit anchors",
+			readme: "## Usage\n## Install {#usage}\n## Usage\n",
+			want:   "## Usage {#usage-1}\n## Install {#usage}\n## Usage {#usage-2}\n",
+		},
+		{
+			name:   "headings with no slug",
+			readme: "## !!!\n## ???\n",
+			want:   "## !!! {#section}\n## ??? {#section-1}\n",
+		},
+		{
+			name:   "code fences",
+			readme: "## Example\n```sh\n# not a heading\n```\n",
+			want:   "## Example {#example}\n```sh\n# not a heading\n```\n",
+		},
+		{
+			name:   "no headings",
+			readme: "Just text.\n#hashtag\n",
+			want:   "Just text.\n#hashtag\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := string(addHeadingAnchors([]byte(tc.readme)))
+			if got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHeadingAnchorsUnique(t *testing.T) {
+	readme := "# A\n## A\n## A 1\n## A-1\n## a {#a-2}\n## A\n### A 1\n"
+	seen := map[string]bool{}
+	for _, m := range regexp.MustCompile(`\{#([^}]+)\}`).FindAllStringSubmatch(string(addHeadingAnchors([]byte(readme))), -1) {
+		if seen[m[1]] {
+			t.Errorf("anchor %q used more than once", m[1])
+		}
+		seen[m[1]] = true
+	}
+	if len(seen) != 7 {
+		t.Errorf("got %d anchors, want 7", len(seen))
+	}
+}
+
+func TestStripLeadingH1(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		readme string
+		want   string
+	}{
+		{name: "ATX heading", readme: "# api\n\nAn API.\n", want: "An API.\n"},
+		{name: "closed ATX heading", readme: "# api #\n\nAn API.\n", want: "An API.\n"},
+		{name: "leading blank lines", readme: "\n\n  \n# api\nAn API.\n", want: "An API.\n"},
+		{name: "setext heading", readme: "api\n===\n\nAn API.\n", want: "An API.\n"},
+		{name: "setext heading, CRLF", readme: "api\r\n===  \r\n\r\nAn API.\r\n", want: "An API.\r\n"},
+		{name: "only a heading", readme: "# api\n", want: ""},
+		{name: "no heading", readme: "An API.\n\n# Usage\n", want: "An API.\n\n# Usage\n"},
+		{name: "H2", readme: "## api\n\nAn API.\n", want: "## api\n\nAn API.\n"},
+		{name: "setext H2", readme: "api\n---\n\nAn API.\n", want: "api\n---\n\nAn API.\n"},
+		{name: "indented code", readme: "    api\n    ===\n", want: "    api\n    ===\n"},
+		{name: "only the first H1", readme: "# api\n\n# Usage\n", want: "# Usage\n"},
+		{name: "empty", readme: "", want: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(stripLeadingH1([]byte(tc.readme))); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStripLeadingH1Pages(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		strip    bool
+		want     string
+		wantNone string
+	}{
+		{name: "stripped", strip: true, want: "An API.", wantNone: "# api"},
+		{name: "off", want: "# api\n\nAn API."},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n\nAn API.\n")
+			e := newTestEnv(t, gh.URL)
+			e.stripLeadingH1 = tc.strip
+			if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			page := readPage(t, e, "api")
+			if !strings.Contains(page, tc.want) {
+				t.Errorf("page doesn't contain %q:\n%s", tc.want, page)
+			}
+			if tc.wantNone != "" && strings.Contains(page, tc.wantNone) {
+				t.Errorf("page contains %q:\n%s", tc.wantNone, page)
+			}
+			if !strings.Contains(page, `title = "api"`) {
+				t.Errorf("page lost its title:\n%s", page)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tomlValue renders v, which is usually decoded from JSON, as a TOML value
+// for use in front matter.
+func tomlValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return tomlString(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		if v == float64(int64(v)) {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []string:
+		values := make([]string, 0, len(v))
+		for _, s := range v {
+			values = append(values, tomlString(s))
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			values = append(values, tomlValue(value))
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	case map[string]int:
+		generic := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			generic[key] = value
+		}
+		return tomlValue(generic)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]string, 0, len(v))
+		for _, key := range keys {
+			values = append(values, tomlKey(key)+" = "+tomlValue(v[key]))
+		}
+		return "{" + strings.Join(values, ", ") + "}"
+	}
+	return tomlString("")
+}
+
+func tomlKey(key string) string {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return tomlString(key)
+		}
+	}
+	if key == "" {
+		return tomlString(key)
+	}
+	return key
+}
+
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			b.WriteString(`\u` + strconv.FormatInt(int64(r)+0x10000, 16)[1:])
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// primaryLanguage returns the language with the most bytes, breaking ties
+// alphabetically.
+func primaryLanguage(languages map[string]int) string {
+	primary := ""
+	for language, bytes := range languages {
+		if primary == "" || bytes > languages[primary] || bytes == languages[primary] && language < primary {
+			primary = language
+		}
+	}
+	return primary
+}
+
+// reservedKeys are the front matter keys that identify a page. They're
+// always generated, and METADATA_FILE can only override them if they're
+// listed in ALLOW_FRONT_MATTER_OVERRIDES.
+var reservedKeys = []string{"date", "title", "repo", "url"}
+
+// generatedKeys are the front matter keys that only readmesync sets, which
+// METADATA_FILE can't override at all.
+var generatedKeys = []string{"version", "languages", "content_hash"}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if strings.ToLower(k) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// checkReservedKeys returns an error naming the first entry in metadata
+// that sets a reserved key that isn't in allowed, sets a generated key, or
+// sets a key more than once. Hugo ignores the case of front matter keys,
+// so neither does the check.
+func checkReservedKeys(metadata map[string]map[string]interface{}, allowed []string) error {
+	repos := make([]string, 0, len(metadata))
+	for repo := range metadata {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	for _, repo := range repos {
+		keys := make([]string, 0, len(metadata[repo]))
+		for key := range metadata[repo] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		seen := map[string]bool{}
+		for _, key := range keys {
+			lower := strings.ToLower(key)
+			if seen[lower] {
+				return errors.New(repo + ": " + key + " is set more than once")
+			}
+			seen[lower] = true
+			if containsKey(generatedKeys, lower) {
+				return errors.New(repo + ": " + key + " is always generated")
+			}
+			if !containsKey(reservedKeys, lower) {
+				continue
+			}
+			if !containsKey(allowed, lower) {
+				return errors.New(repo + ": " + key + " is reserved")
+			}
+			if _, ok := metadata[repo][key].(string); !ok {
+				return errors.New(repo + ": " + key + " must be a string")
+			}
+		}
+	}
+	return nil
+}
+
+func setReservedKey(data *pageData, key, value string) {
+	switch key {
+	case "date":
+		data.Date = value
+	case "title":
+		data.Title = value
+	case "repo":
+		data.Repo = value
+	case "url":
+		data.URL = value
+	}
+}
+
+// enrich fills in data's metadata. Live data from the GitHub API is
+// applied first, when REPO_METADATA or REPO_LANGUAGES is set, and then anything in the
+// METADATA_FILE entry for the repo overrides it. Keys in the file that
+// aren't description, homepage, topics, stars, or forks are passed through
+// as Params.
+func (e env) enrich(ctx context.Context, data *pageData) {
+	if e.repoMetadata {
+		repo, err := e.pullRepo(ctx, e.fullName(data.Repo))
+		if err != nil {
+			log.Println(err)
+		} else {
+			data.Description = repo.Description
+			data.Homepage = repo.Homepage
+			data.Language = repo.Language
+			data.Topics = repo.Topics
+			data.Stars = repo.Stars
+			data.Forks = repo.Forks
+		}
+	}
+	if e.repoLanguages {
+		languages, err := e.pullLanguages(ctx, e.fullName(data.Repo))
+		if err != nil {
+			log.Println(err)
+		} else {
+			data.Languages = languages
+			data.Language = primaryLanguage(languages)
+		}
+	}
+	for key, value := range e.staticMetadata[data.Repo] {
+		// Values of the wrong type clear the field rather than ending up
+		// in Params and duplicating a generated key.
+		s, _ := value.(string)
+		switch key = strings.ToLower(key); key {
+		case "title", "repo", "url", "date":
+			// checkReservedKeys has already made sure overriding these
+			// is allowed.
+			setReservedKey(data, key, s)
+		case "language":
+			data.Language = s
+		case "description":
+			data.Description = s
+		case "homepage":
+			data.Homepage = s
+		case "topics":
+			topics, _ := value.([]interface{})
+			data.Topics = nil
+			for _, topic := range topics {
+				if s, ok := topic.(string); ok {
+					data.Topics = append(data.Topics, s)
+				}
+			}
+		case "stars":
+			f, _ := value.(float64)
+			data.Stars = int(f)
+		case "forks":
+			f, _ := value.(float64)
+			data.Forks = int(f)
+		case "version", "languages", "content_hash":
+			log.Println(data.Repo + ": ignoring " + key + " in METADATA_FILE, it's always generated")
+		default:
+			if data.Params == nil {
+				data.Params = map[string]interface{}{}
+			}
+			data.Params[key] = value
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnrich(t *testing.T) {
+	const live = `{"description": "Live description", "homepage": "https://api.example", "language": "Go", "topics": ["go", "api"], "stargazers_count": 42, "forks_count": 7, "default_branch": "master"}`
+	for _, tc := range []struct {
+		name     string
+		live     bool
+		static   map[string]interface{}
+		want     pageData
+		wantLogs string
+	}{
+		{
+			name: "live only",
+			live: true,
+			want: pageData{Description: "Live description", Homepage: "https://api.example", Language: "Go", Topics: []string{"go", "api"}, Stars: 42, Forks: 7},
+		},
+		{
+			name:   "static only",
+			static: map[string]interface{}{"description": "Static description", "stars": float64(3)},
+			want:   pageData{Description: "Static description", Stars: 3},
+		},
+		{
+			name: "static overrides live",
+			live: true,
+			static: map[string]interface{}{
+				"description": "Static description",
+				"topics":      []interface{}{"library"},
+				"stars":       float64(100),
+			},
+			want: pageData{Description: "Static description", Homepage: "https://api.example", Language: "Go", Topics: []string{"library"}, Stars: 100, Forks: 7},
+		},
+		{
+			name:   "other keys are params",
+			live:   true,
+			static: map[string]interface{}{"weight": float64(10), "status": "stable"},
+			want:   pageData{Description: "Live description", Homepage: "https://api.example", Language: "Go", Topics: []string{"go", "api"}, Stars: 42, Forks: 7, Params: map[string]interface{}{"weight": float64(10), "status": "stable"}},
+		},
+		{
+			name:   "values of the wrong type clear the field",
+			live:   true,
+			static: map[string]interface{}{"description": 5, "stars": "many"},
+			want:   pageData{Homepage: "https://api.example", Language: "Go", Topics: []string{"go", "api"}, Forks: 7},
+		},
+		{
+			name:     "generated keys ignored",
+			static:   map[string]interface{}{"content_hash": "abc"},
+			wantLogs: "ignoring content_hash in METADATA_FILE",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setRepo("darlinggo/api", live)
+			e := newTestEnv(t, gh.URL)
+			e.repoMetadata = tc.live
+			e.staticMetadata = map[string]map[string]interface{}{"api": tc.static}
+			logs := captureLog(t)
+			data := pageData{Repo: "api"}
+			e.enrich(context.Background(), &data)
+			tc.want.Repo = "api"
+			if !reflect.DeepEqual(data, tc.want) {
+				t.Errorf("got %+v, want %+v", data, tc.want)
+			}
+			if !strings.Contains(logs.String(), tc.wantLogs) {
+				t.Errorf("logs don't contain %q:\n%s", tc.wantLogs, logs)
+			}
+		})
+	}
+}
+
+func TestMetadataFrontMatter(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	gh.setRepo("darlinggo/api", `{"description": "Live description", "topics": ["go"], "stargazers_count": 42, "default_branch": "master", "size": 1}`)
+	e := newTestEnv(t, gh.URL)
+	e.repoMetadata = true
+	e.staticMetadata = map[string]map[string]interface{}{"api": {"description": "Static description", "weight": float64(10)}}
+	if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	page := readPage(t, e, "api")
+	for _, want := range []string{
+		`description = "Static description"`,
+		`topics = ["go"]`,
+		"stars = 42",
+		"weight = 10",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page doesn't contain %s:\n%s", want, page)
+		}
+	}
+}
+
+// TestRepoMetadataFrontMatter checks that the repo's description and
+// homepage reach the page, and that the page is still written when they
+// can't be fetched.
+func TestRepoMetadataFrontMatter(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		repo     string
+		want     []string
+		wantNone []string
+		wantLogs string
+	}{
+		{
+			name: "description and homepage",
+			repo: `{"description": "A small API", "homepage": "https://api.example", "language": "Go", "default_branch": "master", "size": 1}`,
+			want: []string{`description = "A small API"`, `homepage = "https://api.example"`, `language = "Go"`},
+		},
+		{
+			name:     "no homepage",
+			repo:     `{"description": "A small API", "homepage": "", "default_branch": "master", "size": 1}`,
+			want:     []string{`description = "A small API"`},
+			wantNone: []string{"homepage ="},
+		},
+		{
+			name:     "repo can't be fetched",
+			wantNone: []string{"description =", "homepage ="},
+			wantLogs: "/repos/darlinggo/api: non-200 status: 500",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			gh.setRepo("darlinggo/api", tc.repo)
+			gh.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/repos/darlinggo/api" && tc.repo == "" {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				gh.serve(w, r)
+			})
+			e := newTestEnv(t, gh.URL)
+			e.repoMetadata = true
+			logs := captureLog(t)
+			if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			page := readPage(t, e, "api")
+			if !strings.Contains(page, "# api") {
+				t.Errorf("page doesn't contain its README:\n%s", page)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(page, want+"\n") {
+					t.Errorf("page doesn't contain %s:\n%s", want, page)
+				}
+			}
+			for _, unwanted := range tc.wantNone {
+				if strings.Contains(page, unwanted) {
+					t.Errorf("page contains %s:\n%s", unwanted, page)
+				}
+			}
+			if !strings.Contains(logs.String(), tc.wantLogs) {
+				t.Errorf("logs don't contain %q:\n%s", tc.wantLogs, logs)
+			}
+		})
+	}
+}
+
+func TestStarsAndForksFrontMatter(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		repo     string
+		static   map[string]interface{}
+		want     []string
+		wantNone []string
+	}{
+		{
+			name: "stars and forks",
+			repo: `{"stargazers_count": 42, "forks_count": 7, "default_branch": "master", "size": 1}`,
+			want: []string{"stars = 42", "forks = 7"},
+		},
+		{
+			name:     "no forks",
+			repo:     `{"stargazers_count": 42, "forks_count": 0, "default_branch": "master", "size": 1}`,
+			want:     []string{"stars = 42"},
+			wantNone: []string{"forks ="},
+		},
+		{
+			name:     "neither",
+			repo:     `{"default_branch": "master", "size": 1}`,
+			wantNone: []string{"stars =", "forks ="},
+		},
+		{
+			name:   "overridden by the metadata file",
+			repo:   `{"stargazers_count": 42, "forks_count": 7, "default_branch": "master", "size": 1}`,
+			static: map[string]interface{}{"forks": float64(100)},
+			want:   []string{"stars = 42", "forks = 100"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			gh.setRepo("darlinggo/api", tc.repo)
+			e := newTestEnv(t, gh.URL)
+			e.repoMetadata = true
+			if tc.static != nil {
+				e.staticMetadata = map[string]map[string]interface{}{"api": tc.static}
+			}
+			if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			page := readPage(t, e, "api")
+			for _, want := range tc.want {
+				if !strings.Contains(page, want+"\n") {
+					t.Errorf("page doesn't contain %s:\n%s", want, page)
+				}
+			}
+			for _, unwanted := range tc.wantNone {
+				if strings.Contains(page, unwanted) {
+					t.Errorf("page contains %s:\n%s", unwanted, page)
+				}
+			}
+		})
+	}
+}
+
+func TestPrimaryLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		languages map[string]int
+		want      string
+	}{
+		{name: "none"},
+		{name: "one", languages: map[string]int{"Go": 100}, want: "Go"},
+		{name: "most bytes", languages: map[string]int{"Go": 5000, "Shell": 200, "HTML": 4000}, want: "Go"},
+		{name: "tie", languages: map[string]int{"Shell": 100, "Go": 100, "Makefile": 10}, want: "Go"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := primaryLanguage(tc.languages); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLanguagesFrontMatter(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		languages bool
+		metadata  bool
+		want      []string
+		wantNot   []string
+	}{
+		{
+			name:      "languages",
+			languages: true,
+			want:      []string{`language = "Go"`, "languages = {Go = 5000, HTML = 4000, Shell = 200}"},
+		},
+		{
+			name:      "languages override the repo's language",
+			languages: true,
+			metadata:  true,
+			want:      []string{`language = "Go"`, "languages = "},
+			wantNot:   []string{`language = "HTML"`},
+		},
+		{
+			name:     "repo metadata only",
+			metadata: true,
+			want:     []string{`language = "HTML"`},
+			wantNot:  []string{"languages = "},
+		},
+		{
+			name:    "off",
+			wantNot: []string{"language = ", "languages = "},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			gh.setRepo("darlinggo/api", `{"language": "HTML", "default_branch": "master", "size": 1}`)
+			gh.setRepo("darlinggo/api/languages", `{"Go": 5000, "Shell": 200, "HTML": 4000}`)
+			e := newTestEnv(t, gh.URL)
+			e.repoLanguages = tc.languages
+			e.repoMetadata = tc.metadata
+			if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			page := readPage(t, e, "api")
+			for _, want := range tc.want {
+				if !strings.Contains(page, want) {
+					t.Errorf("page doesn't contain %s:\n%s", want, page)
+				}
+			}
+			for _, unwanted := range tc.wantNot {
+				if strings.Contains(page, unwanted) {
+					t.Errorf("page contains %s:\n%s", unwanted, page)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckReservedKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		metadata map[string]interface{}
+		allowed  []string
+		wantErr  string
+	}{
+		{name: "no reserved keys", metadata: map[string]interface{}{"description": "api", "weight": float64(1)}},
+		{name: "url override", metadata: map[string]interface{}{"url": "/elsewhere/"}, wantErr: "api: url is reserved"},
+		{name: "allowed url override", metadata: map[string]interface{}{"url": "/elsewhere/"}, allowed: []string{"url"}},
+		{name: "url override in another case", metadata: map[string]interface{}{"URL": "/elsewhere/"}, wantErr: "api: URL is reserved"},
+		{name: "allowed url override in another case", metadata: map[string]interface{}{"Url": "/elsewhere/"}, allowed: []string{"URL"}},
+		{name: "allowed override that isn't a string", metadata: map[string]interface{}{"date": float64(2020)}, allowed: []string{"date"}, wantErr: "api: date must be a string"},
+		{name: "key set twice", metadata: map[string]interface{}{"Weight": float64(1), "weight": float64(2)}, wantErr: "api: weight is set more than once"},
+		{name: "version", metadata: map[string]interface{}{"version": "v2"}, allowed: []string{"version"}, wantErr: "api: version is always generated"},
+		{name: "content hash", metadata: map[string]interface{}{"Content_Hash": "abc"}, wantErr: "api: Content_Hash is always generated"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkReservedKeys(map[string]map[string]interface{}{"api": tc.metadata}, tc.allowed)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("got error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestFrontMatterOverride(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	e := newTestEnv(t, gh.URL)
+	e.staticMetadata = map[string]map[string]interface{}{"api": {"URL": "/elsewhere/", "Description": "Static description"}}
+	if err := checkReservedKeys(e.staticMetadata, []string{"url"}); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	page := readPage(t, e, "api")
+	for key, want := range map[string]string{
+		"url":         `url = "/elsewhere/"`,
+		"description": `description = "Static description"`,
+	} {
+		if n := strings.Count(strings.ToLower(page), key+" = "); n != 1 {
+			t.Errorf("page sets %s %d times:\n%s", key, n, page)
+		}
+		if !strings.Contains(page, want) {
+			t.Errorf("page doesn't contain %s:\n%s", want, page)
+		}
+	}
+}
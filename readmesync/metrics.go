@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// buildBuckets are the upper bounds, in seconds, of the build duration
+// histogram's buckets.
+var buildBuckets = []float64{1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// metrics counts what readmesync has done, for Prometheus to scrape from
+// /metrics. Its methods do nothing on a nil *metrics, so instrumented code
+// doesn't need to check whether METRICS is set.
+type metrics struct {
+	sync.Mutex
+	webhooks      map[string]int64
+	fetches       int64
+	fetchFailures int64
+	builds        int64
+	buildFailures int64
+	buildCounts   []int64
+	buildSum      float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{webhooks: map[string]int64{}, buildCounts: make([]int64, len(buildBuckets))}
+}
+
+func (m *metrics) webhook(event string) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	m.webhooks[event]++
+	m.Unlock()
+}
+
+func (m *metrics) fetch(err error) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	m.fetches++
+	if err != nil {
+		m.fetchFailures++
+	}
+	m.Unlock()
+}
+
+func (m *metrics) build(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	m.builds++
+	if err != nil {
+		m.buildFailures++
+	}
+	secs := d.Seconds()
+	m.buildSum += secs
+	for i, bound := range buildBuckets {
+		if secs <= bound {
+			m.buildCounts[i]++
+		}
+	}
+	m.Unlock()
+}
+
+// ServeHTTP writes the metrics in Prometheus's text format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP readmesync_webhooks_total Webhook deliveries received, by event.")
+	fmt.Fprintln(w, "# TYPE readmesync_webhooks_total counter")
+	events := make([]string, 0, len(m.webhooks))
+	for event := range m.webhooks {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	for _, event := range events {
+		fmt.Fprintf(w, "readmesync_webhooks_total{event=%q} %d\n", event, m.webhooks[event])
+	}
+	fmt.Fprintln(w, "# HELP readmesync_readme_fetches_total READMEs fetched from GitHub.")
+	fmt.Fprintln(w, "# TYPE readmesync_readme_fetches_total counter")
+	fmt.Fprintf(w, "readmesync_readme_fetches_total %d\n", m.fetches)
+	fmt.Fprintln(w, "# HELP readmesync_readme_fetch_failures_total README fetches that failed.")
+	fmt.Fprintln(w, "# TYPE readmesync_readme_fetch_failures_total counter")
+	fmt.Fprintf(w, "readmesync_readme_fetch_failures_total %d\n", m.fetchFailures)
+	fmt.Fprintln(w, "# HELP readmesync_builds_total Hugo builds run.")
+	fmt.Fprintln(w, "# TYPE readmesync_builds_total counter")
+	fmt.Fprintf(w, "readmesync_builds_total %d\n", m.builds)
+	fmt.Fprintln(w, "# HELP readmesync_build_failures_total Hugo builds that failed.")
+	fmt.Fprintln(w, "# TYPE readmesync_build_failures_total counter")
+	fmt.Fprintf(w, "readmesync_build_failures_total %d\n", m.buildFailures)
+	fmt.Fprintln(w, "# HELP readmesync_build_duration_seconds How long Hugo builds took.")
+	fmt.Fprintln(w, "# TYPE readmesync_build_duration_seconds histogram")
+	for i, bound := range buildBuckets {
+		fmt.Fprintf(w, "readmesync_build_duration_seconds_bucket{le=\"%g\"} %d\n", bound, m.buildCounts[i])
+	}
+	fmt.Fprintf(w, "readmesync_build_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.builds)
+	fmt.Fprintf(w, "readmesync_build_duration_seconds_sum %g\n", m.buildSum)
+	fmt.Fprintf(w, "readmesync_build_duration_seconds_count %d\n", m.builds)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns what m serves on /metrics.
+func scrape(t *testing.T, m *metrics) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ctype := w.Header().Get("Content-Type"); !strings.HasPrefix(ctype, "text/plain") {
+		t.Errorf("got Content-Type %q, want text/plain", ctype)
+	}
+	return w.Body.String()
+}
+
+func TestMetricsDeliveries(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		hugo   string
+		readme bool
+		want   []string
+	}{
+		{
+			name:   "push",
+			readme: true,
+			want: []string{
+				`readmesync_webhooks_total{event="push"} 1`,
+				"readmesync_readme_fetches_total 1\n",
+				"readmesync_readme_fetch_failures_total 0\n",
+				"readmesync_builds_total 1\n",
+				"readmesync_build_failures_total 0\n",
+				`readmesync_build_duration_seconds_bucket{le="+Inf"} 1`,
+				"readmesync_build_duration_seconds_count 1\n",
+			},
+		},
+		{
+			name: "missing README",
+			want: []string{
+				`readmesync_webhooks_total{event="push"} 1`,
+				"readmesync_readme_fetches_total 1\n",
+				"readmesync_readme_fetch_failures_total 1\n",
+				"readmesync_builds_total 0\n",
+			},
+		},
+		{
+			name:   "failed build",
+			hugo:   "exit 1\n",
+			readme: true,
+			want: []string{
+				"readmesync_builds_total 1\n",
+				"readmesync_build_failures_total 1\n",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			if tc.readme {
+				gh.setReadme("darlinggo/api", "# api\n")
+			}
+			e := newTestEnv(t, gh.URL)
+			e.hugoCmd = fakeHugo(t, tc.hugo)
+			e.metrics = newMetrics()
+			serve(e, newDelivery("push", pushBody("api", "refs/heads/master")))
+			got := scrape(t, e.metrics)
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("metrics don't contain %q:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMetricsEvents(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	e := newTestEnv(t, gh.URL)
+	e.metrics = newMetrics()
+	serve(e, newDelivery("push", pushBody("api", "refs/heads/master")))
+	serve(e, newDelivery("ping", "{}"))
+	serve(e, newDelivery("ping", "{}"))
+	got := scrape(t, e.metrics)
+	for _, want := range []string{`readmesync_webhooks_total{event="ping"} 2`, `readmesync_webhooks_total{event="push"} 1`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics don't contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, `event="ping"`) > strings.Index(got, `event="push"`) {
+		t.Errorf("events aren't in order:\n%s", got)
+	}
+}
+
+func TestBuildDurationHistogram(t *testing.T) {
+	m := newMetrics()
+	for _, d := range []time.Duration{500 * time.Millisecond, 3 * time.Second, 10 * time.Second, 10 * time.Minute} {
+		m.build(d, nil)
+	}
+	m.build(time.Second, errors.New("build failed"))
+	got := scrape(t, m)
+	for _, want := range []string{
+		`readmesync_build_duration_seconds_bucket{le="1"} 2`,
+		`readmesync_build_duration_seconds_bucket{le="2.5"} 2`,
+		`readmesync_build_duration_seconds_bucket{le="5"} 3`,
+		`readmesync_build_duration_seconds_bucket{le="10"} 4`,
+		`readmesync_build_duration_seconds_bucket{le="300"} 4`,
+		`readmesync_build_duration_seconds_bucket{le="+Inf"} 5`,
+		"readmesync_build_duration_seconds_sum 614.5\n",
+		"readmesync_build_duration_seconds_count 5\n",
+		"readmesync_build_failures_total 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics don't contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *metrics
+	m.webhook("push")
+	m.fetch(nil)
+	m.build(time.Second, nil)
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	e := newTestEnv(t, gh.URL)
+	if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+		t.Errorf("got status %d without metrics: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+const (
+	defaultSuccessNotification = `{"text": {{ json (print "Built " (join .Report.Repos ", ") " in " .Report.DurationMS "ms") }}}`
+	defaultFailureNotification = `{"text": {{ json (print "Build failed for " (join .Report.Repos ", ") ": " .Report.Error) }}}`
+)
+
+var notifyFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": strings.Join,
+}
+
+type notification struct {
+	Event   string
+	Success bool
+	Report  buildReport
+}
+
+type notifier struct {
+	url         string
+	contentType string
+	success     *template.Template
+	failure     *template.Template
+}
+
+// loadNotifyTemplate parses the template in the file at path, or def if
+// path is empty.
+func loadNotifyTemplate(name, path, def string) (*template.Template, error) {
+	text := def
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+	return template.New(name).Funcs(notifyFuncs).Parse(text)
+}
+
+// notify renders the success or failure template for n and posts the
+// result to NOTIFY_URL.
+func (e env) notify(n notification) error {
+	t := e.notifier.failure
+	if n.Success {
+		t = e.notifier.success
+	}
+	var body bytes.Buffer
+	err := t.Execute(&body, n)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(e.notifier.url, e.notifier.contentType, &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("notify: non-2xx status: " + resp.Status)
+	}
+	return nil
+}
+
+func (e env) notifyAsync(n notification) {
+	if e.notifier == nil {
+		return
+	}
+	go func() {
+		if err := e.notify(n); err != nil {
+			log.Println(err)
+		}
+	}()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type notifyRequest struct {
+	contentType string
+	body        string
+}
+
+// newNotifyServer returns a server that sends the notifications posted to
+// it on the returned channel.
+func newNotifyServer(t *testing.T) (*httptest.Server, <-chan notifyRequest) {
+	ch := make(chan notifyRequest, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		ch <- notifyRequest{contentType: r.Header.Get("Content-Type"), body: string(b)}
+	}))
+	t.Cleanup(s.Close)
+	return s, ch
+}
+
+func TestNotify(t *testing.T) {
+	const discord = `{"content": {{ json (print "❌ " .Event ": " (join .Report.Repos ", ")) }}{{ with .Report.Error }}, "embeds": [{"description": {{ json . }}}]{{ end }}}`
+	report := buildReport{Repos: []string{"api", "hash"}, DurationMS: 1200, ExitCode: 2, Error: "exit status 2"}
+	for _, tc := range []struct {
+		name     string
+		template string
+		success  bool
+		want     string
+	}{
+		{
+			name:    "default failure",
+			success: false,
+			want:    `{"text": "Build failed for api, hash: exit status 2"}`,
+		},
+		{
+			name:    "default success",
+			success: true,
+			want:    `{"text": "Built api, hash in 1200ms"}`,
+		},
+		{
+			name:     "Discord failure",
+			template: discord,
+			want:     `{"content": "❌ push: api, hash", "embeds": [{"description": "exit status 2"}]}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, ch := newNotifyServer(t)
+			success, err := loadNotifyTemplate("success", "", defaultSuccessNotification)
+			if err != nil {
+				t.Fatal(err)
+			}
+			failure, err := loadNotifyTemplate("failure", "", defaultFailureNotification)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.template != "" {
+				path := t.TempDir() + "/failure.tmpl"
+				if err := ioutil.WriteFile(path, []byte(tc.template), 0644); err != nil {
+					t.Fatal(err)
+				}
+				failure, err = loadNotifyTemplate("failure", path, defaultFailureNotification)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			e := newTestEnv(t, "http://github.invalid")
+			e.notifier = &notifier{url: s.URL, contentType: "application/json", success: success, failure: failure}
+			r := report
+			if tc.success {
+				r.Error, r.ExitCode = "", 0
+			}
+			if err := e.notify(notification{Event: "push", Success: tc.success, Report: r}); err != nil {
+				t.Fatal(err)
+			}
+			got := <-ch
+			if got.body != tc.want {
+				t.Errorf("got body\n%s\nwant\n%s", got.body, tc.want)
+			}
+			if got.contentType != "application/json" {
+				t.Errorf("got Content-Type %q", got.contentType)
+			}
+		})
+	}
+}
+
+func TestNotifyFailedBuild(t *testing.T) {
+	s, ch := newNotifyServer(t)
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	e := newTestEnv(t, gh.URL)
+	e.hugoCmd = fakeHugo(t, "exit 1\n")
+	success, _ := loadNotifyTemplate("success", "", defaultSuccessNotification)
+	failure, _ := loadNotifyTemplate("failure", "", defaultFailureNotification)
+	e.notifier = &notifier{url: s.URL, contentType: "application/json", success: success, failure: failure}
+	serve(e, newDelivery("push", pushBody("api", "refs/heads/master")))
+	select {
+	case got := <-ch:
+		if want := `{"text": "Build failed for api: exit status 1"}`; got.body != want {
+			t.Errorf("got body\n%s\nwant\n%s", got.body, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification was sent")
+	}
+}
+
+func TestNotifyTemplateErrors(t *testing.T) {
+	if _, err := loadNotifyTemplate("failure", "/nonexistent/failure.tmpl", defaultFailureNotification); err == nil {
+		t.Error("got no error for a missing template file")
+	}
+	path := t.TempDir() + "/failure.tmpl"
+	ioutil.WriteFile(path, []byte(`{{ .Report.Repos `), 0644)
+	if _, err := loadNotifyTemplate("failure", path, defaultFailureNotification); err == nil {
+		t.Error("got no error for an unparseable template")
+	}
+}
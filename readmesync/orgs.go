@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// fullName returns the owner/repo name for repo, which is either already
+// namespaced or belongs to the first of GITHUB_ORGS.
+func (e env) fullName(repo string) string {
+	if strings.Contains(repo, "/") {
+		return repo
+	}
+	return e.orgs[0] + "/" + repo
+}
+
+// repoName returns the name syncing uses for owner's repo: namespaced as
+// owner/repo when serving several orgs, otherwise just the repo's name. An
+// empty owner means the first of GITHUB_ORGS. ok is false if owner isn't
+// one of GITHUB_ORGS.
+func (e env) repoName(owner, repo string) (name string, ok bool) {
+	if owner == "" {
+		owner = e.orgs[0]
+	}
+	for _, org := range e.orgs {
+		if strings.EqualFold(org, owner) {
+			if len(e.orgs) > 1 {
+				return org + "/" + repo, true
+			}
+			return repo, true
+		}
+	}
+	return "", false
+}
+
+// resolveRepo maps a repo from a sync-all payload, given either as
+// owner/repo or as a bare name, to its repoName.
+func (e env) resolveRepo(repo string) (string, error) {
+	owner := ""
+	if i := strings.Index(repo, "/"); i >= 0 {
+		owner, repo = repo[:i], repo[i+1:]
+	} else if len(e.orgs) > 1 {
+		return "", errors.New(repo + ": repos must be given as org/repo when syncing several orgs")
+	}
+	name, ok := e.repoName(owner, repo)
+	if !ok {
+		return "", errors.New(owner + "/" + repo + ": " + owner + " is not one of GITHUB_ORGS")
+	}
+	return name, nil
+}
+
+// repoNames maps full repo names to their repoNames, dropping any that
+// aren't in one of GITHUB_ORGS.
+func (e env) repoNames(fullNames []string) []string {
+	names := make([]string, 0, len(fullNames))
+	for _, fullName := range fullNames {
+		if name, ok := e.repoName(splitFullName(fullName)); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func splitFullName(fullName string) (owner, repo string) {
+	if i := strings.Index(fullName, "/"); i >= 0 {
+		return fullName[:i], fullName[i+1:]
+	}
+	return "", fullName
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMultiOrgSyncAll(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# darlinggo api\n")
+	gh.setReadme("paddycarver/api", "# paddycarver api\n")
+	e := newTestEnv(t, gh.URL)
+	e.orgs = []string{"darlinggo", "paddycarver"}
+	if w := serve(e, newDelivery("sync-all", syncAllBody("darlinggo/api", "paddycarver/api"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	for repo, want := range map[string]string{
+		"darlinggo/api":   "# darlinggo api\n",
+		"paddycarver/api": "# paddycarver api\n",
+	} {
+		page := readPage(t, e, repo)
+		if !strings.Contains(page, want) || !strings.Contains(page, `repo = "`+repo+`"`) {
+			t.Errorf("%s: got page\n%s", repo, page)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(e.hugoSource, e.dir, "api.md")); err == nil {
+		t.Error("wrote a page without a namespace")
+	}
+}
+
+func TestMultiOrgRequests(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		event      string
+		body       string
+		wantStatus int
+		wantPage   string
+	}{
+		{name: "push to the second org", event: "push", body: orgPushBody("paddycarver", "api"), wantStatus: http.StatusOK, wantPage: "paddycarver/api"},
+		{name: "push with a differently cased org", event: "push", body: orgPushBody("PaddyCarver", "api"), wantStatus: http.StatusOK, wantPage: "paddycarver/api"},
+		{name: "push to another org", event: "push", body: orgPushBody("someone", "api"), wantStatus: http.StatusOK},
+		{name: "sync-all of a bare name", event: "sync-all", body: syncAllBody("api"), wantStatus: http.StatusBadRequest},
+		{name: "sync-all of another org", event: "sync-all", body: syncAllBody("someone/api"), wantStatus: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("paddycarver/api", "# paddycarver api\n")
+			gh.setReadme("someone/api", "# someone api\n")
+			e := newTestEnv(t, gh.URL)
+			e.orgs = []string{"darlinggo", "paddycarver"}
+			if w := serve(e, newDelivery(tc.event, tc.body)); w.Code != tc.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tc.wantStatus, w.Body.String())
+			}
+			pages, _ := filepath.Glob(filepath.Join(e.hugoSource, e.dir, "*", "*.md"))
+			if tc.wantPage == "" {
+				if len(pages) > 0 {
+					t.Errorf("wrote %v, want no pages", pages)
+				}
+				return
+			}
+			if len(pages) != 1 || pages[0] != filepath.Join(e.hugoSource, e.dir, tc.wantPage+".md") {
+				t.Errorf("wrote %v, want only %s", pages, tc.wantPage)
+			}
+		})
+	}
+}
+
+// orgPushBody returns the payload of a push to the master branch of
+// owner/repo.
+func orgPushBody(owner, repo string) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"ref":   "refs/heads/master",
+		"after": "0123456789abcdef",
+		"repository": map[string]interface{}{
+			"name":      repo,
+			"full_name": owner + "/" + repo,
+			"owner":     map[string]string{"login": owner},
+		},
+		"sender": map[string]string{"login": "octocat"},
+	})
+	return string(b)
+}
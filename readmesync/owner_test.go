@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestConfiguredOwner(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		owner string
+		event string
+		body  string
+		want  string
+	}{
+		{name: "sync-all for a user", owner: "someone", event: "sync-all", body: syncAllBody("api"), want: "/repos/someone/api/readme"},
+		{name: "sync-all for an org", owner: "darlinggo", event: "sync-all", body: syncAllBody("api"), want: "/repos/darlinggo/api/readme"},
+		{name: "push", owner: "someone", event: "push", body: strings.Replace(pushBody("api", "refs/heads/master"), "darlinggo", "someone", -1), want: "/repos/someone/api/readme"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme(tc.owner+"/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.orgs = []string{tc.owner}
+			if w := serve(e, newDelivery(tc.event, tc.body)); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			found := false
+			for _, path := range gh.requested() {
+				if strings.HasPrefix(path, "/repos/") && strings.Contains(path, "/readme") {
+					if !strings.HasPrefix(path, tc.want) {
+						t.Errorf("requested %s, want %s", path, tc.want)
+					}
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("%s wasn't requested: %q", tc.want, gh.requested())
+			}
+			if page := readPage(t, e, "api"); !strings.Contains(page, "# api") {
+				t.Errorf("page doesn't contain the README:\n%s", page)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+// orgRepos lists the names of owner's repos, whether owner is an org or a
+// user.
+func (e env) orgRepos(ctx context.Context, owner string) ([]string, error) {
+	names, err := e.listRepoNames(ctx, "/orgs/"+owner+"/repos?type=all")
+	if serr, ok := err.(statusError); ok && serr.code == http.StatusNotFound {
+		return e.listRepoNames(ctx, "/users/"+owner+"/repos?type=owner")
+	}
+	return names, err
+}
+
+// listRepoNames returns the names of the repos listed at path, following
+// it page by page.
+func (e env) listRepoNames(ctx context.Context, path string) ([]string, error) {
+	var names []string
+	for page := 1; ; page++ {
+		body, err := e.githubGet(ctx, path+"&per_page=100&page="+strconv.Itoa(page), "application/vnd.github.v3+json")
+		if err != nil {
+			return nil, err
+		}
+		var repos []struct {
+			Name string `json:"name"`
+		}
+		err = json.Unmarshal(body, &repos)
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range repos {
+			names = append(names, repo.Name)
+		}
+		if len(repos) < 100 {
+			return names, nil
+		}
+	}
+}
+
+// matchRepos returns the repos in GITHUB_ORGS whose names match pattern,
+// as owner/repo.
+func (e env) matchRepos(ctx context.Context, pattern string) ([]string, error) {
+	matched := []string{}
+	for _, org := range e.orgs {
+		names, err := e.orgRepos(ctx, org)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok {
+				matched = append(matched, org+"/"+name)
+			}
+		}
+	}
+	return matched, nil
+}
+
+// bufferedResponse is an http.ResponseWriter that keeps the response, so
+// it can be passed on as part of another.
+type bufferedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedResponse) WriteHeader(code int) { b.code = code }
+
+type patternSync struct {
+	Matched []string        `json:"matched"`
+	Sync    json.RawMessage `json:"sync,omitempty"`
+}
+
+// syncPattern serves POST /sync?pattern=..., syncing every repo whose
+// name matches the glob pattern just as a sync-all naming them would, and
+// responding with the repos matched and the sync-all's summary.
+func (e env) syncPattern(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !e.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	pattern := r.URL.Query().Get("pattern")
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("pattern must be a glob, like \"api-*\""))
+		return
+	}
+	matched, err := e.matchRepos(r.Context(), pattern)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	result := patternSync{Matched: matched}
+	if len(matched) > 0 {
+		body, err := json.Marshal(map[string][]string{"repos": matched})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		target := "/hook"
+		if r.URL.Query().Get("force") == "1" {
+			target += "?force=1"
+		}
+		req, err := http.NewRequestWithContext(r.Context(), "POST", target, bytes.NewReader(body))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		req.RemoteAddr = r.RemoteAddr
+		req.Header.Set("X-Github-Event", "sync-all")
+		signDelivery(req, body, e.hookSecret)
+		resp := &bufferedResponse{header: http.Header{}, code: http.StatusOK}
+		e.ServeHTTP(resp, req)
+		if resp.code != http.StatusOK && resp.code != http.StatusAccepted {
+			w.WriteHeader(resp.code)
+			w.Write(resp.body.Bytes())
+			return
+		}
+		result.Sync = resp.body.Bytes()
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSyncPattern(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		method      string
+		token       string
+		pattern     string
+		want        int
+		wantMatched []string
+		wantSynced  []string
+	}{
+		{
+			name:        "prefix",
+			pattern:     "api-*",
+			want:        http.StatusOK,
+			wantMatched: []string{"darlinggo/api-client", "darlinggo/api-server"},
+			wantSynced:  []string{"api-client", "api-server"},
+		},
+		{
+			name:        "single character",
+			pattern:     "has?",
+			want:        http.StatusOK,
+			wantMatched: []string{"darlinggo/hash"},
+			wantSynced:  []string{"hash"},
+		},
+		{name: "no matches", pattern: "web-*", want: http.StatusOK, wantMatched: []string{}},
+		{name: "bad pattern", pattern: "api-[", want: http.StatusBadRequest},
+		{name: "no pattern", want: http.StatusBadRequest},
+		{name: "wrong token", token: "Bearer nope", pattern: "api-*", want: http.StatusUnauthorized},
+		{name: "GET", method: "GET", pattern: "api-*", want: http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			for _, repo := range []string{"api-client", "api-server", "hash", "site"} {
+				gh.setRepo("darlinggo/"+repo, `{"default_branch": "master", "size": 1}`)
+				gh.setReadme("darlinggo/"+repo, "# "+repo+"\n")
+			}
+			e := newTestEnv(t, gh.URL)
+			e.adminToken = "admin"
+			method, token := tc.method, tc.token
+			if method == "" {
+				method = "POST"
+			}
+			if token == "" {
+				token = "Bearer admin"
+			}
+			req := httptest.NewRequest(method, "/sync?pattern="+tc.pattern, nil)
+			req.Header.Set("Authorization", token)
+			w := httptest.NewRecorder()
+			e.syncPattern(w, req)
+			if w.Code != tc.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			if tc.want != http.StatusOK {
+				return
+			}
+			var result struct {
+				Matched []string     `json:"matched"`
+				Sync    *syncSummary `json:"sync"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Matched, tc.wantMatched) {
+				t.Errorf("matched %q, want %q", result.Matched, tc.wantMatched)
+			}
+			if tc.wantSynced == nil {
+				if result.Sync != nil {
+					t.Errorf("got a sync %+v for no matches", result.Sync)
+				}
+				return
+			}
+			if result.Sync == nil || !reflect.DeepEqual(result.Sync.Synced, tc.wantSynced) {
+				t.Errorf("got sync %+v, want %q synced", result.Sync, tc.wantSynced)
+			}
+			for _, repo := range tc.wantSynced {
+				readPage(t, e, repo)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// payloadWindow is how many recent payload sizes of each event are kept
+// to judge new ones against.
+const payloadWindow = 100
+
+// payloadMinSamples is how many sizes of an event have to have been seen
+// before any are called anomalous.
+const payloadMinSamples = 10
+
+// payloadStats keeps the sizes of the latest payloads of each event, and
+// warns about those that are more than stddevs standard deviations from
+// the mean of the ones before them.
+type payloadStats struct {
+	sync.Mutex
+	stddevs float64
+	sizes   map[string][]int
+}
+
+func newPayloadStats(stddevs float64) *payloadStats {
+	return &payloadStats{stddevs: stddevs, sizes: map[string][]int{}}
+}
+
+// observe logs the size of a payload for event, and records it.
+func (p *payloadStats) observe(event string, size int) {
+	p.Lock()
+	sizes := p.sizes[event]
+	mean, stddev := meanStddev(sizes)
+	sizes = append(sizes, size)
+	if len(sizes) > payloadWindow {
+		sizes = sizes[len(sizes)-payloadWindow:]
+	}
+	p.sizes[event] = sizes
+	p.Unlock()
+	log.Println(event + " payload: " + strconv.Itoa(size) + " bytes")
+	if anomalous(size, len(sizes)-1, mean, stddev, p.stddevs) {
+		log.Println("warning: " + event + " payload of " + strconv.Itoa(size) + " bytes is unusual, they're typically " +
+			strconv.Itoa(int(mean)) + " ± " + strconv.Itoa(int(stddev)) + " bytes")
+	}
+}
+
+func meanStddev(sizes []int) (float64, float64) {
+	if len(sizes) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, size := range sizes {
+		sum += float64(size)
+	}
+	mean := sum / float64(len(sizes))
+	var squares float64
+	for _, size := range sizes {
+		squares += (float64(size) - mean) * (float64(size) - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(sizes)))
+}
+
+// anomalous reports whether size is more than stddevs standard deviations
+// from mean, once there have been enough samples to tell. Sizes from
+// events whose payloads never vary are anomalous as soon as they differ.
+func anomalous(size, samples int, mean, stddev, stddevs float64) bool {
+	if samples < payloadMinSamples {
+		return false
+	}
+	return math.Abs(float64(size)-mean) > stddevs*stddev
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPayloadAnomalies(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		sizes       []int
+		event       string
+		size        int
+		wantWarning bool
+	}{
+		{name: "outlier", sizes: []int{100, 110, 90, 105, 95, 100, 110, 90, 105, 95}, size: 5000, wantWarning: true},
+		{name: "typical", sizes: []int{100, 110, 90, 105, 95, 100, 110, 90, 105, 95}, size: 108},
+		{name: "small outlier", sizes: []int{100, 110, 90, 105, 95, 100, 110, 90, 105, 95}, size: 2, wantWarning: true},
+		{name: "too few samples", sizes: []int{100, 110, 90}, size: 5000},
+		{name: "payloads that never vary", sizes: []int{100, 100, 100, 100, 100, 100, 100, 100, 100, 100}, size: 101, wantWarning: true},
+		{name: "other events aren't compared", sizes: []int{100, 110, 90, 105, 95, 100, 110, 90, 105, 95}, event: "sync-all", size: 5000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newPayloadStats(3)
+			for _, size := range tc.sizes {
+				p.observe("push", size)
+			}
+			logs := captureLog(t)
+			event := tc.event
+			if event == "" {
+				event = "push"
+			}
+			p.observe(event, tc.size)
+			if !strings.Contains(logs.String(), event+" payload: ") {
+				t.Errorf("payload size wasn't logged:\n%s", logs.String())
+			}
+			if got := strings.Contains(logs.String(), "warning: "); got != tc.wantWarning {
+				t.Errorf("warned: got %v, want %v:\n%s", got, tc.wantWarning, logs.String())
+			}
+		})
+	}
+}
+
+func TestPayloadWindow(t *testing.T) {
+	p := newPayloadStats(3)
+	for i := 0; i < payloadWindow*2; i++ {
+		p.observe("push", i)
+	}
+	if got := len(p.sizes["push"]); got != payloadWindow {
+		t.Errorf("kept %d sizes, want %d", got, payloadWindow)
+	}
+	if got := p.sizes["push"][0]; got != payloadWindow {
+		t.Errorf("oldest size kept is %d, want %d", got, payloadWindow)
+	}
+}
+
+func TestPayloadStatsDeliveries(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	e := newTestEnv(t, gh.URL)
+	e.payloads = newPayloadStats(3)
+	for i := 0; i < payloadMinSamples; i++ {
+		serve(e, newDelivery("sync-all", syncAllBody("api")))
+	}
+	logs := captureLog(t)
+	repos := make([]string, 500)
+	for i := range repos {
+		repos[i] = "api"
+	}
+	serve(e, newDelivery("sync-all", syncAllBody(repos...)))
+	if !strings.Contains(logs.String(), "warning: sync-all payload of ") {
+		t.Errorf("no warning for a huge sync-all:\n%s", logs.String())
+	}
+}
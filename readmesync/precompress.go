@@ -0,0 +1,91 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw, err := gzip.NewWriterLevel(dst, gzip.BestCompression)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+	}
+	return err
+}
+
+// precompress writes a gzipped copy alongside every file in dir with one
+// of extensions, so a static server can serve them without compressing
+// on the fly. Files whose copy is already up to date are skipped.
+func precompress(dir string, extensions []string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, ".gz") {
+			return nil
+		}
+		matched := false
+		for _, ext := range extensions {
+			if strings.EqualFold(filepath.Ext(path), ext) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil
+		}
+		if gz, err := os.Stat(path + ".gz"); err == nil && !gz.ModTime().Before(info.ModTime()) {
+			return nil
+		}
+		return gzipFile(path)
+	})
+}
+
+// precompressSites precompresses the output dir of every site repos were
+// just built into. A site whose output dir isn't known, because its config
+// sets its own, is skipped.
+func (e env) precompressSites(repos []string) error {
+	done := map[string]bool{}
+	for _, config := range e.sitesFor(repos) {
+		dir, ok := e.outputDir(config)
+		if !ok {
+			log.Println("precompress: skipping " + config + ", its output dir isn't in OUTPUT_DIRS_FILE")
+			continue
+		}
+		if done[dir] {
+			continue
+		}
+		done[dir] = true
+		unlock := e.siteLocks.lock(e.siteKey(config))
+		err := precompress(dir, e.precompressExts)
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
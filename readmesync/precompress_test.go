@@ -0,0 +1,129 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readGzip(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestPrecompress(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]bool{
+		"index.html":          true,
+		"api/index.html":      true,
+		"css/style.CSS":       true,
+		"js/app.js":           true,
+		"images/logo.png":     false,
+		"robots.txt":          false,
+		"api/index.xml":       false,
+		"archive/old.html.gz": false,
+	}
+	for name := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("content of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := precompress(dir, []string{".html", ".css", ".js"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range files {
+		gz := filepath.Join(dir, name+".gz")
+		_, err := os.Stat(gz)
+		if got := err == nil; got != want {
+			t.Errorf("%s: got a .gz companion %v, want %v", name, got, want)
+			continue
+		}
+		if want && readGzip(t, gz) != "content of "+name {
+			t.Errorf("%s: .gz companion doesn't match", name)
+		}
+	}
+
+	// Companions are only rewritten for files that have changed since.
+	index := filepath.Join(dir, "index.html")
+	js := filepath.Join(dir, "js", "app.js")
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(js+".gz", old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(js, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(index, []byte("rebuilt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := precompress(dir, []string{".html", ".css", ".js"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readGzip(t, index+".gz"); got != "rebuilt" {
+		t.Errorf("got stale companion %q for a rebuilt file", got)
+	}
+	if info, err := os.Stat(js + ".gz"); err != nil || !info.ModTime().Equal(old) {
+		t.Error("rewrote the companion of an unchanged file")
+	}
+}
+
+func TestPrecompressAfterBuild(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		configs map[string]string
+		dirs    map[string]string
+		wantDir string
+	}{
+		{name: "default site", wantDir: "public"},
+		{
+			name:    "site of its own",
+			configs: map[string]string{"api": "api.toml"},
+			dirs:    map[string]string{"api.toml": "public-api"},
+			wantDir: "public-api",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			// Hugo builds into --destination, if it's given, or public.
+			e.hugoCmd = fakeHugo(t, `dest=public
+while [ $# -gt 0 ]; do
+	if [ "$1" = --destination ]; then dest=$2; fi
+	shift
+done
+mkdir -p "$dest/api" && echo '<h1>api</h1>' > "$dest/api/index.html"`)
+			e.repoConfigs = tc.configs
+			e.outputDirs = tc.dirs
+			e.precompress = true
+			e.precompressExts = []string{".html"}
+			if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			if got := readGzip(t, filepath.Join(e.hugoSource, tc.wantDir, "api", "index.html.gz")); got != "<h1>api</h1>\n" {
+				t.Errorf("got companion %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// contentSection returns the path of the READMEs within Hugo's content
+// directory, e.g. "project" for an OUTPUT_DIR of /content/project.
+func (e env) contentSection() string {
+	rel, err := filepath.Rel("content", strings.TrimPrefix(filepath.Clean(e.dir), "/"))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return rel
+}
+
+// preview builds a single repo's README at a ref into its own directory
+// under PREVIEW_DIR, which is removed again after PREVIEW_TTL.
+func (e env) preview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !e.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	repo, ref := r.URL.Query().Get("repo"), r.URL.Query().Get("ref")
+	if repo == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("repo is required"))
+		return
+	}
+	if !validRepoRef(repo) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("repo must be a repo name, optionally as owner/repo"))
+		return
+	}
+	repo, err := e.resolveRepo(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	readme, err := e.readme(r.Context(), repo, ref)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	content, err := ioutil.TempDir("", "readmesync-preview-content-")
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(content)
+	page := filepath.Join(content, e.contentSection(), repo+".md")
+	err = os.MkdirAll(filepath.Dir(page), 0755)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Create(page)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	data := e.newPageData(repo, e.transform(r.Context(), repo, ref, readme))
+	data.Version = ref
+	e.enrich(r.Context(), &data)
+	err = e.pageTmpl.Execute(f, data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	dest, err := ioutil.TempDir(e.previewDir, strings.Replace(repo, "/", "-", -1)+"-")
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	path := "/" + filepath.Base(dest) + "/" + repo + "/"
+	args := append(e.hugoArgs(e.configFor(repo)), "--contentDir", content, "--destination", dest)
+	if e.previewBaseURL != "" {
+		args = append(args, "--baseURL", strings.TrimRight(e.previewBaseURL, "/")+"/"+filepath.Base(dest)+"/")
+	}
+	run := e.hugo(args...)
+	if run.err != nil {
+		os.RemoveAll(dest)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	expires := time.Now().Add(e.previewTTL)
+	expirePreview(dest, e.previewTTL)
+
+	b, err := json.Marshal(struct {
+		Path    string    `json:"path"`
+		Expires time.Time `json:"expires"`
+	}{
+		Path:    path,
+		Expires: expires,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// expirePreview removes the preview at dest after ttl, or straight away if
+// it's already up.
+func expirePreview(dest string, ttl time.Duration) {
+	remove := func() {
+		if err := os.RemoveAll(dest); err != nil {
+			log.Println(err)
+		}
+	}
+	if ttl <= 0 {
+		remove()
+		return
+	}
+	time.AfterFunc(ttl, remove)
+}
+
+// sweepPreviews expires the previews left in PREVIEW_DIR from before a
+// restart, whose timers didn't survive it: those older than PREVIEW_TTL
+// straight away, and the rest once they're that old.
+func (e env) sweepPreviews() error {
+	err := os.MkdirAll(e.previewDir, 0755)
+	if err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(e.previewDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		expirePreview(filepath.Join(e.previewDir, entry.Name()), time.Until(entry.ModTime().Add(e.previewTTL)))
+	}
+	return nil
+}
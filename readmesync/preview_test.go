@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// previewHugo is a fakeHugo script that records the pages in the content
+// dir it's given, and writes a page into its destination.
+const previewHugo = `while [ $# -gt 0 ]; do
+	case "$1" in
+	--contentDir) ls "$2"/project >> "$0.pages" ;;
+	--destination) mkdir -p "$2/api" && echo built > "$2/api/index.html" ;;
+	esac
+	shift
+done
+`
+
+func previewRequest(query url.Values) *http.Request {
+	req := httptest.NewRequest("POST", "/preview?"+query.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	return req
+}
+
+func TestPreview(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api@feature", "# api on a branch\n")
+	e := newTestEnv(t, gh.URL)
+	e.adminToken = "admin"
+	e.hugoCmd = fakeHugo(t, previewHugo)
+	e.previewDir = t.TempDir()
+	e.previewTTL = 200 * time.Millisecond
+	w := httptest.NewRecorder()
+	e.preview(w, previewRequest(url.Values{"repo": {"api"}, "ref": {"feature"}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Path    string    `json:"path"`
+		Expires time.Time `json:"expires"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	dir := strings.Split(strings.Trim(resp.Path, "/"), "/")[0]
+	if !strings.HasPrefix(dir, "api-") || resp.Path != "/"+dir+"/api/" {
+		t.Errorf("got path %s, want /api-*/api/", resp.Path)
+	}
+	if time.Until(resp.Expires) > e.previewTTL {
+		t.Errorf("got expiry %s, want within %s", resp.Expires, e.previewTTL)
+	}
+	built := filepath.Join(e.previewDir, dir, "api", "index.html")
+	if _, err := os.Stat(built); err != nil {
+		t.Fatalf("preview wasn't built: %v", err)
+	}
+	pages, err := ioutil.ReadFile(e.hugoCmd + ".pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(pages)) != "api.md" {
+		t.Errorf("got content %q, want just api.md", pages)
+	}
+	if _, err := os.Stat(filepath.Join(e.hugoSource, e.dir, "api.md")); err == nil {
+		t.Error("preview wrote to the site's own content")
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if _, err := os.Stat(filepath.Join(e.previewDir, dir)); os.IsNotExist(err) {
+			return
+		}
+	}
+	t.Error("preview wasn't cleaned up after PREVIEW_TTL")
+}
+
+func TestPreviewRequests(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		method string
+		auth   string
+		query  url.Values
+		orgs   []string
+		want   int
+	}{
+		{name: "no repo", query: url.Values{}, want: http.StatusBadRequest},
+		{name: "traversal", query: url.Values{"repo": {"../../etc"}}, want: http.StatusBadRequest},
+		{name: "dot dot", query: url.Values{"repo": {".."}}, want: http.StatusBadRequest},
+		{name: "absolute path", query: url.Values{"repo": {"/etc"}}, want: http.StatusBadRequest},
+		{name: "other org", query: url.Values{"repo": {"someone/api"}}, want: http.StatusBadRequest},
+		{name: "bare name with several orgs", query: url.Values{"repo": {"api"}}, orgs: []string{"darlinggo", "paddycarver"}, want: http.StatusBadRequest},
+		{name: "unauthorized", auth: "Bearer wrong", query: url.Values{"repo": {"api"}}, want: http.StatusUnauthorized},
+		{name: "GET", method: "GET", query: url.Values{"repo": {"api"}}, want: http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.adminToken = "admin"
+			e.previewDir = t.TempDir()
+			if tc.orgs != nil {
+				e.orgs = tc.orgs
+			}
+			req := previewRequest(tc.query)
+			if tc.method != "" {
+				req.Method = tc.method
+			}
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			w := httptest.NewRecorder()
+			e.preview(w, req)
+			if w.Code != tc.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) > 0 {
+				t.Errorf("hugo ran %d times, want none", len(runs))
+			}
+			if left, _ := ioutil.ReadDir(e.previewDir); len(left) > 0 {
+				t.Errorf("left %d entries in PREVIEW_DIR", len(left))
+			}
+		})
+	}
+}
+
+// TestSweepPreviews checks that previews left from before a restart are
+// removed once they expire, even though their timers are gone.
+func TestSweepPreviews(t *testing.T) {
+	e := newTestEnv(t, "http://github.invalid")
+	e.previewDir = t.TempDir()
+	e.previewTTL = 200 * time.Millisecond
+	for name, age := range map[string]time.Duration{"api-expired": time.Hour, "api-fresh": 0} {
+		dir := filepath.Join(e.previewDir, name)
+		if err := os.MkdirAll(filepath.Join(dir, "api"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		then := time.Now().Add(-age)
+		if err := os.Chtimes(dir, then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.sweepPreviews(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(e.previewDir, "api-expired")); !os.IsNotExist(err) {
+		t.Errorf("expired preview wasn't removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(e.previewDir, "api-fresh")); err != nil {
+		t.Errorf("preview removed before it expired: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := os.Stat(filepath.Join(e.previewDir, "api-fresh"))
+		if os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("preview wasn't removed once it expired")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"log"
+	"strconv"
+)
+
+// progressWriter logs a running byte count for name each time another
+// every bytes have been written through it. Large writes are passed on in
+// pieces, so each interval is logged as it's crossed.
+type progressWriter struct {
+	w       io.Writer
+	name    string
+	every   int64
+	written int64
+	next    int64
+}
+
+func newProgressWriter(w io.Writer, name string, every int64) *progressWriter {
+	return &progressWriter{w: w, name: name, every: every, next: every}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	if p.every <= 0 {
+		n, err := p.w.Write(b)
+		p.written += int64(n)
+		return n, err
+	}
+	var total int
+	for len(b) > 0 {
+		chunk := b
+		if left := p.next - p.written; int64(len(chunk)) > left {
+			chunk = b[:left]
+		}
+		n, err := p.w.Write(chunk)
+		total += n
+		p.written += int64(n)
+		if p.written >= p.next {
+			log.Println(p.name + ": wrote " + strconv.FormatInt(p.written, 10) + " bytes")
+			p.next += p.every
+		}
+		if err != nil {
+			return total, err
+		}
+		b = b[n:]
+	}
+	return total, nil
+}
+
+func logBatchProgress(done, total, every int) {
+	if every <= 0 || total <= 1 {
+		return
+	}
+	if done%every == 0 || done == total {
+		log.Println("wrote " + strconv.Itoa(done) + "/" + strconv.Itoa(total) + " READMEs")
+	}
+}
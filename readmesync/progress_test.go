@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestBatchProgress(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		repos int
+		every int
+		want  []string
+	}{
+		{name: "off", repos: 5},
+		{name: "every 2", repos: 5, every: 2, want: []string{"wrote 2/5", "wrote 4/5", "wrote 5/5"}},
+		{name: "every 5", repos: 5, every: 5, want: []string{"wrote 5/5"}},
+		{name: "every 1", repos: 3, every: 1, want: []string{"wrote 1/3", "wrote 2/3", "wrote 3/3"}},
+		{name: "single repo", repos: 1, every: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			var repos []string
+			for i := 0; i < tc.repos; i++ {
+				repo := fmt.Sprintf("repo%d", i)
+				gh.setReadme("darlinggo/"+repo, "# "+repo+"\n")
+				repos = append(repos, repo)
+			}
+			e := newTestEnv(t, gh.URL)
+			e.progressEvery = tc.every
+			logs := captureLog(t)
+			if w := serve(e, newDelivery("sync-all", syncAllBody(repos...))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			var got []string
+			for _, m := range regexp.MustCompile(`wrote \d+/\d+`).FindAllString(logs.String(), -1) {
+				got = append(got, m)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got progress %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		every  int64
+		writes []int
+		want   []string
+	}{
+		{name: "off", writes: []int{100, 100}},
+		{name: "small writes", every: 10, writes: []int{4, 4, 4, 4, 4}, want: []string{"wrote 10 bytes", "wrote 20 bytes"}},
+		{name: "large write", every: 10, writes: []int{35, 2, 4}, want: []string{"wrote 10 bytes", "wrote 20 bytes", "wrote 30 bytes", "wrote 40 bytes"}},
+		{name: "under the interval", every: 100, writes: []int{50}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			logs := captureLog(t)
+			pw := newProgressWriter(ioutil.Discard, "api", tc.every)
+			for _, n := range tc.writes {
+				if _, err := pw.Write([]byte(strings.Repeat("x", n))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var got []string
+			for _, m := range regexp.MustCompile(`api: (wrote \d+ bytes)`).FindAllStringSubmatch(logs.String(), -1) {
+				got = append(got, m[1])
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got progress %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLargePageProgress(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n\n"+strings.Repeat("Lots of documentation.\n", 1000))
+	e := newTestEnv(t, gh.URL)
+	e.progressBytes = 4096
+	logs := captureLog(t)
+	if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	if n := len(regexp.MustCompile(`api: wrote \d+ bytes`).FindAllString(logs.String(), -1)); n < 5 {
+		t.Errorf("got %d byte counts for a 23KB page, want at least 5:\n%s", n, logs)
+	}
+}
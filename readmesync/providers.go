@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+)
+
+// provider knows how a particular forge delivers webhooks: which headers
+// carry the event and signature, and what the payload looks like.
+type provider interface {
+	// event returns the event named by r's headers, using GitHub's event
+	// names.
+	event(r *http.Request) string
+	// verifier returns the verifier for deliveries signed with secret.
+	verifier(secret []byte) verifier
+	// normalize converts body into the shape of the equivalent GitHub
+	// payload.
+	normalize(body []byte) ([]byte, error)
+}
+
+// providerFor picks the provider that sent r, based on its headers.
+// requireBoth is passed on to GitHub's verifier.
+func providerFor(r *http.Request, requireBoth bool) provider {
+	switch {
+	case r.Header.Get("X-Gitea-Event") != "":
+		return gitea{}
+	case r.Header.Get("X-Gitlab-Event") != "":
+		return gitlab{}
+	}
+	return github{requireBoth: requireBoth}
+}
+
+type github struct {
+	requireBoth bool
+}
+
+func (github) event(r *http.Request) string {
+	return r.Header.Get("X-Github-Event")
+}
+
+func (g github) verifier(secret []byte) verifier {
+	return githubVerifier{
+		sha256:      hmacVerifier{header: "X-Hub-Signature-256", prefix: "sha256=", hash: sha256.New, secret: secret},
+		sha1:        hmacVerifier{header: "X-Hub-Signature", prefix: "sha1=", hash: sha1.New, secret: secret},
+		requireBoth: g.requireBoth,
+	}
+}
+
+func (github) normalize(body []byte) ([]byte, error) {
+	return body, nil
+}
+
+// gitea payloads are already GitHub-compatible, but are signed with a bare
+// hex HMAC-SHA256 in X-Gitea-Signature.
+type gitea struct{}
+
+func (gitea) event(r *http.Request) string {
+	return r.Header.Get("X-Gitea-Event")
+}
+
+func (gitea) verifier(secret []byte) verifier {
+	return hmacVerifier{header: "X-Gitea-Signature", hash: sha256.New, secret: secret}
+}
+
+func (gitea) normalize(body []byte) ([]byte, error) {
+	return body, nil
+}
+
+// gitlab sends the shared secret itself in X-Gitlab-Token, and names the
+// repo as a project.
+type gitlab struct{}
+
+func (gitlab) event(r *http.Request) string {
+	switch r.Header.Get("X-Gitlab-Event") {
+	case "Push Hook", "Tag Push Hook":
+		return "push"
+	}
+	return ""
+}
+
+func (gitlab) verifier(secret []byte) verifier {
+	return tokenVerifier{header: "X-Gitlab-Token", secret: secret}
+}
+
+func (gitlab) normalize(body []byte) ([]byte, error) {
+	var payload struct {
+		Ref      string   `json:"ref"`
+		After    string   `json:"after"`
+		UserName string   `json:"user_username"`
+		Commits  []commit `json:"commits"`
+		Project  struct {
+			Name              string `json:"name"`
+			PathWithNamespace string `json:"path_with_namespace"`
+			Namespace         string `json:"namespace"`
+			WebURL            string `json:"web_url"`
+		} `json:"project"`
+	}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, err
+	}
+	var req request
+	req.Ref = payload.Ref
+	req.After = payload.After
+	req.Sender.Login = payload.UserName
+	req.Commits = payload.Commits
+	req.Repository.Name = payload.Project.Name
+	req.Repository.FullName = payload.Project.PathWithNamespace
+	req.Repository.URL = payload.Project.WebURL
+	req.Repository.Owner.Login = payload.Project.Namespace
+	return json.Marshal(req)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const gitlabPush = `{
+	"object_kind": "push",
+	"ref": "refs/heads/master",
+	"after": "0123456789abcdef",
+	"user_username": "octocat",
+	"project": {"name": "api", "path_with_namespace": "darlinggo/api", "namespace": "darlinggo", "web_url": "https://gitlab.example/darlinggo/api"},
+	"commits": []
+}`
+
+func TestProviders(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		headers  func(body string) map[string]string
+		body     string
+		want     int
+		wantSync bool
+	}{
+		{
+			name: "github",
+			headers: func(body string) map[string]string {
+				return map[string]string{
+					"X-Github-Event":      "push",
+					"X-Hub-Signature-256": "sha256=" + sign(sha256.New, []byte(body), []byte(testSecret)),
+				}
+			},
+			body:     pushBody("api", "refs/heads/master"),
+			want:     http.StatusOK,
+			wantSync: true,
+		},
+		{
+			name: "gitea",
+			headers: func(body string) map[string]string {
+				return map[string]string{
+					"X-Gitea-Event":     "push",
+					"X-Gitea-Signature": sign(sha256.New, []byte(body), []byte(testSecret)),
+				}
+			},
+			body:     pushBody("api", "refs/heads/master"),
+			want:     http.StatusOK,
+			wantSync: true,
+		},
+		{
+			name: "gitea with a bad signature",
+			headers: func(body string) map[string]string {
+				return map[string]string{
+					"X-Gitea-Event":     "push",
+					"X-Gitea-Signature": sign(sha256.New, []byte(body), []byte("wrong")),
+				}
+			},
+			body: pushBody("api", "refs/heads/master"),
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "gitea with a GitHub signature",
+			headers: func(body string) map[string]string {
+				return map[string]string{
+					"X-Gitea-Event":       "push",
+					"X-Hub-Signature-256": "sha256=" + sign(sha256.New, []byte(body), []byte(testSecret)),
+				}
+			},
+			body: pushBody("api", "refs/heads/master"),
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "gitlab",
+			headers: func(string) map[string]string {
+				return map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": testSecret}
+			},
+			body:     gitlabPush,
+			want:     http.StatusOK,
+			wantSync: true,
+		},
+		{
+			name: "gitlab with the wrong token",
+			headers: func(string) map[string]string {
+				return map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}
+			},
+			body: gitlabPush,
+			want: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			req := httptest.NewRequest("POST", "/hook", strings.NewReader(tc.body))
+			for k, v := range tc.headers(tc.body) {
+				req.Header.Set(k, v)
+			}
+			if w := serve(e, req); w.Code != tc.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			_, err := os.Stat(filepath.Join(e.hugoSource, e.dir, "api.md"))
+			if got := err == nil; got != tc.wantSync {
+				t.Errorf("synced: got %v, want %v", got, tc.wantSync)
+			}
+		})
+	}
+}
+
+func TestGitlabNormalize(t *testing.T) {
+	b, err := gitlab{}.normalize([]byte(gitlabPush))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req request
+	if err := json.Unmarshal(b, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Ref != "refs/heads/master" || req.After != "0123456789abcdef" || req.Sender.Login != "octocat" {
+		t.Errorf("got ref %q, after %q, sender %q", req.Ref, req.After, req.Sender.Login)
+	}
+	if req.Repository.Name != "api" || req.Repository.FullName != "darlinggo/api" || req.Repository.Owner.Login != "darlinggo" {
+		t.Errorf("got repository %+v", req.Repository)
+	}
+}
+
+func TestGitlabEvents(t *testing.T) {
+	for header, want := range map[string]string{
+		"Push Hook":          "push",
+		"Tag Push Hook":      "push",
+		"Merge Request Hook": "",
+	} {
+		r := httptest.NewRequest("POST", "/hook", nil)
+		r.Header.Set("X-Gitlab-Event", header)
+		if got := (gitlab{}).event(r); got != want {
+			t.Errorf("%s: got event %q, want %q", header, got, want)
+		}
+	}
+}
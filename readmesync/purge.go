@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	purgeGeneric    = "generic"
+	purgeCloudflare = "cloudflare"
+)
+
+// purge asks the CDN at CDN_PURGE_URL to drop its copies of the pages that
+// were just rebuilt.
+func (e env) purge(pages []string) error {
+	paths := make([]string, 0, len(pages))
+	for _, page := range pages {
+		paths = append(paths, "/"+page)
+	}
+	sort.Strings(paths)
+	var payload interface{}
+	switch e.purgeProvider {
+	case purgeCloudflare:
+		files := make([]string, 0, len(paths))
+		for _, path := range paths {
+			files = append(files, strings.TrimRight(e.purgeBaseURL, "/")+path)
+		}
+		payload = map[string][]string{"files": files}
+	default:
+		payload = map[string][]string{"paths": paths}
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.purgeURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.purgeAuth != "" {
+		req.Header.Set("Authorization", e.purgeAuth)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("cdn purge: non-2xx status: " + resp.Status + ": " + string(body))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fakeCDN records the purge requests sent to it.
+type fakeCDN struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	auth     []string
+	payloads []map[string][]string
+}
+
+func newFakeCDN(t *testing.T) *fakeCDN {
+	c := &fakeCDN{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		var payload map[string][]string
+		if err := json.Unmarshal(b, &payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.auth = append(c.auth, r.Header.Get("Authorization"))
+		c.payloads = append(c.payloads, payload)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestPurge(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		provider string
+		hugo     string
+		want     []map[string][]string
+	}{
+		{
+			name: "generic",
+			want: []map[string][]string{{"paths": {"/api", "/hash"}}},
+		},
+		{
+			name:     "cloudflare",
+			provider: purgeCloudflare,
+			want:     []map[string][]string{{"files": {"https://darlinggo.example/api", "https://darlinggo.example/hash"}}},
+		},
+		{
+			name: "failed build",
+			hugo: "exit 1\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			gh.setReadme("darlinggo/hash", "# hash\n")
+			cdn := newFakeCDN(t)
+			e := newTestEnv(t, gh.URL)
+			e.hugoCmd = fakeHugo(t, tc.hugo)
+			e.purgeURL = cdn.URL
+			e.purgeProvider = tc.provider
+			e.purgeBaseURL = "https://darlinggo.example/"
+			e.purgeAuth = "Bearer cdn-token"
+			serve(e, newDelivery("sync-all", syncAllBody("hash", "api")))
+			cdn.mu.Lock()
+			defer cdn.mu.Unlock()
+			if !reflect.DeepEqual(cdn.payloads, tc.want) {
+				t.Errorf("got purges %v, want %v", cdn.payloads, tc.want)
+			}
+			for _, auth := range cdn.auth {
+				if auth != "Bearer cdn-token" {
+					t.Errorf("got Authorization %q, want %q", auth, "Bearer cdn-token")
+				}
+			}
+		})
+	}
+}
+
+func TestPurgeFailure(t *testing.T) {
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("bad token"))
+	}))
+	defer cdn.Close()
+	e := newTestEnv(t, "http://github.invalid")
+	e.purgeURL = cdn.URL
+	if err := e.purge([]string{"api"}); err == nil {
+		t.Error("got no error for a 403")
+	}
+}
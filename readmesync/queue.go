@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queuedDelivery is a verified webhook delivery waiting to be processed.
+type queuedDelivery struct {
+	Event    string `json:"event"`
+	Delivery string `json:"delivery,omitempty"`
+	// URL is the URL the delivery was made to, query and all.
+	URL string `json:"url"`
+	// Repo is the full name of the repo the delivery is about, if it's
+	// about just one.
+	Repo string          `json:"repo,omitempty"`
+	Body json.RawMessage `json:"body"`
+}
+
+// deliveryQueue is a queue of deliveries kept on disk in dir, one file
+// each, so none are lost if readmesync restarts before they're processed.
+// They're processed one at a time, and each is only removed once it has
+// been handled successfully, build and all. Deliveries about the same repo
+// are handled in the order they arrived: one that fails holds up the
+// later deliveries for its repo until it's retried, but not those for any
+// other. One that still fails after attempts tries is moved to
+// dir/failed, rather than holding up the rest forever.
+type deliveryQueue struct {
+	dir        string
+	attempts   int
+	retryDelay time.Duration
+
+	mu   sync.Mutex
+	seq  int
+	wake chan struct{}
+
+	// retries are the deliveries that have failed, by path. Only run
+	// uses it.
+	retries map[string]retry
+}
+
+// retry is how many times a queued delivery has failed, and when it's
+// next due to be tried.
+type retry struct {
+	failures int
+	at       time.Time
+}
+
+func newDeliveryQueue(dir string, attempts int, retryDelay time.Duration) (*deliveryQueue, error) {
+	err := os.MkdirAll(filepath.Join(dir, "failed"), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &deliveryQueue{dir: dir, attempts: attempts, retryDelay: retryDelay, wake: make(chan struct{}, 1), retries: map[string]retry{}}, nil
+}
+
+// enqueue writes d to the queue, returning once it's safely on disk.
+func (q *deliveryQueue) enqueue(d queuedDelivery) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	q.seq++
+	// Names sort in arrival order: the time, then a sequence number for
+	// deliveries that arrive within the same nanosecond.
+	name := padded(time.Now().UnixNano(), 20) + "-" + padded(int64(q.seq), 10) + ".json"
+	q.mu.Unlock()
+	tmp, err := ioutil.TempFile(q.dir, ".delivery-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(q.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// padded formats n with leading zeros to width digits.
+func padded(n int64, width int) string {
+	s := strconv.FormatInt(n, 10)
+	if len(s) >= width {
+		return s
+	}
+	return strings.Repeat("0", width-len(s)) + s
+}
+
+// pending returns the paths of the deliveries in the queue, oldest first.
+// ReadDir sorts them by name, which is by arrival.
+func (q *deliveryQueue) pending() ([]string, error) {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		paths = append(paths, filepath.Join(q.dir, f.Name()))
+	}
+	return paths, nil
+}
+
+// run processes the queue with handle forever, starting with whatever was
+// left in it from before a restart. handle reports whether it succeeded.
+func (q *deliveryQueue) run(handle func(queuedDelivery) bool) {
+	for {
+		paths, err := q.pending()
+		if err != nil {
+			log.Println("queue:", err)
+		}
+		// held are the repos with a delivery waiting to be retried, whose
+		// later deliveries have to wait for it.
+		held := map[string]bool{}
+		var next time.Time
+		done := false
+		for _, path := range paths {
+			d, ok := q.read(path)
+			if !ok {
+				done = true
+				continue
+			}
+			if held[d.Repo] {
+				continue
+			}
+			r, failed := q.retries[path]
+			if !failed || !time.Now().Before(r.at) {
+				if q.process(path, d, handle) {
+					done = true
+					continue
+				}
+				r = q.retries[path]
+			}
+			held[d.Repo] = true
+			if next.IsZero() || r.at.Before(next) {
+				next = r.at
+			}
+		}
+		if done {
+			continue
+		}
+		if next.IsZero() {
+			<-q.wake
+			continue
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// read reads the delivery at path, setting it aside if it's unreadable.
+func (q *deliveryQueue) read(path string) (queuedDelivery, bool) {
+	var d queuedDelivery
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(b, &d)
+	}
+	if err != nil {
+		log.Println("queue: "+filepath.Base(path)+" is unreadable, setting it aside:", err)
+		q.setAside(path)
+		return d, false
+	}
+	return d, true
+}
+
+// process tries handling d, the delivery at path, and reports whether
+// it's finished with: handled, or set aside after its last attempt. If
+// it isn't, it's due to be tried again once retryDelay has passed.
+func (q *deliveryQueue) process(path string, d queuedDelivery, handle func(queuedDelivery) bool) bool {
+	if handle(d) {
+		delete(q.retries, path)
+		err := os.Remove(path)
+		if err != nil {
+			log.Println("queue:", err)
+		}
+		return true
+	}
+	r := q.retries[path]
+	r.failures++
+	if r.failures >= q.attempts {
+		log.Println("queue: " + d.Event + " delivery " + filepath.Base(path) + " failed " + strconv.Itoa(r.failures) + " times, setting it aside")
+		delete(q.retries, path)
+		q.setAside(path)
+		return true
+	}
+	log.Println("queue: " + d.Event + " delivery " + filepath.Base(path) + " failed, retrying in " + q.retryDelay.String())
+	r.at = time.Now().Add(q.retryDelay)
+	q.retries[path] = r
+	return false
+}
+
+func (q *deliveryQueue) setAside(path string) {
+	err := os.Rename(path, filepath.Join(q.dir, "failed", filepath.Base(path)))
+	if err != nil {
+		log.Println("queue:", err)
+	}
+}
+
+// replay processes a delivery from the queue just as though it had only
+// now arrived, short of counting it again: it's already been verified,
+// and counted in the metrics, the payload stats and the audit log. It's
+// answered here rather than queued again, and its build always finishes
+// before it counts as handled, even with BUILD_ASYNC set.
+func (e env) replay(d queuedDelivery) bool {
+	e.queue = nil
+	e.waitForBuilds = true
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		log.Println("queue:", err)
+		return false
+	}
+	resp := &bufferedResponse{header: http.Header{}, code: http.StatusOK}
+	e.handle(resp, req, d.Event, d.Body, &auditEntry{})
+	if resp.code >= 300 {
+		log.Println("queue: " + d.Event + " delivery got " + strconv.Itoa(resp.code) + ": " + resp.body.String())
+		return false
+	}
+	return true
+}
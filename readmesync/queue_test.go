@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runQueue runs q with handle until every delivery has been handled or
+// set aside, and returns the events of the deliveries handle was given.
+func runQueue(t *testing.T, q *deliveryQueue, handle func(queuedDelivery) bool) []string {
+	t.Helper()
+	var mu sync.Mutex
+	var handled []string
+	go q.run(func(d queuedDelivery) bool {
+		mu.Lock()
+		handled = append(handled, d.Delivery)
+		mu.Unlock()
+		return handle(d)
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		paths, err := q.pending()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d deliveries still queued", len(paths))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]string{}, handled...)
+}
+
+func TestQueueOrder(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newDeliveryQueue(dir, 1, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, id := range []string{"api-1", "hash-1", "api-2", "api-3", "hash-2"} {
+		if err := q.enqueue(queuedDelivery{Event: "push", Delivery: id, Body: json.RawMessage(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, id)
+	}
+	if got := runQueue(t, q, func(d queuedDelivery) bool { return true }); !reflect.DeepEqual(got, want) {
+		t.Errorf("handled %q, want %q", got, want)
+	}
+}
+
+// TestQueueRestart checks that deliveries queued before a restart are
+// handled, in order, once the queue is opened again.
+func TestQueueRestart(t *testing.T) {
+	dir := t.TempDir()
+	before, err := newDeliveryQueue(dir, 1, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if err := before.enqueue(queuedDelivery{Event: "push", Delivery: id, Body: json.RawMessage(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A delivery only half written when readmesync stopped is ignored.
+	if err := ioutil.WriteFile(filepath.Join(dir, ".delivery-partial"), []byte(`{"eve`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	after, err := newDeliveryQueue(dir, 1, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := runQueue(t, after, func(d queuedDelivery) bool { return true }), []string{"1", "2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("handled %q, want %q", got, want)
+	}
+}
+
+func TestQueueFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		failures     int
+		wantAttempts int
+		wantAside    bool
+	}{
+		{name: "succeeds", wantAttempts: 1},
+		{name: "succeeds on a retry", failures: 2, wantAttempts: 3},
+		{name: "set aside", failures: 5, wantAttempts: 3, wantAside: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q, err := newDeliveryQueue(dir, 3, time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []string{"first", "second"} {
+				if err := q.enqueue(queuedDelivery{Event: "push", Delivery: id, Body: json.RawMessage(`{}`)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			attempts := 0
+			handled := runQueue(t, q, func(d queuedDelivery) bool {
+				if d.Delivery != "first" {
+					return true
+				}
+				attempts++
+				return attempts > tc.failures
+			})
+			if attempts != tc.wantAttempts {
+				t.Errorf("got %d attempts, want %d", attempts, tc.wantAttempts)
+			}
+			// The second delivery waits for the first.
+			if last := handled[len(handled)-1]; last != "second" {
+				t.Errorf("handled %q, want second last", handled)
+			}
+			aside, err := filepath.Glob(filepath.Join(dir, "failed", "*.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(aside) == 1; got != tc.wantAside {
+				t.Errorf("set aside %v, want %v", aside, tc.wantAside)
+			}
+		})
+	}
+}
+
+// TestQueueRepoOrder checks that a failing delivery holds up only the
+// later deliveries for its own repo.
+func TestQueueRepoOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		failures int
+		want     map[string][]string
+	}{
+		{
+			name:     "succeeds on a retry",
+			failures: 2,
+			want:     map[string][]string{"darlinggo/api": {"api-1", "api-2", "api-3"}, "darlinggo/hash": {"hash-1", "hash-2"}},
+		},
+		{
+			name:     "set aside",
+			failures: 5,
+			want:     map[string][]string{"darlinggo/api": {"api-1", "api-2", "api-3"}, "darlinggo/hash": {"hash-2"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := newDeliveryQueue(t.TempDir(), 3, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []string{"api-1", "hash-1", "api-2", "hash-2", "api-3"} {
+				repo := "darlinggo/" + strings.SplitN(id, "-", 2)[0]
+				if err := q.enqueue(queuedDelivery{Event: "push", Delivery: id, Repo: repo, Body: json.RawMessage(`{}`)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var mu sync.Mutex
+			failures := 0
+			got := map[string][]string{}
+			var apiDone, hashRetried time.Time
+			runQueue(t, q, func(d queuedDelivery) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				if d.Delivery == "hash-1" {
+					if failures > 0 && hashRetried.IsZero() {
+						hashRetried = time.Now()
+					}
+					if failures < tc.failures {
+						failures++
+						return false
+					}
+				}
+				got[d.Repo] = append(got[d.Repo], d.Delivery)
+				if d.Delivery == "api-3" {
+					apiDone = time.Now()
+				}
+				return true
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("handled %q, want %q", got, tc.want)
+			}
+			if apiDone.IsZero() || !apiDone.Before(hashRetried) {
+				t.Errorf("api was held up by hash-1 failing")
+			}
+		})
+	}
+}
+
+func TestQueueUnreadable(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newDeliveryQueue(dir, 3, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "00000000000000000001-0000000001.json"), []byte("not JSON"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if handled := runQueue(t, q, func(d queuedDelivery) bool { return true }); len(handled) != 0 {
+		t.Errorf("handled %q", handled)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "failed", "00000000000000000001-0000000001.json")); err != nil {
+		t.Errorf("unreadable delivery wasn't set aside: %v", err)
+	}
+}
+
+// TestQueuedDeliveries checks deliveries through the handler: each is
+// answered as queued, and only processed, build and all, from the queue.
+func TestQueuedDeliveries(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	gh.setReadme("darlinggo/hash", "# hash\n")
+	e := newTestEnv(t, gh.URL)
+	var err error
+	e.queue, err = newDeliveryQueue(t.TempDir(), 1, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []*http.Request{
+		newDelivery("push", pushBody("api", "refs/heads/master")),
+		newDelivery("sync-all", syncAllBody("hash")),
+	} {
+		w := serve(e, req)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusAccepted)
+		}
+		if ctype := w.Header().Get("Content-Type"); ctype != "application/json" {
+			t.Errorf("got Content-Type %q, want application/json", ctype)
+		}
+		var queued struct {
+			Queued bool `json:"queued"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &queued); err != nil || !queued.Queued {
+			t.Errorf("got body %q, want it to say it was queued", w.Body.String())
+		}
+	}
+	if runs := hugoRuns(t, e.hugoCmd); len(runs) != 0 {
+		t.Errorf("hugo ran %d times before the queue was processed", len(runs))
+	}
+	runQueue(t, e.queue, e.replay)
+	// The last delivery is removed from the queue only after it's built.
+	if runs := hugoRuns(t, e.hugoCmd); len(runs) != 2 {
+		t.Errorf("hugo ran %d times, want 2", len(runs))
+	}
+	readPage(t, e, "api")
+	readPage(t, e, "hash")
+}
+
+// TestQueuedDeliveryReplay checks that a queued delivery is replayed with
+// the URL it was made to, and isn't counted a second time when it is.
+func TestQueuedDeliveryReplay(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	e := newTestEnv(t, gh.URL)
+	e.readmeChangesOnly = true
+	e.metrics = newMetrics()
+	e.payloads = newPayloadStats(3)
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	var err error
+	if e.audit, err = openAuditLog(auditPath); err != nil {
+		t.Fatal(err)
+	}
+	if e.queue, err = newDeliveryQueue(t.TempDir(), 1, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]interface{}
+	json.Unmarshal([]byte(pushBody("api", "refs/heads/master")), &payload)
+	payload["commits"] = []map[string]interface{}{{"modified": []string{"main.go"}}}
+	body, _ := json.Marshal(payload)
+	req := newDelivery("push", string(body))
+	req.URL.RawQuery = "force=1"
+	if w := serve(e, req); w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	runQueue(t, e.queue, e.replay)
+	// The push doesn't touch the README, so only force syncs it.
+	readPage(t, e, "api")
+	if got := e.metrics.webhooks["push"]; got != 1 {
+		t.Errorf("counted %d push webhooks, want 1", got)
+	}
+	if got := len(e.payloads.sizes["push"]); got != 1 {
+		t.Errorf("observed %d push payloads, want 1", got)
+	}
+	if entries := readAudit(t, auditPath); len(entries) != 1 || entries[0].Status != http.StatusAccepted {
+		t.Errorf("got audit entries %+v, want just the one for queueing it", entries)
+	}
+}
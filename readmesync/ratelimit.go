@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimit tracks the GitHub API quota, as reported by the most recent
+// response.
+type rateLimit struct {
+	sync.Mutex
+	known     bool
+	remaining int
+	reset     time.Time
+}
+
+// update records the quota from resp's headers, if it has them.
+func (l *rateLimit) update(resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	l.Lock()
+	l.known, l.remaining, l.reset = true, remaining, time.Unix(reset, 0)
+	l.Unlock()
+}
+
+// wait blocks until the quota resets, if it's been used up, or until ctx
+// is done.
+func (l *rateLimit) wait(ctx context.Context) error {
+	l.Lock()
+	exhausted := l.known && l.remaining <= 0
+	until := time.Until(l.reset)
+	l.Unlock()
+	if !exhausted || until <= 0 {
+		return nil
+	}
+	log.Println("GitHub rate limit exhausted, waiting " + until.Round(time.Second).String() + " for it to reset")
+	timer := time.NewTimer(until)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *rateLimit) log() {
+	l.Lock()
+	defer l.Unlock()
+	if l.known {
+		log.Println("GitHub rate limit: " + strconv.Itoa(l.remaining) + " requests remaining until " + l.reset.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimitUpdate(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		remaining     string
+		reset         string
+		wantKnown     bool
+		wantRemaining int
+	}{
+		{name: "both headers", remaining: "42", reset: "1700000000", wantKnown: true, wantRemaining: 42},
+		{name: "no headers"},
+		{name: "no reset", remaining: "42"},
+		{name: "not a number", remaining: "lots", reset: "1700000000"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tc.remaining != "" {
+				resp.Header.Set("X-RateLimit-Remaining", tc.remaining)
+			}
+			if tc.reset != "" {
+				resp.Header.Set("X-RateLimit-Reset", tc.reset)
+			}
+			l := &rateLimit{}
+			l.update(resp)
+			if l.known != tc.wantKnown || l.remaining != tc.wantRemaining {
+				t.Errorf("got known %v, remaining %d, want %v, %d", l.known, l.remaining, tc.wantKnown, tc.wantRemaining)
+			}
+		})
+	}
+}
+
+// TestRateLimitWaits checks that once the quota is used up, the next
+// request waits for it to reset.
+func TestRateLimitWaits(t *testing.T) {
+	reset := time.Now().Truncate(time.Second).Add(2 * time.Second)
+	var mu sync.Mutex
+	var times []time.Time
+	gh := newFakeGitHub(t)
+	for _, repo := range []string{"api", "hash", "site"} {
+		gh.setReadme("darlinggo/"+repo, "# "+repo+"\n")
+	}
+	gh.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		remaining := 2 - len(times)
+		mu.Unlock()
+		if remaining < 0 {
+			remaining = 0
+		}
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		gh.serve(w, r)
+	})
+	e := newTestEnv(t, gh.URL)
+	e.syncConcurrency = 1
+	logs := captureLog(t)
+	if w := serve(e, newDelivery("sync-all", syncAllBody("api", "hash", "site"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	if len(times) != 3 {
+		t.Fatalf("got %d requests, want 3", len(times))
+	}
+	if times[1].After(reset) {
+		t.Errorf("second request waited until %s, before the quota was used up", times[1])
+	}
+	if times[2].Before(reset) {
+		t.Errorf("third request was sent at %s, before the quota reset at %s", times[2], reset)
+	}
+	if !strings.Contains(logs.String(), "GitHub rate limit exhausted") {
+		t.Errorf("didn't log waiting for the rate limit:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "GitHub rate limit: 0 requests remaining") {
+		t.Errorf("didn't log the remaining quota after the sync:\n%s", logs.String())
+	}
+}
+
+// TestRateLimitWaitCancelled checks that waiting for the rate limit stops
+// once the caller gives up, such as when the delivery's connection closes.
+func TestRateLimitWaitCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request sent while the rate limit was exhausted")
+	}))
+	defer srv.Close()
+	e := newTestEnv(t, srv.URL)
+	e.rateLimit.known, e.rateLimit.reset = true, time.Now().Add(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := e.pullReadme(ctx, "darlinggo/api", "")
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("waited %s after the caller gave up", waited)
+	}
+}
+
+// TestRateLimitWaitOutlastsTimeout checks that REQUEST_TIMEOUT doesn't cut
+// short waiting for the rate limit to reset, only the request itself.
+func TestRateLimitWaitOutlastsTimeout(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	e := newTestEnv(t, gh.URL)
+	e.requestTimeout = 50 * time.Millisecond
+	reset := time.Now().Truncate(time.Second).Add(2 * time.Second)
+	e.rateLimit.known, e.rateLimit.reset = true, reset
+	body, err := e.pullReadme(context.Background(), "darlinggo/api", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "# api\n" {
+		t.Errorf("got README %q", body)
+	}
+	if time.Now().Before(reset) {
+		t.Errorf("fetched before the rate limit reset at %s", reset)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// rawReadmeNames are the README filenames tried on the raw host, in the
+// order GitHub itself prefers them.
+var rawReadmeNames = []string{"README.md", "readme.md", "Readme.md", "README.markdown", "README"}
+
+// pullRawReadme fetches pkg's README at ref, or at its default branch if
+// ref is empty, from the raw host instead of the API, so it doesn't count
+// against the rate limit. The raw host can't say which file is the README,
+// so each of rawReadmeNames is tried in turn.
+func (e env) pullRawReadme(ctx context.Context, pkg, ref string) ([]byte, error) {
+	if ref == "" {
+		branch, err := e.defaultBranch(ctx, pkg)
+		if err != nil {
+			return nil, err
+		}
+		ref = branch
+	}
+	fullName := e.fullName(pkg)
+	ctx, cancel := context.WithTimeout(ctx, e.requestTimeout)
+	defer cancel()
+	for _, name := range rawReadmeNames {
+		req, err := http.NewRequestWithContext(ctx, "GET", e.rawHost+"/"+fullName+"/"+ref+"/"+name, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if resp.StatusCode != 200 {
+			return nil, statusError{repo: fullName, code: resp.StatusCode, status: resp.Status}
+		}
+		return body, checkText(fullName, body)
+	}
+	return nil, errors.New(fullName + ": no README found on the raw host at " + ref)
+}
+
+// rawReadme tries the raw host first, falling back to the API for the
+// READMEs it can't find, like those of private repos or with unusual
+// names.
+func (e env) rawReadme(ctx context.Context, pkg, ref string) ([]byte, bool) {
+	body, err := e.pullRawReadme(ctx, pkg, ref)
+	if err != nil {
+		log.Println(err.Error() + ", falling back to the API")
+		return nil, false
+	}
+	return body, true
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newRawHost stubs the raw host, serving files at their paths and
+// recording the paths it was asked for.
+func newRawHost(t *testing.T, files map[string]string) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		body, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, requested...)
+	}
+}
+
+func TestRawHost(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		files     map[string]string
+		ref       string
+		want      string
+		wantAPI   bool
+		wantFirst string
+	}{
+		{
+			name:      "default branch",
+			files:     map[string]string{"/darlinggo/api/main/README.md": "# api, raw\n"},
+			want:      "# api, raw\n",
+			wantFirst: "/darlinggo/api/main/README.md",
+		},
+		{
+			name:      "lowercase name",
+			files:     map[string]string{"/darlinggo/api/main/readme.md": "# api, lowercase\n"},
+			want:      "# api, lowercase\n",
+			wantFirst: "/darlinggo/api/main/README.md",
+		},
+		{
+			name:      "ref",
+			files:     map[string]string{"/darlinggo/api/v1.0.0/README.md": "# api, v1\n"},
+			ref:       "v1.0.0",
+			want:      "# api, v1\n",
+			wantFirst: "/darlinggo/api/v1.0.0/README.md",
+		},
+		{
+			name:      "not on the raw host",
+			files:     map[string]string{},
+			want:      "# api, from the API\n",
+			wantAPI:   true,
+			wantFirst: "/darlinggo/api/main/README.md",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setRepo("darlinggo/api", `{"default_branch": "main", "size": 1}`)
+			gh.setReadme("darlinggo/api", "# api, from the API\n")
+			raw, requested := newRawHost(t, tc.files)
+			e := newTestEnv(t, gh.URL)
+			// As main sets it up for USE_RAW_HOST.
+			e.useRawHost, e.branchFromAPI, e.rawHost = true, true, raw.URL
+			got, err := e.readme(context.Background(), "api", tc.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+			if paths := requested(); len(paths) == 0 || paths[0] != tc.wantFirst {
+				t.Errorf("got raw host requests %q, want the first to be %q", paths, tc.wantFirst)
+			}
+			usedAPI := false
+			for _, path := range gh.requested() {
+				if strings.HasSuffix(path, "/readme") {
+					usedAPI = true
+				}
+			}
+			if usedAPI != tc.wantAPI {
+				t.Errorf("fetched from the API: got %v, want %v", usedAPI, tc.wantAPI)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".readmesync-ready-")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, err = f.Write([]byte("ok"))
+	closeErr := f.Close()
+	removeErr := os.Remove(name)
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return removeErr
+}
+
+func (e env) checkReady() error {
+	done := make(chan error, 1)
+	go func() {
+		for _, dir := range []string{e.hugoSource, filepath.Join(e.hugoSource, e.dir)} {
+			if err := checkWritable(dir); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(e.readyTimeout):
+		return errors.New("timed out checking directories are writable")
+	}
+}
+
+func (e env) ready(w http.ResponseWriter, r *http.Request) {
+	if err := e.checkReady(); err != nil {
+		log.Println("not ready:", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Write([]byte("ok"))
+}
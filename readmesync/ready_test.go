@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(t *testing.T, output string)
+		want  int
+	}{
+		{
+			name:  "writable",
+			setup: func(t *testing.T, output string) {},
+			want:  http.StatusOK,
+		},
+		{
+			name: "read-only output dir",
+			setup: func(t *testing.T, output string) {
+				if os.Geteuid() == 0 {
+					t.Skip("root can write to read-only directories")
+				}
+				if err := os.Chmod(output, 0555); err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { os.Chmod(output, 0755) })
+			},
+			want: http.StatusServiceUnavailable,
+		},
+		{
+			// Writing into a path that isn't a directory fails for every
+			// user, root included, just as a read-only mount would.
+			name: "output dir unusable",
+			setup: func(t *testing.T, output string) {
+				if err := os.Remove(output); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(output, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: http.StatusServiceUnavailable,
+		},
+		{
+			name: "output dir missing",
+			setup: func(t *testing.T, output string) {
+				if err := os.Remove(output); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: http.StatusServiceUnavailable,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t, "http://github.invalid")
+			e.readyTimeout = 5 * time.Second
+			output := filepath.Join(e.hugoSource, e.dir)
+			if err := os.MkdirAll(output, 0755); err != nil {
+				t.Fatal(err)
+			}
+			tc.setup(t, output)
+			w := httptest.NewRecorder()
+			e.ready(w, httptest.NewRequest("GET", "/ready", nil))
+			if w.Code != tc.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			left, err := filepath.Glob(filepath.Join(e.hugoSource, ".readmesync-ready-*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(left) > 0 {
+				t.Errorf("left behind %v", left)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errRedisNil = errors.New("redis: nil reply")
+
+// redisStore is a store backed by Redis, so several instances can share
+// conditional-request state. It speaks just enough RESP to GET and SET.
+// Entries expire in Redis after ttl, if it's set, rather than being swept.
+type redisStore struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+	timeout  time.Duration
+	ttl      time.Duration
+}
+
+func newRedisStore(rawurl string) (redisStore, error) {
+	s := redisStore{prefix: "readmesync:", timeout: 5 * time.Second}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return s, err
+	}
+	if u.Scheme != "redis" {
+		return s, errors.New("unsupported scheme " + u.Scheme)
+	}
+	s.addr = u.Host
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		s.db, err = strconv.Atoi(db)
+		if err != nil {
+			return s, errors.New("invalid database " + db)
+		}
+	}
+	return s, nil
+}
+
+func writeCommand(w io.Writer, args ...string) error {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func readReply(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, errors.New("redis: " + line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	return nil, errors.New("redis: unexpected reply " + line)
+}
+
+func (s redisStore) do(args ...string) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+	r := bufio.NewReader(conn)
+	var commands [][]string
+	if s.password != "" {
+		commands = append(commands, []string{"AUTH", s.password})
+	}
+	if s.db != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	commands = append(commands, args)
+	var reply []byte
+	for _, command := range commands {
+		err = writeCommand(conn, command...)
+		if err != nil {
+			return nil, err
+		}
+		reply, err = readReply(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+func (s redisStore) get(key string) (cacheEntry, bool, error) {
+	var entry cacheEntry
+	b, err := s.do("GET", s.prefix+key)
+	if err == errRedisNil {
+		return entry, false, nil
+	}
+	if err != nil {
+		return entry, false, err
+	}
+	err = json.Unmarshal(b, &entry)
+	if err != nil {
+		return entry, false, err
+	}
+	return entry, true, nil
+}
+
+func (s redisStore) set(key string, entry cacheEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	args := []string{"SET", s.prefix + key, string(b)}
+	if s.ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(s.ttl/time.Millisecond), 10))
+	}
+	_, err = s.do(args...)
+	return err
+}
+
+func (s redisStore) sweep(cutoff time.Time) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import "strings"
+
+const (
+	refBranch = "branch"
+	refTag    = "tag"
+)
+
+// parseRef splits a fully-qualified ref like refs/heads/master or
+// refs/tags/v1.0.0 into its kind and short name. ok is false unless the
+// short name is one git itself would accept, so crafted refs can't slip
+// past the branch comparison.
+func parseRef(ref string) (kind, name string, ok bool) {
+	switch {
+	case strings.HasPrefix(ref, "refs/heads/"):
+		kind, name = refBranch, strings.TrimPrefix(ref, "refs/heads/")
+	case strings.HasPrefix(ref, "refs/tags/"):
+		kind, name = refTag, strings.TrimPrefix(ref, "refs/tags/")
+	default:
+		return "", "", false
+	}
+	return kind, name, validRefName(name)
+}
+
+// validRefName applies the rules of git check-ref-format to name.
+func validRefName(name string) bool {
+	if name == "" || name == "@" || strings.HasSuffix(name, ".") {
+		return false
+	}
+	if strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return false
+		}
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRef(t *testing.T) {
+	for _, tc := range []struct {
+		ref      string
+		wantKind string
+		wantName string
+		wantOK   bool
+	}{
+		{ref: "refs/heads/master", wantKind: refBranch, wantName: "master", wantOK: true},
+		{ref: "refs/heads/feature/readme", wantKind: refBranch, wantName: "feature/readme", wantOK: true},
+		{ref: "refs/tags/v1.0.0", wantKind: refTag, wantName: "v1.0.0", wantOK: true},
+		{ref: "refs/tags/release/2", wantKind: refTag, wantName: "release/2", wantOK: true},
+		{ref: "master"},
+		{ref: "refs/heads/"},
+		{ref: "refs/pull/1/head"},
+		{ref: "refs/heads/a..b"},
+		{ref: "refs/heads/a b"},
+		{ref: "refs/heads/foo.lock"},
+		{ref: "refs/heads/.hidden"},
+		{ref: "refs/heads/trailing."},
+		{ref: "refs/heads/a//b"},
+		{ref: "refs/heads/@"},
+		{ref: "refs/tags/v1@{0}"},
+	} {
+		t.Run(tc.ref, func(t *testing.T) {
+			kind, name, ok := parseRef(tc.ref)
+			if ok != tc.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tc.wantOK)
+			}
+			if ok && (kind != tc.wantKind || name != tc.wantName) {
+				t.Errorf("got %s %q, want %s %q", kind, name, tc.wantKind, tc.wantName)
+			}
+		})
+	}
+}
+
+func TestPushRefs(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		ref        string
+		syncTags   bool
+		wantStatus int
+		wantPage   string
+	}{
+		{name: "default branch", ref: "refs/heads/master", wantStatus: http.StatusOK, wantPage: "api"},
+		{name: "other branch", ref: "refs/heads/develop", wantStatus: http.StatusOK},
+		{name: "tag ignored", ref: "refs/tags/v1.0.0", wantStatus: http.StatusOK},
+		{name: "tag synced", ref: "refs/tags/v1.0.0", syncTags: true, wantStatus: http.StatusOK, wantPage: "api-v1.0.0"},
+		{name: "malformed ref", ref: "master", wantStatus: http.StatusBadRequest},
+		{name: "invalid branch name", ref: "refs/heads/a..b", wantStatus: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			gh.setReadme("darlinggo/api@v1.0.0", "# api v1\n")
+			e := newTestEnv(t, gh.URL)
+			e.syncTags = tc.syncTags
+			w := serve(e, newDelivery("push", pushBody("api", tc.ref)))
+			if w.Code != tc.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tc.wantStatus, w.Body.String())
+			}
+			pages, err := filepath.Glob(filepath.Join(e.hugoSource, e.dir, "*.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantPage == "" {
+				if len(pages) > 0 {
+					t.Errorf("wrote %v, want no pages", pages)
+				}
+				return
+			}
+			if _, err := os.Stat(filepath.Join(e.hugoSource, e.dir, tc.wantPage+".md")); err != nil {
+				t.Errorf("page %s not written: %v", tc.wantPage, err)
+			}
+			if len(pages) != 1 {
+				t.Errorf("wrote %v, want only %s", pages, tc.wantPage)
+			}
+		})
+	}
+}
+
+func TestCraftedRefs(t *testing.T) {
+	for _, tc := range []struct {
+		ref        string
+		wantStatus int
+	}{
+		{ref: "refs/heads/master/extra", wantStatus: http.StatusOK},
+		{ref: "refs/heads/refs/heads/master", wantStatus: http.StatusOK},
+		{ref: "refs/heads/master2", wantStatus: http.StatusOK},
+		{ref: "refs/heads/Master", wantStatus: http.StatusOK},
+		{ref: "refs/heads/../heads/master", wantStatus: http.StatusBadRequest},
+		{ref: "refs/heads/./master", wantStatus: http.StatusBadRequest},
+		{ref: "refs/heads//master", wantStatus: http.StatusBadRequest},
+		{ref: "refs/heads/master/", wantStatus: http.StatusBadRequest},
+		{ref: "refs/heads/master ", wantStatus: http.StatusBadRequest},
+		{ref: "refs/heads/master\n", wantStatus: http.StatusBadRequest},
+		{ref: "refs/heads/master\x00", wantStatus: http.StatusBadRequest},
+		{ref: "refs/heads/master.lock", wantStatus: http.StatusBadRequest},
+		{ref: "refs/heads/master@{1}", wantStatus: http.StatusBadRequest},
+		{ref: "refs/heads/master^", wantStatus: http.StatusBadRequest},
+		{ref: "refs/heads/master~1", wantStatus: http.StatusBadRequest},
+		{ref: "refs/heads/mas*ter", wantStatus: http.StatusBadRequest},
+		{ref: "REFS/HEADS/master", wantStatus: http.StatusBadRequest},
+		{ref: "refs/remotes/origin/master", wantStatus: http.StatusBadRequest},
+		{ref: " refs/heads/master", wantStatus: http.StatusBadRequest},
+		{ref: "heads/master", wantStatus: http.StatusBadRequest},
+	} {
+		t.Run(tc.ref, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			if w := serve(e, newDelivery("push", pushBody("api", tc.ref))); w.Code != tc.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tc.wantStatus)
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) > 0 {
+				t.Errorf("crafted ref %q triggered a build", tc.ref)
+			}
+		})
+	}
+}
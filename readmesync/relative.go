@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	relativeTarget = regexp.MustCompile(`(!?)\[[^\[\]]*\]\(([^)\s]+)`)
+	referenceLink  = regexp.MustCompile(`^ {0,3}\[[^\]]+\]:\s*(\S+)`)
+	htmlHref       = regexp.MustCompile(`(?i)<a\b[^>]*\bhref\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+	htmlImageSrc   = regexp.MustCompile(`(?i)<img\b[^>]*\bsrc\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+
+	// wrappedTarget matches the link around an image, as in badges like
+	// [![build](badge.svg)](link).
+	wrappedTarget = regexp.MustCompile(`\)\]\(([^)\s]+)`)
+)
+
+var imageExts = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true}
+
+// resolveRelative returns target, a link in a README, relative to base
+// instead of the README, or false if it isn't relative. READMEs are at the
+// root of their repo, so links that start with / resolve the same way, and
+// none can climb out of it.
+func resolveRelative(target, base string) (string, bool) {
+	if target == "" || strings.HasPrefix(target, "#") || strings.HasPrefix(target, "//") {
+		return "", false
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+	u.Path = strings.TrimPrefix(path.Clean("/"+u.Path), "/")
+	return base + "/" + u.String(), true
+}
+
+// replaceGroup replaces the text matched by group n of each of re's
+// matches in line with what fn returns for it.
+func replaceGroup(line string, re *regexp.Regexp, n int, fn func(match []string, group string) string) string {
+	var out strings.Builder
+	last := 0
+	for _, idx := range re.FindAllStringSubmatchIndex(line, -1) {
+		match := make([]string, len(idx)/2)
+		for i := range match {
+			if idx[2*i] >= 0 {
+				match[i] = line[idx[2*i]:idx[2*i+1]]
+			}
+		}
+		out.WriteString(line[last:idx[2*n]])
+		out.WriteString(fn(match, match[n]))
+		last = idx[2*n+1]
+	}
+	out.WriteString(line[last:])
+	return out.String()
+}
+
+// rewriteRelativeLinks rewrites the relative links in readme, fullName's README at
+// ref, to point at the file on GitHub, and its relative images to point at
+// the raw file, so they still work once the README is on the site.
+func (e env) rewriteRelativeLinks(readme []byte, fullName, ref string) []byte {
+	blob := "https://github.com/" + fullName + "/blob/" + ref
+	raw := e.rawHost + "/" + fullName + "/" + ref
+	resolve := func(target string, image bool) string {
+		base := blob
+		if image {
+			base = raw
+		}
+		if abs, ok := resolveRelative(target, base); ok {
+			return abs
+		}
+		return target
+	}
+	// In HTML, the quotes around the attribute are kept as they were.
+	resolveAttr := func(attr string, image bool) string {
+		quote := ""
+		if strings.HasPrefix(attr, `"`) || strings.HasPrefix(attr, "'") {
+			quote = attr[:1]
+		}
+		return quote + resolve(strings.Trim(attr, `"'`), image) + quote
+	}
+	return eachLine(readme, func(line string) string {
+		line = replaceGroup(line, relativeTarget, 2, func(m []string, target string) string {
+			return resolve(target, m[1] == "!")
+		})
+		line = replaceGroup(line, wrappedTarget, 1, func(m []string, target string) string {
+			return resolve(target, false)
+		})
+		line = replaceGroup(line, referenceLink, 1, func(m []string, target string) string {
+			return resolve(target, imageExts[strings.ToLower(path.Ext(target))])
+		})
+		line = replaceGroup(line, htmlHref, 1, func(m []string, attr string) string {
+			return resolveAttr(attr, false)
+		})
+		return replaceGroup(line, htmlImageSrc, 1, func(m []string, attr string) string {
+			return resolveAttr(attr, true)
+		})
+	})
+}
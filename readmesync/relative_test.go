@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRewriteRelativeLinks(t *testing.T) {
+	const (
+		blob = "https://github.com/darlinggo/api/blob/main/"
+		raw  = "https://raw.githubusercontent.com/darlinggo/api/main/"
+	)
+	for _, tc := range []struct {
+		name   string
+		readme string
+		want   string
+	}{
+		{name: "relative link", readme: "See [the guide](./docs/guide.md).", want: "See [the guide](" + blob + "docs/guide.md)."},
+		{name: "relative link with a fragment", readme: "[Install](docs/guide.md#install)", want: "[Install](" + blob + "docs/guide.md#install)"},
+		{name: "link from the repo root", readme: "[License](/LICENSE)", want: "[License](" + blob + "LICENSE)"},
+		{name: "link climbing out of the repo", readme: "[up](../../etc/passwd)", want: "[up](" + blob + "etc/passwd)"},
+		{name: "link with a title", readme: `[guide](docs/guide.md "The guide")`, want: `[guide](` + blob + `docs/guide.md "The guide")`},
+		{name: "relative image", readme: "![logo](images/logo.png)", want: "![logo](" + raw + "images/logo.png)"},
+		{name: "badge", readme: "[![build](badge.svg)](ci.md)", want: "[![build](" + raw + "badge.svg)](" + blob + "ci.md)"},
+		{name: "reference link", readme: "[guide]: docs/guide.md", want: "[guide]: " + blob + "docs/guide.md"},
+		{name: "reference image", readme: "[logo]: images/logo.PNG", want: "[logo]: " + raw + "images/logo.PNG"},
+		{name: "HTML link", readme: `<a href="docs/guide.md">guide</a>`, want: `<a href="` + blob + `docs/guide.md">guide</a>`},
+		{name: "HTML image", readme: `<img alt="logo" src='images/logo.png'>`, want: `<img alt="logo" src='` + raw + `images/logo.png'>`},
+		{name: "absolute link", readme: "[Go](https://go.dev/doc)", want: "[Go](https://go.dev/doc)"},
+		{name: "absolute image", readme: "![badge](https://img.shields.io/badge.svg)", want: "![badge](https://img.shields.io/badge.svg)"},
+		{name: "protocol-relative link", readme: "[cdn](//cdn.example/x.js)", want: "[cdn](//cdn.example/x.js)"},
+		{name: "mailto link", readme: "[mail](mailto:hi@darlinggo.example)", want: "[mail](mailto:hi@darlinggo.example)"},
+		{name: "anchor link", readme: "[Usage](#usage)", want: "[Usage](#usage)"},
+		{name: "HTML anchor link", readme: `<a href="#usage">Usage</a>`, want: `<a href="#usage">Usage</a>`},
+		{name: "code fence", readme: "```md\n[guide](docs/guide.md)\n```", want: "```md\n[guide](docs/guide.md)\n```"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t, "http://github.invalid")
+			got := string(e.rewriteRelativeLinks([]byte(tc.readme+"\n"), "darlinggo/api", "main"))
+			if want := tc.want + "\n"; got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestAbsoluteLinksPages(t *testing.T) {
+	const readme = "# api\n\nSee [the guide](docs/guide.md) and [usage](#usage).\n\n![logo](images/logo.png)\n"
+	for _, tc := range []struct {
+		name     string
+		absolute bool
+		fromAPI  bool
+		want     []string
+		wantNone []string
+	}{
+		{
+			name:     "absolute links",
+			absolute: true,
+			want:     []string{"(https://github.com/darlinggo/api/blob/master/docs/guide.md)", "(https://raw.githubusercontent.com/darlinggo/api/master/images/logo.png)", "(#usage)"},
+		},
+		{
+			name:     "absolute links to the default branch",
+			absolute: true,
+			fromAPI:  true,
+			want:     []string{"(https://github.com/darlinggo/api/blob/main/docs/guide.md)", "(https://raw.githubusercontent.com/darlinggo/api/main/images/logo.png)", "(#usage)"},
+		},
+		{
+			name:     "off",
+			want:     []string{"(docs/guide.md)", "(images/logo.png)", "(#usage)"},
+			wantNone: []string{"https://github.com/", "https://raw.githubusercontent.com/"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", readme)
+			gh.setRepo("darlinggo/api", `{"default_branch": "main", "size": 1}`)
+			e := newTestEnv(t, gh.URL)
+			e.absoluteLinks, e.branchFromAPI = tc.absolute, tc.fromAPI
+			push := "refs/heads/master"
+			if tc.fromAPI {
+				push = "refs/heads/main"
+			}
+			if w := serve(e, newDelivery("push", pushBody("api", push))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			page := readPage(t, e, "api")
+			for _, want := range tc.want {
+				if !strings.Contains(page, want) {
+					t.Errorf("page doesn't contain %s:\n%s", want, page)
+				}
+			}
+			for _, unwanted := range tc.wantNone {
+				if strings.Contains(page, unwanted) {
+					t.Errorf("page contains %s:\n%s", unwanted, page)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// transform applies each of the enabled README transforms, in order, to
+// readme, repo's README at ref, or at its default branch if ref is empty.
+func (e env) transform(ctx context.Context, repo, ref string, readme []byte) []byte {
+	readme = stripBOM(readme)
+	if e.charsetMeta == charsetMetaStrip {
+		readme = stripCharsetMeta(readme)
+	}
+	if e.stripLeadingH1 {
+		readme = stripLeadingH1(readme)
+	}
+	if e.tocMarker != "" {
+		readme = addTOC(readme, e.tocMarker)
+	}
+	if e.headingAnchors {
+		readme = addHeadingAnchors(readme)
+	}
+	if e.emoji != "" {
+		readme = transformEmoji(readme, e.emoji)
+	}
+	if e.absoluteLinks {
+		branch := ref
+		if branch == "" {
+			var err error
+			branch, err = e.defaultBranch(ctx, repo)
+			if err != nil {
+				log.Println(repo+": can't make links absolute:", err)
+			}
+		}
+		if branch != "" {
+			readme = e.rewriteRelativeLinks(readme, e.fullName(repo), branch)
+		}
+	}
+	if e.validateLinks {
+		readme = e.checkLinks(readme)
+	}
+	return readme
+}
+
+// newPageData returns the template data for page, rendering readme. The
+// page is assumed to be for the repo of the same name; callers rendering
+// versioned pages should set Repo and Version themselves before calling
+// enrich.
+func (e env) newPageData(page string, readme []byte) pageData {
+	return pageData{
+		Name:   page,
+		Repo:   page,
+		Readme: string(readme),
+		Date:   time.Now().Format(e.dateFormat),
+		Title:  page,
+		URL:    "/" + page,
+	}
+}
+
+// completePageData fills in everything about data that isn't known until
+// its repo and version are: the repo's metadata, the hash of raw (the
+// README as fetched) if EMBED_CONTENT_HASH is set, and the body header and
+// footer.
+func (e env) completePageData(ctx context.Context, data *pageData, raw []byte) error {
+	e.enrich(ctx, data)
+	if e.embedContentHash {
+		data.ContentHash = contentHash(raw)
+	}
+	return e.wrapBody(ctx, data)
+}
+
+// writePage renders data to the page for repo, returning the number of
+// bytes written. With SPLIT_README set, READMEs with sections are split
+// into several pages instead.
+func (e env) writePage(repo string, data pageData) (int64, error) {
+	dir := filepath.Join(e.hugoSource, e.dir, repo)
+	if e.splitLevel > 0 {
+		intro, parts := splitReadme(data.Readme, e.splitLevel)
+		if len(parts) > 0 {
+			return e.writeSplitPage(repo, dir, data, intro, parts)
+		}
+	}
+	if isSplitPage(dir) {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return e.writeFile(dir+".md", repo, e.pageTmpl, data)
+}
+
+// writeFile renders t with data to path. The file is closed before it
+// returns, and a failure to close it is an error, since it can mean the
+// page wasn't fully written.
+func (e env) writeFile(path, repo string, t *template.Template, data interface{}) (int64, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return 0, err
+	}
+	// The page is rendered beside the one it replaces, so one that fails
+	// to render part way leaves the old one in place.
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return 0, err
+	}
+	pw := newProgressWriter(f, repo, e.progressBytes)
+	var out io.Writer = pw
+	var intended bytes.Buffer
+	if e.verifyWrites {
+		out = io.MultiWriter(pw, &intended)
+	}
+	err = t.Execute(out, data)
+	if err == nil && e.verifyWrites {
+		err = verifyWrite(f, intended.Bytes())
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return pw.written, err
+}
+
+// renderFailed reports whether err came from executing a template, rather
+// than from writing out the result.
+func renderFailed(err error) bool {
+	var execErr template.ExecError
+	return errors.As(err, &execErr)
+}
+
+// loadPageTemplate parses the page template at path, and checks it can be
+// rendered with a page's data, so mistakes in it surface at startup rather
+// than with the first webhook.
+func loadPageTemplate(path string) (*template.Template, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := template.New("project").Funcs(tmplFuncs).Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+	sample := pageData{
+		Name:      "example",
+		Repo:      "example",
+		Readme:    "# example\n",
+		Date:      time.Now().Format(time.RFC3339),
+		Title:     "example",
+		URL:       "/example",
+		Topics:    []string{"example"},
+		Languages: map[string]int{"Go": 1},
+		Params:    map[string]interface{}{"example": "example"},
+	}
+	err = t.Execute(ioutil.Discard, sample)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// bodyData is what the BODY_HEADER_TEMPLATE and BODY_FOOTER_TEMPLATE are
+// rendered with: the page's data, plus enough to link back to the README,
+// e.g. https://github.com/{{ .FullName }}/edit/{{ .Branch }}/README.md.
+type bodyData struct {
+	pageData
+	FullName string
+	Branch   string
+}
+
+// loadBodyTemplate parses the template at path, returning nil if path is
+// empty.
+func loadBodyTemplate(name, path string) (*template.Template, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(name).Parse(string(b))
+}
+
+// wrapBody renders the header and footer that go around data's README, if
+// they're configured.
+func (e env) wrapBody(ctx context.Context, data *pageData) error {
+	if e.bodyHeader == nil && e.bodyFooter == nil {
+		return nil
+	}
+	wrapper := bodyData{pageData: *data, FullName: e.fullName(data.Repo), Branch: data.Version}
+	if wrapper.Branch == "" {
+		branch, err := e.defaultBranch(ctx, data.Repo)
+		if err != nil {
+			log.Println(err)
+		}
+		wrapper.Branch = branch
+	}
+	var err error
+	data.Header, err = renderBody(e.bodyHeader, wrapper)
+	if err != nil {
+		return err
+	}
+	data.Footer, err = renderBody(e.bodyFooter, wrapper)
+	return err
+}
+
+func renderBody(t *template.Template, data bodyData) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	var b strings.Builder
+	err := t.Execute(&b, data)
+	return strings.TrimSpace(b.String()), err
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestValidateDateFormat(t *testing.T) {
+	for _, tc := range []struct {
+		layout  string
+		wantErr bool
+	}{
+		{layout: time.RFC3339},
+		{layout: "2006-01-02"},
+		{layout: "2006-01-02 15:04:05 -0700"},
+		{layout: "Jan 2, 2006"},
+		{layout: "yesterday", wantErr: true},
+		{layout: "", wantErr: true},
+		{layout: "YYYY-MM-DD", wantErr: true},
+	} {
+		t.Run(tc.layout, func(t *testing.T) {
+			err := validateDateFormat(tc.layout)
+			if tc.wantErr && err == nil {
+				t.Error("got no error")
+			}
+			if !tc.wantErr && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDateFormat(t *testing.T) {
+	for _, tc := range []struct {
+		layout string
+		want   string
+	}{
+		{layout: time.RFC3339, want: `date = "\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(Z|[+-]\d\d:\d\d)"`},
+		{layout: "2006-01-02", want: `date = "\d{4}-\d\d-\d\d"\n`},
+		{layout: "2006-01-02 15:04", want: `date = "\d{4}-\d\d-\d\d \d\d:\d\d"\n`},
+	} {
+		t.Run(tc.layout, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.dateFormat = tc.layout
+			before := time.Now()
+			if w := serve(e, newDelivery("sync-all", syncAllBody("api"))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			page := readPage(t, e, "api")
+			m := regexp.MustCompile(tc.want).FindString(page)
+			if m == "" {
+				t.Fatalf("page has no date matching %s:\n%s", tc.want, page)
+			}
+			date, err := time.ParseInLocation(tc.layout, regexp.MustCompile(`"(.*)"`).FindStringSubmatch(m)[1], time.Local)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if date.Format(tc.layout) != before.Format(tc.layout) && date.Format(tc.layout) != time.Now().Format(tc.layout) {
+				t.Errorf("got date %s, want the time of the sync", date.Format(tc.layout))
+			}
+		})
+	}
+}
+
+func TestBodyWrapper(t *testing.T) {
+	const (
+		banner = "> This page is generated from the README of {{ .FullName }}."
+		edit   = "[Edit on GitHub](https://github.com/{{ .FullName }}/edit/{{ .Branch }}/README.md)"
+	)
+	for _, tc := range []struct {
+		name          string
+		header        string
+		footer        string
+		branchFromAPI bool
+		ref           string
+		want          []string
+	}{
+		{
+			name: "none",
+			ref:  "refs/heads/master",
+			want: []string{"# api\n"},
+		},
+		{
+			name:   "header and footer",
+			header: banner,
+			footer: edit,
+			ref:    "refs/heads/master",
+			want: []string{
+				"> This page is generated from the README of darlinggo/api.",
+				"# api\n",
+				"[Edit on GitHub](https://github.com/darlinggo/api/edit/master/README.md)",
+			},
+		},
+		{
+			name:   "header only",
+			header: banner,
+			ref:    "refs/heads/master",
+			want:   []string{"> This page is generated from the README of darlinggo/api.", "# api\n"},
+		},
+		{
+			name:          "default branch from the API",
+			footer:        edit,
+			branchFromAPI: true,
+			ref:           "refs/heads/main",
+			want:          []string{"# api\n", "[Edit on GitHub](https://github.com/darlinggo/api/edit/main/README.md)"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			gh.setRepo("darlinggo/api", `{"default_branch": "main", "size": 1}`)
+			e := newTestEnv(t, gh.URL)
+			e.branchFromAPI = tc.branchFromAPI
+			var err error
+			e.bodyHeader, err = loadBodyTemplate("header", writeTemplate(t, tc.header))
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.bodyFooter, err = loadBodyTemplate("footer", writeTemplate(t, tc.footer))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w := serve(e, newDelivery("push", pushBody("api", tc.ref))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			page := readPage(t, e, "api")
+			body := page[strings.LastIndex(page, "+++")+len("+++"):]
+			if got := strings.Fields(body); !reflect.DeepEqual(got, strings.Fields(strings.Join(tc.want, "\n"))) {
+				t.Errorf("got body %q, want %q", body, tc.want)
+			}
+		})
+	}
+}
+
+// writeTemplate writes text to a file for loadBodyTemplate, returning ""
+// if there's no text.
+func writeTemplate(t *testing.T, text string) string {
+	t.Helper()
+	if text == "" {
+		return ""
+	}
+	path := filepath.Join(t.TempDir(), "body.tmpl")
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTemplateFallback(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		fallback string
+		wantExit bool
+	}{
+		{name: "fallback", fallback: "true"},
+		{name: "no fallback", wantExit: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if os.Getenv("TEMPLATE_FALLBACK_TEST") != "" {
+				_, err := loadPageTemplate(writeTemplate(t, "{{ .Readme"))
+				templateFailed("TEMPLATE_PATH must be the path to a template for each README's page:", err)
+				return
+			}
+			cmd := exec.Command(os.Args[0], "-test.run=^TestTemplateFallback$/^"+strings.ReplaceAll(tc.name, " ", "_")+"$")
+			cmd.Env = append(os.Environ(), "TEMPLATE_FALLBACK_TEST=1", "TEMPLATE_FALLBACK="+tc.fallback)
+			out, err := cmd.CombinedOutput()
+			if exitErr, ok := err.(*exec.ExitError); err != nil && (!ok || exitErr.ExitCode() != 1) {
+				t.Fatalf("unexpected error %v:\n%s", err, out)
+			}
+			if exited := err != nil; exited != tc.wantExit {
+				t.Errorf("exited: got %v, want %v:\n%s", exited, tc.wantExit, out)
+			}
+			if !strings.Contains(string(out), "TEMPLATE_PATH must be the path") {
+				t.Errorf("template error not logged:\n%s", out)
+			}
+			if got := strings.Contains(string(out), "using the built-in template instead"); got == tc.wantExit {
+				t.Errorf("logged the fallback: got %v, want %v:\n%s", got, !tc.wantExit, out)
+			}
+		})
+	}
+}
+
+func TestBrokenTemplates(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		load func(path string) error
+		text string
+	}{
+		{
+			name: "page template that doesn't parse",
+			load: func(path string) error { _, err := loadPageTemplate(path); return err },
+			text: "{{ .Readme",
+		},
+		{
+			name: "page template that doesn't render",
+			load: func(path string) error { _, err := loadPageTemplate(path); return err },
+			text: "{{ .NoSuchField }}",
+		},
+		{
+			name: "missing page template",
+			load: func(path string) error { _, err := loadPageTemplate(path + ".missing"); return err },
+			text: "{{ .Readme }}",
+		},
+		{
+			name: "body template that doesn't parse",
+			load: func(path string) error { _, err := loadBodyTemplate("header", path); return err },
+			text: "{{ if }}",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.load(writeTemplate(t, tc.text)); err == nil {
+				t.Error("broken template loaded")
+			}
+		})
+	}
+}
+
+func TestRenderFailuresMidBatch(t *testing.T) {
+	// Fails to render hash's page, and only hash's.
+	failing := template.Must(template.New("page").Parse(`{{ if eq .Repo "hash" }}{{ index .Repo 99 }}{{ end }}{{ .Readme }}`))
+	for _, tc := range []struct {
+		name       string
+		skip       bool
+		event      string
+		body       string
+		want       int
+		wantPages  []string
+		wantFailed []string
+		wantBuilds int
+	}{
+		{
+			name:       "sync-all, skipping failures",
+			skip:       true,
+			event:      "sync-all",
+			body:       syncAllBody("api", "hash", "site"),
+			want:       http.StatusOK,
+			wantPages:  []string{"api", "site"},
+			wantFailed: []string{"hash"},
+			wantBuilds: 1,
+		},
+		{
+			name:  "sync-all, not skipping failures",
+			event: "sync-all",
+			body:  syncAllBody("api", "hash", "site"),
+			want:  http.StatusInternalServerError,
+		},
+		{
+			name:  "push, skipping failures",
+			skip:  true,
+			event: "push",
+			body:  pushBody("hash", "refs/heads/master"),
+			want:  http.StatusInternalServerError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			for _, repo := range []string{"api", "hash", "site"} {
+				gh.setReadme("darlinggo/"+repo, "# "+repo+"\n")
+			}
+			e := newTestEnv(t, gh.URL)
+			e.pageTmpl, e.skipBadRenders = failing, tc.skip
+			writeTestPage(t, e, "hash")
+			before := readPage(t, e, "hash")
+			w := serve(e, newDelivery(tc.event, tc.body))
+			if w.Code != tc.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			if after := readPage(t, e, "hash"); after != before {
+				t.Errorf("hash's page was changed by a failed render:\n%s", after)
+			}
+			for _, repo := range tc.wantPages {
+				if page := readPage(t, e, repo); !strings.Contains(page, "# "+repo+"\n") {
+					t.Errorf("%s page is missing its README:\n%s", repo, page)
+				}
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != tc.wantBuilds {
+				t.Errorf("hugo ran %d times, want %d", len(runs), tc.wantBuilds)
+			}
+			left, _ := filepath.Glob(filepath.Join(e.hugoSource, e.dir, ".*"))
+			if len(left) > 0 {
+				t.Errorf("left behind %v", left)
+			}
+			if tc.wantFailed == nil {
+				return
+			}
+			var summary syncSummary
+			if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(summary.Synced, tc.wantPages) {
+				t.Errorf("got synced %q, want %q", summary.Synced, tc.wantPages)
+			}
+			for _, repo := range tc.wantFailed {
+				if summary.Errors[repo] == "" {
+					t.Errorf("no error reported for %s: %+v", repo, summary)
+				}
+			}
+		})
+	}
+}
+
+func TestCustomPageTemplate(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		text     string
+		split    bool
+		want     []string
+		wantNone []string
+	}{
+		{
+			name:     "custom front matter",
+			text:     "+++\ntitle = {{ toml .Title }}\nweight = 5\nsection = \"projects\"\n+++\n\n{{ .Readme }}",
+			want:     []string{"weight = 5\n", "section = \"projects\"\n", "title = \"api\"\n", "# api\n"},
+			wantNone: []string{"date ="},
+		},
+		{
+			name: "template functions",
+			text: "+++\ntags = {{ toml .Topics }}\n+++\n\n{{ .Readme }}",
+			want: []string{"tags = []\n", "# api\n"},
+		},
+		{
+			name:  "split page intro",
+			text:  "+++\ntitle = {{ toml .Title }}\nkind = \"custom\"\n+++\n\n{{ .Readme }}",
+			split: true,
+			want:  []string{"kind = \"custom\"\n", "Intro."},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := loadPageTemplate(writeTemplate(t, tc.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n\nIntro.\n\n## Install\n\nGo get it.\n")
+			e := newTestEnv(t, gh.URL)
+			e.pageTmpl = tmpl
+			if tc.split {
+				e.splitLevel = 2
+			}
+			if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			path := filepath.Join(e.hugoSource, e.dir, "api.md")
+			if tc.split {
+				path = filepath.Join(e.hugoSource, e.dir, "api", "_index.md")
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page := string(b)
+			for _, want := range tc.want {
+				if !strings.Contains(page, want) {
+					t.Errorf("page doesn't contain %q:\n%s", want, page)
+				}
+			}
+			for _, unwanted := range tc.wantNone {
+				if strings.Contains(page, unwanted) {
+					t.Errorf("page contains %q:\n%s", unwanted, page)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type buildReport struct {
+	Time       time.Time `json:"time"`
+	Repos      []string  `json:"repos"`
+	Bytes      int64     `json:"bytes_written"`
+	DurationMS int64     `json:"duration_ms"`
+	ExitCode   int       `json:"exit_code"`
+	Warnings   []string  `json:"warnings"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type reportKeeper struct {
+	sync.RWMutex
+	last *buildReport
+
+	// lastSuccess is when the latest build that succeeded ran.
+	lastSuccess time.Time
+
+	// failures counts the builds that have failed in a row.
+	failures int
+}
+
+// buildAndReport builds repos, then records and announces the outcome.
+func (e env) buildAndReport(event string, repos []string, bytes int64) (buildReport, error) {
+	done := track(&e.activity.builds)
+	report := buildReport{Time: time.Now(), Repos: repos, Bytes: bytes}
+	err := e.build(repos, &report)
+	if err == nil && e.reposJSON != "" {
+		err = e.writeReposJSON(e.reposJSON)
+	}
+	if err == nil && e.precompress {
+		err = e.precompressSites(repos)
+	}
+	done()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	e.saveReport(report)
+	e.notifyAsync(notification{Event: event, Success: err == nil, Report: report})
+	e.countFailure(err)
+	return report, err
+}
+
+// countFailure tracks consecutive build failures and, if
+// EXIT_AFTER_FAILURES is set, exits once there have been that many, so the
+// process can be restarted instead of serving a site it can't build.
+func (e env) countFailure(err error) {
+	e.reports.Lock()
+	if err == nil {
+		e.reports.failures = 0
+	} else {
+		e.reports.failures++
+	}
+	failures := e.reports.failures
+	e.reports.Unlock()
+	if e.exitAfterFailures > 0 && failures >= e.exitAfterFailures {
+		log.Println(strconv.Itoa(failures) + " builds failed in a row, exiting")
+		os.Exit(1)
+	}
+}
+
+// saveReport keeps report as the latest for /build-status and, if
+// BUILD_REPORT_PATH is set, writes it there.
+func (e env) saveReport(report buildReport) {
+	e.reports.Lock()
+	e.reports.last = &report
+	if report.Error == "" {
+		e.reports.lastSuccess = report.Time
+	}
+	e.reports.Unlock()
+	if e.reportPath == "" {
+		return
+	}
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(e.reportPath), ".build-report-")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), e.reportPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Println(err)
+	}
+}
+
+func (e env) buildStatus(w http.ResponseWriter, r *http.Request) {
+	e.reports.RLock()
+	last := e.reports.last
+	e.reports.RUnlock()
+	if last == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	b, err := json.Marshal(last)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
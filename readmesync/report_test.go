@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBuildReport(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		hugo         string
+		wantExitCode int
+		wantWarnings []string
+		wantError    bool
+	}{
+		{
+			name:         "successful build",
+			hugo:         "echo 'WARN deprecated config key'\necho 'built 3 pages'\n",
+			wantWarnings: []string{"WARN deprecated config key"},
+		},
+		{
+			name:         "failed build",
+			hugo:         "echo 'WARN missing layout'\necho 'ERROR render failed'\nexit 3\n",
+			wantExitCode: 3,
+			wantWarnings: []string{"WARN missing layout"},
+			wantError:    true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			gh.setReadme("darlinggo/hash", "# hash\n")
+			e := newTestEnv(t, gh.URL)
+			e.hugoCmd = fakeHugo(t, tc.hugo)
+			e.reportPath = filepath.Join(t.TempDir(), "report.json")
+			serve(e, newDelivery("sync-all", syncAllBody("hash", "api")))
+
+			b, err := ioutil.ReadFile(e.reportPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var written buildReport
+			if err := json.Unmarshal(b, &written); err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			e.buildStatus(w, httptest.NewRequest("GET", "/build-status", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("/build-status: got status %d", w.Code)
+			}
+			var served buildReport
+			if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(written, served) {
+				t.Errorf("wrote %+v, but /build-status served %+v", written, served)
+			}
+
+			if want := []string{"api", "hash"}; !reflect.DeepEqual(written.Repos, want) {
+				t.Errorf("got repos %q, want %q", written.Repos, want)
+			}
+			if written.Bytes <= int64(len("# api\n# hash\n")) {
+				t.Errorf("got %d bytes written, want the size of both pages", written.Bytes)
+			}
+			if written.DurationMS < 0 || written.Time.IsZero() {
+				t.Errorf("got time %s and duration %dms", written.Time, written.DurationMS)
+			}
+			if written.ExitCode != tc.wantExitCode {
+				t.Errorf("got exit code %d, want %d", written.ExitCode, tc.wantExitCode)
+			}
+			if !reflect.DeepEqual(written.Warnings, tc.wantWarnings) {
+				t.Errorf("got warnings %q, want %q", written.Warnings, tc.wantWarnings)
+			}
+			if got := written.Error != ""; got != tc.wantError {
+				t.Errorf("got error %q, want one: %v", written.Error, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestBuildStatusBeforeBuilds(t *testing.T) {
+	e := newTestEnv(t, "http://github.invalid")
+	w := httptest.NewRecorder()
+	e.buildStatus(w, httptest.NewRequest("GET", "/build-status", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestExitAfterFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		threshold string
+		builds    string
+		wantExit  bool
+	}{
+		{name: "off", threshold: "0", builds: "fffff"},
+		{name: "below the threshold", threshold: "3", builds: "ff"},
+		{name: "at the threshold", threshold: "3", builds: "fff", wantExit: true},
+		{name: "success resets the count", threshold: "3", builds: "ffsff"},
+		{name: "threshold of one", threshold: "1", builds: "sf", wantExit: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if os.Getenv("EXIT_TEST_BUILDS") != "" {
+				driveBuilds(t)
+				return
+			}
+			cmd := exec.Command(os.Args[0], "-test.run=^TestExitAfterFailures$/^"+strings.ReplaceAll(tc.name, " ", "_")+"$")
+			cmd.Env = append(os.Environ(), "EXIT_TEST_BUILDS="+tc.builds, "EXIT_TEST_THRESHOLD="+tc.threshold)
+			out, err := cmd.CombinedOutput()
+			exited := err != nil
+			if exitErr, ok := err.(*exec.ExitError); err != nil && (!ok || exitErr.ExitCode() != 1) {
+				t.Fatalf("unexpected error %v:\n%s", err, out)
+			}
+			if exited != tc.wantExit {
+				t.Errorf("exited: got %v, want %v:\n%s", exited, tc.wantExit, out)
+			}
+			if want := "builds failed in a row, exiting"; strings.Contains(string(out), want) != tc.wantExit {
+				t.Errorf("output containing %q: got %v, want %v:\n%s", want, !tc.wantExit, tc.wantExit, out)
+			}
+		})
+	}
+}
+
+// driveBuilds runs the builds in EXIT_TEST_BUILDS, where f is a failed
+// build and s a successful one, for TestExitAfterFailures' subprocesses.
+func driveBuilds(t *testing.T) {
+	e := newTestEnv(t, "http://github.invalid")
+	e.exitAfterFailures, _ = strconv.Atoi(os.Getenv("EXIT_TEST_THRESHOLD"))
+	fail, succeed := fakeHugo(t, "exit 1\n"), fakeHugo(t, "")
+	for _, build := range os.Getenv("EXIT_TEST_BUILDS") {
+		e.hugoCmd = succeed
+		if build == 'f' {
+			e.hugoCmd = fail
+		}
+		e.buildAndReport("push", []string{"api"}, 0)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type repoPage struct {
+	Name        string                 `json:"name"`
+	FrontMatter map[string]interface{} `json:"front_matter"`
+	Modified    time.Time              `json:"modified"`
+}
+
+// parseFrontMatter reads the simple key = value TOML front matter that
+// readmesync writes. Strings and integers are decoded; anything else is
+// returned as written.
+func parseFrontMatter(path string) (map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fields := map[string]interface{}{}
+	scanner := bufio.NewScanner(f)
+	inFrontMatter := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "+++" {
+			if inFrontMatter {
+				break
+			}
+			inFrontMatter = true
+			continue
+		}
+		if !inFrontMatter {
+			if line != "" {
+				break
+			}
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		key := strings.Trim(strings.TrimSpace(line[:i]), `"`)
+		raw := strings.TrimSpace(line[i+1:])
+		if s, err := strconv.Unquote(raw); err == nil {
+			fields[key] = s
+		} else if n, err := strconv.Atoi(raw); err == nil {
+			fields[key] = n
+		} else {
+			fields[key] = raw
+		}
+	}
+	return fields, scanner.Err()
+}
+
+// repoIndex caches the pages in the output directory for ttl, so listing
+// them doesn't rescan the directory on every request.
+type repoIndex struct {
+	sync.Mutex
+	ttl     time.Duration
+	workers int
+	pages   []repoPage
+	expires time.Time
+}
+
+func (idx *repoIndex) invalidate() {
+	idx.Lock()
+	idx.pages = nil
+	idx.Unlock()
+}
+
+// scan parses the front matter of every page under dir, using up to
+// idx.workers goroutines.
+func (idx *repoIndex) scan(dir string) ([]repoPage, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".md" {
+			return nil
+		}
+		// Only the index of a split README is listed, not its parts.
+		if isSplitPage(filepath.Dir(path)) && info.Name() != "_index.md" {
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	pages := make([]repoPage, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < idx.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				rel, _ := filepath.Rel(dir, paths[j])
+				name := strings.TrimSuffix(strings.TrimSuffix(rel, ".md"), string(filepath.Separator)+"_index")
+				page := repoPage{Name: filepath.ToSlash(name)}
+				if info, err := os.Stat(paths[j]); err == nil {
+					page.Modified = info.ModTime()
+				}
+				fields, err := parseFrontMatter(paths[j])
+				if err != nil {
+					log.Println(err)
+				}
+				page.FrontMatter = fields
+				pages[j] = page
+			}
+		}()
+	}
+	for j := range paths {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Name < pages[j].Name })
+	return pages, nil
+}
+
+func (idx *repoIndex) list(dir string) ([]repoPage, error) {
+	idx.Lock()
+	defer idx.Unlock()
+	if idx.pages != nil && time.Now().Before(idx.expires) {
+		return idx.pages, nil
+	}
+	pages, err := idx.scan(dir)
+	if err != nil {
+		return nil, err
+	}
+	idx.pages, idx.expires = pages, time.Now().Add(idx.ttl)
+	return pages, nil
+}
+
+func (e env) listRepos(w http.ResponseWriter, r *http.Request) {
+	pages, err := e.index.list(filepath.Join(e.hugoSource, e.dir))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	b, err := json.Marshal(pages)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+type repoSummary struct {
+	Name        string    `json:"name"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Description string    `json:"description,omitempty"`
+	LastMod     time.Time `json:"lastmod"`
+}
+
+// writeReposJSON writes a summary of every page in the output directory
+// to path, for index pages that list the repos client-side.
+func (e env) writeReposJSON(path string) error {
+	pages, err := e.index.scan(filepath.Join(e.hugoSource, e.dir))
+	if err != nil {
+		return err
+	}
+	repos := make([]repoSummary, 0, len(pages))
+	for _, page := range pages {
+		summary := repoSummary{Name: page.Name, LastMod: page.Modified}
+		summary.Title, _ = page.FrontMatter["title"].(string)
+		summary.URL, _ = page.FrontMatter["url"].(string)
+		summary.Description, _ = page.FrontMatter["description"].(string)
+		repos = append(repos, summary)
+	}
+	b, err := json.MarshalIndent(repos, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".repos-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
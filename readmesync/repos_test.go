@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func listedRepos(t *testing.T, e env) []string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	e.listRepos(w, httptest.NewRequest("GET", "/repos", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	var pages []repoPage
+	if err := json.Unmarshal(w.Body.Bytes(), &pages); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, page := range pages {
+		names = append(names, page.Name)
+	}
+	return names
+}
+
+func TestListReposCache(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		ttl    time.Duration
+		change func(t *testing.T, e env, gh *fakeGitHub)
+		want   []string
+	}{
+		{
+			name:   "cached",
+			ttl:    time.Minute,
+			change: func(t *testing.T, e env, gh *fakeGitHub) { writeTestPage(t, e, "hash") },
+			want:   []string{"api"},
+		},
+		{
+			name:   "expired",
+			ttl:    time.Nanosecond,
+			change: func(t *testing.T, e env, gh *fakeGitHub) { writeTestPage(t, e, "hash") },
+			want:   []string{"api", "hash"},
+		},
+		{
+			name: "invalidated by a sync",
+			ttl:  time.Minute,
+			change: func(t *testing.T, e env, gh *fakeGitHub) {
+				gh.setReadme("darlinggo/hash", "# hash\n")
+				if w := serve(e, newDelivery("push", pushBody("hash", "refs/heads/master"))); w.Code != http.StatusOK {
+					t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+				}
+			},
+			want: []string{"api", "hash"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			e := newTestEnv(t, gh.URL)
+			e.index = &repoIndex{ttl: tc.ttl, workers: 2}
+			writeTestPage(t, e, "api")
+			if got := listedRepos(t, e); !reflect.DeepEqual(got, []string{"api"}) {
+				t.Fatalf("got %q before the change, want [api]", got)
+			}
+			tc.change(t, e, gh)
+			if got := listedRepos(t, e); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestListReposFrontMatter(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	e := newTestEnv(t, gh.URL)
+	if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	w := httptest.NewRecorder()
+	e.listRepos(w, httptest.NewRequest("GET", "/repos", nil))
+	var pages []repoPage
+	if err := json.Unmarshal(w.Body.Bytes(), &pages); err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	if got := pages[0].FrontMatter["repo"]; got != "api" {
+		t.Errorf("got repo %v, want api", got)
+	}
+	if pages[0].Modified.IsZero() {
+		t.Error("modified time isn't set")
+	}
+}
+
+func readReposJSON(t *testing.T, path string) []repoSummary {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var repos []repoSummary
+	if err := json.Unmarshal(b, &repos); err != nil {
+		t.Fatalf("%v: %s", err, b)
+	}
+	return repos
+}
+
+func TestReposJSON(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	gh.setReadme("darlinggo/hash", "# hash\n")
+	e := newTestEnv(t, gh.URL)
+	e.reposJSON = filepath.Join(e.publishDir, "repos.json")
+	e.staticMetadata = map[string]map[string]interface{}{"api": {"description": "The API"}}
+	if w := serve(e, newDelivery("sync-all", syncAllBody("api", "hash"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	repos := readReposJSON(t, e.reposJSON)
+	if len(repos) != 2 {
+		t.Fatalf("got %d repos, want 2: %+v", len(repos), repos)
+	}
+	for i, want := range []repoSummary{
+		{Name: "api", Title: "api", URL: "/api", Description: "The API"},
+		{Name: "hash", Title: "hash", URL: "/hash"},
+	} {
+		got := repos[i]
+		if got.LastMod.IsZero() {
+			t.Errorf("%s has no lastmod", got.Name)
+		}
+		got.LastMod = time.Time{}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+
+	e.staticMetadata["api"]["description"] = "The new API"
+	gh.setReadme("darlinggo/site", "# site\n")
+	if w := serve(e, newDelivery("sync-all", syncAllBody("api", "site"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	repos = readReposJSON(t, e.reposJSON)
+	var names []string
+	for _, repo := range repos {
+		names = append(names, repo.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"api", "hash", "site"}) {
+		t.Errorf("got repos %q after the second sync", names)
+	}
+	if repos[0].Description != "The new API" {
+		t.Errorf("got description %q after the change", repos[0].Description)
+	}
+	left, err := filepath.Glob(filepath.Join(e.publishDir, ".repos-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) > 0 {
+		t.Errorf("left behind %v", left)
+	}
+}
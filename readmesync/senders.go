@@ -0,0 +1,24 @@
+package main
+
+import "strings"
+
+// senderAllowed reports whether deliveries triggered by login should be
+// acted on. Logins in DENIED_SENDERS never are; if ALLOWED_SENDERS is set,
+// only the logins in it are. Logins are compared ignoring case, as GitHub
+// does.
+func (e env) senderAllowed(login string) bool {
+	for _, denied := range e.deniedSenders {
+		if strings.EqualFold(denied, login) {
+			return false
+		}
+	}
+	if len(e.allowedSenders) == 0 {
+		return true
+	}
+	for _, allowed := range e.allowedSenders {
+		if strings.EqualFold(allowed, login) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSenderFiltering(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		allowed  []string
+		denied   []string
+		event    string
+		wantSync bool
+	}{
+		{name: "no lists", event: "push", wantSync: true},
+		{name: "allowed sender", allowed: []string{"octocat"}, event: "push", wantSync: true},
+		{name: "sender not allowed", allowed: []string{"someone"}, event: "push"},
+		{name: "denied sender", denied: []string{"octocat"}, event: "push"},
+		{name: "denied beats allowed", allowed: []string{"octocat"}, denied: []string{"octocat"}, event: "push"},
+		{name: "other sender denied", denied: []string{"dependabot[bot]"}, event: "push", wantSync: true},
+		{name: "case ignored", denied: []string{"OctoCat"}, event: "push"},
+		{name: "sync-all isn't filtered", denied: []string{"octocat"}, event: "sync-all", wantSync: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.allowedSenders, e.deniedSenders = tc.allowed, tc.denied
+			body := pushBody("api", "refs/heads/master")
+			if tc.event == "sync-all" {
+				body = syncAllBody("api")
+			}
+			logs := captureLog(t)
+			if w := serve(e, newDelivery(tc.event, body)); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			_, err := os.Stat(filepath.Join(e.hugoSource, e.dir, "api.md"))
+			if got := err == nil; got != tc.wantSync {
+				t.Errorf("synced: got %v, want %v", got, tc.wantSync)
+			}
+			if got := strings.Contains(logs.String(), "sent by octocat"); got == tc.wantSync {
+				t.Errorf("logged the ignored sender: got %v, want %v\n%s", got, !tc.wantSync, logs)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// listenAddr returns the address LISTEN_ADDR, addr, says to listen on,
+// defaulting to 0.0.0.0:9001.
+func listenAddr(addr string) (string, error) {
+	if addr == "" {
+		return "0.0.0.0:9001", nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if port == "" {
+		return "", errors.New("missing port in address " + addr)
+	}
+	return addr, nil
+}
+
+type serverTimeouts struct {
+	readHeader time.Duration
+	read       time.Duration
+	write      time.Duration
+	idle       time.Duration
+}
+
+// newServer returns a server for handler on addr with timeouts set, so
+// slow clients can't hold connections open indefinitely. HTTP/2 is served
+// alongside HTTP/1.1, including over plaintext connections.
+func newServer(addr string, handler http.Handler, timeouts serverTimeouts) *http.Server {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeouts.readHeader,
+		ReadTimeout:       timeouts.read,
+		WriteTimeout:      timeouts.write,
+		IdleTimeout:       timeouts.idle,
+		Protocols:         &protocols,
+	}
+}
+
+// shutdownOnSignal waits for SIGINT or SIGTERM, then stops server
+// accepting new connections and waits up to timeout for the requests in
+// flight, including any Hugo builds they're running, and then any builds
+// left for later in background, batched or with BUILD_ASYNC, to finish.
+// stopped is closed once it's done.
+func shutdownOnSignal(server *http.Server, timeout time.Duration, stopped chan<- struct{}, background ...waiter) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	shutdownOn(signals, server, timeout, stopped, background...)
+}
+
+// A waiter is something builds are left running on after their delivery
+// has been answered.
+type waiter interface {
+	wait(ctx context.Context) error
+}
+
+// shutdownOn shuts server down once a signal arrives on signals.
+func shutdownOn(signals <-chan os.Signal, server *http.Server, timeout time.Duration, stopped chan<- struct{}, background ...waiter) {
+	defer close(stopped)
+	sig := <-signals
+	log.Println("received " + sig.String() + ", shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Println("shutdown:", err)
+	}
+	for _, w := range background {
+		if err := w.wait(ctx); err != nil {
+			log.Println("shutdown: gave up waiting for background builds:", err)
+			return
+		}
+	}
+}
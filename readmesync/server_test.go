@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startServer serves handler with timeouts on a local port, returning its
+// address.
+func startServer(t *testing.T, handler http.Handler, timeouts serverTimeouts) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(ln.Addr().String(), handler, timeouts)
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return ln.Addr().String()
+}
+
+func TestSlowHeadersTimedOut(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		timeout time.Duration
+		wantCut bool
+	}{
+		{name: "header timeout", timeout: 100 * time.Millisecond, wantCut: true},
+		{name: "no header timeout"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := startServer(t, http.HandlerFunc(health), serverTimeouts{readHeader: tc.timeout})
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			// Send part of the headers, then nothing else, like a
+			// slowloris client.
+			if _, err := conn.Write([]byte("GET /health HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			start := time.Now()
+			_, err = ioutil.ReadAll(conn)
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if tc.wantCut {
+					t.Error("the server kept waiting for the rest of the headers")
+				}
+				return
+			}
+			if !tc.wantCut {
+				t.Fatalf("the server hung up after %s: %v", time.Since(start), err)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("the server hung up after %s, want about %s", elapsed, tc.timeout)
+			}
+		})
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}), serverTimeouts{readHeader: time.Second})
+	for _, tc := range []struct {
+		name  string
+		http2 bool
+		want  string
+	}{
+		{name: "HTTP/1.1", want: "HTTP/1.1"},
+		{name: "unencrypted HTTP/2", http2: true, want: "HTTP/2.0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var protocols http.Protocols
+			protocols.SetHTTP1(!tc.http2)
+			protocols.SetUnencryptedHTTP2(tc.http2)
+			client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+			resp, err := client.Get("http://" + addr + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(b)); got != tc.want {
+				t.Errorf("served over %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{addr: "", want: "0.0.0.0:9001"},
+		{addr: "127.0.0.1:8080", want: "127.0.0.1:8080"},
+		{addr: ":9002", want: ":9002"},
+		{addr: "[::1]:9001", want: "[::1]:9001"},
+		{addr: "localhost", wantErr: true},
+		{addr: "localhost:", wantErr: true},
+		{addr: "::1", wantErr: true},
+	} {
+		t.Run(tc.addr, func(t *testing.T) {
+			got, err := listenAddr(tc.addr)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want one: %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestListensOnAddr(t *testing.T) {
+	addr, err := listenAddr("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(addr, http.HandlerFunc(health), serverTimeouts{readHeader: time.Second})
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	if host, _, _ := net.SplitHostPort(ln.Addr().String()); host != "127.0.0.1" {
+		t.Errorf("listening on %s, want 127.0.0.1", host)
+	}
+	resp, err := http.Get("http://" + ln.Addr().String() + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if b, _ := ioutil.ReadAll(resp.Body); string(b) != "ok" {
+		t.Errorf("got %q, want ok", b)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		timeout      time.Duration
+		wantFinished bool
+	}{
+		{name: "request finishes", timeout: 5 * time.Second, wantFinished: true},
+		{name: "timeout", timeout: 50 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			started, release := make(chan struct{}), make(chan struct{})
+			server := newServer("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Stands in for a sync that's still running its build.
+				close(started)
+				<-release
+				w.Write([]byte("built"))
+			}), serverTimeouts{readHeader: time.Second})
+			ln, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			served := make(chan error, 1)
+			go func() { served <- server.Serve(ln) }()
+			t.Cleanup(func() { server.Close() })
+
+			responded := make(chan string, 1)
+			go func() {
+				resp, err := http.Get("http://" + ln.Addr().String() + "/hook")
+				if err != nil {
+					responded <- err.Error()
+					return
+				}
+				defer resp.Body.Close()
+				b, _ := ioutil.ReadAll(resp.Body)
+				responded <- string(b)
+			}()
+			<-started
+			signals, stopped := make(chan os.Signal, 1), make(chan struct{})
+			go shutdownOn(signals, server, tc.timeout, stopped)
+			signals <- syscall.SIGTERM
+			if err := <-served; err != http.ErrServerClosed {
+				t.Fatalf("Serve returned %v, want %v", err, http.ErrServerClosed)
+			}
+			if _, err := http.Get("http://" + ln.Addr().String() + "/hook"); err == nil {
+				t.Error("new request accepted while shutting down")
+			}
+			if tc.wantFinished {
+				select {
+				case <-stopped:
+					t.Fatal("shut down before the request in flight finished")
+				case <-time.After(100 * time.Millisecond):
+				}
+				close(release)
+				if got := <-responded; got != "built" {
+					t.Errorf("got response %q, want built", got)
+				}
+				<-stopped
+				return
+			}
+			select {
+			case <-stopped:
+			case <-time.After(2 * time.Second):
+				t.Fatal("shutdown didn't give up after its timeout")
+			}
+			close(release)
+		})
+	}
+}
+
+// TestShutdownWaitsForBackgroundBuilds checks that with BUILD_ASYNC or
+// BUILD_BATCH_WINDOW, shutting down waits, up to its timeout, for the
+// builds of deliveries that have already been answered.
+func TestShutdownWaitsForBackgroundBuilds(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		batched      bool
+		timeout      time.Duration
+		wantFinished bool
+	}{
+		{name: "build finishes", timeout: 5 * time.Second, wantFinished: true},
+		{name: "timeout", timeout: 50 * time.Millisecond},
+		{name: "batched build finishes", batched: true, timeout: 5 * time.Second, wantFinished: true},
+		{name: "batched timeout", batched: true, timeout: 50 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.hugoCmd = fakeHugo(t, "sleep 0.5\ntouch \"$0.finished\"\n")
+			want := http.StatusAccepted
+			if tc.batched {
+				e.batcher = &batcher{window: 100 * time.Millisecond, run: e.buildAndReport}
+				want = http.StatusOK
+			} else {
+				e.worker = newBuildWorker(e.buildAndReport)
+			}
+			if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != want {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			logs := captureLog(t)
+			server := newServer("127.0.0.1:0", e, serverTimeouts{})
+			signals, stopped := make(chan os.Signal, 1), make(chan struct{})
+			go shutdownOn(signals, server, tc.timeout, stopped, e.batcher, e.worker)
+			signals <- syscall.SIGTERM
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatal("shutdown didn't finish")
+			}
+			_, err := os.Stat(e.hugoCmd + ".finished")
+			if finished := err == nil; finished != tc.wantFinished {
+				t.Errorf("build finished before shutdown: got %v, want %v", finished, tc.wantFinished)
+			}
+			if gaveUp := strings.Contains(logs.String(), "gave up waiting for background builds"); gaveUp == tc.wantFinished {
+				t.Errorf("logged giving up: got %v, want %v:\n%s", gaveUp, !tc.wantFinished, logs.String())
+			}
+			// Let the build finish before its temp dir is removed.
+			e.batcher.wait(context.Background())
+			e.worker.wait(context.Background())
+		})
+	}
+}
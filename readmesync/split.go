@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const partTmpl = `
++++
+date = {{ toml .Date }}
+title = {{ toml .Title }}
+repo = {{ toml .Repo }}
+weight = {{ .Weight }}
++++
+
+{{ .Body }}
+`
+
+var partTemplate = template.Must(template.New("part").Funcs(tmplFuncs).Parse(partTmpl))
+
+type readmePart struct {
+	Title string
+	Slug  string
+	Body  string
+}
+
+type partData struct {
+	Date   string
+	Title  string
+	Repo   string
+	Weight int
+	Body   string
+}
+
+// newPartData returns the template data for the ith of the parts data's
+// README was split into.
+func newPartData(data pageData, i int, part readmePart) partData {
+	return partData{
+		Date:   data.Date,
+		Title:  part.Title,
+		Repo:   data.Repo,
+		Weight: i + 1,
+		Body:   part.Body,
+	}
+}
+
+// splitReadme splits readme at its headings of level or above, returning
+// what comes before the first of them and each section after. A level 1
+// heading that starts the README is its title, and stays in the intro.
+// Headings with an explicit {#id}, like HEADING_ANCHORS adds, use it as
+// their slug. The slug "index" is never used, as index.md would turn the
+// section into a leaf bundle.
+func splitReadme(readme string, level int) (string, []readmePart) {
+	var intro strings.Builder
+	var parts []readmePart
+	var body *strings.Builder
+	slugs := slugger{"index": true}
+	seenHeading := false
+	inFence := false
+	scanner := bufio.NewScanner(strings.NewReader(readme))
+	scanner.Buffer(nil, len(readme)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isFence(line) {
+			inFence = !inFence
+		}
+		if !inFence {
+			if l, text, ok := atxHeading(line); ok {
+				title := !seenHeading && l == 1 && strings.TrimSpace(intro.String()) == ""
+				seenHeading = true
+				if l <= level && !title {
+					if body != nil {
+						parts[len(parts)-1].Body = strings.TrimSpace(body.String())
+					}
+					heading, id, explicit := headingAnchor(text)
+					if !explicit {
+						id = heading
+					}
+					parts = append(parts, readmePart{Title: heading, Slug: slugs.unique(id)})
+					body = &strings.Builder{}
+					continue
+				}
+			}
+		}
+		if body != nil {
+			body.WriteString(line + "\n")
+		} else {
+			intro.WriteString(line + "\n")
+		}
+	}
+	if body != nil {
+		parts[len(parts)-1].Body = strings.TrimSpace(body.String())
+	}
+	return intro.String(), parts
+}
+
+// isSplitPage reports whether dir holds a README that was split into
+// several pages.
+func isSplitPage(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "_index.md"))
+	return err == nil
+}
+
+// writeSplitPage writes the intro of repo's README, with the usual front
+// matter, to dir/_index.md, and each of parts to a page of its own in dir,
+// replacing whatever was there before.
+func (e env) writeSplitPage(repo, dir string, data pageData, intro string, parts []readmePart) (int64, error) {
+	err := os.Remove(dir + ".md")
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return 0, err
+	}
+	data.Readme = intro
+	written, err := e.writeFile(filepath.Join(dir, "_index.md"), repo, e.pageTmpl, data)
+	if err != nil {
+		return written, err
+	}
+	for i, part := range parts {
+		n, err := e.writeFile(filepath.Join(dir, part.Slug+".md"), repo, partTemplate, newPartData(data, i, part))
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
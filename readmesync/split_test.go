@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSplitReadme(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		readme    string
+		level     int
+		wantIntro string
+		want      []readmePart
+	}{
+		{
+			name:      "sections",
+			readme:    "# api\n\nIntro.\n\n## Install\n\nGo get it.\n\n## Usage\n\nRun it.\n",
+			level:     2,
+			wantIntro: "# api\n\nIntro.\n\n",
+			want:      []readmePart{{Title: "Install", Slug: "install", Body: "Go get it."}, {Title: "Usage", Slug: "usage", Body: "Run it."}},
+		},
+		{
+			name:      "deeper headings stay in their section",
+			readme:    "# api\n\n## Install\n\n### From source\n\nBuild it.\n",
+			level:     2,
+			wantIntro: "# api\n\n",
+			want:      []readmePart{{Title: "Install", Slug: "install", Body: "### From source\n\nBuild it."}},
+		},
+		{
+			name:      "level 1 only",
+			readme:    "# api\n\n## Install\n\n# Reference\n\nSee the docs.\n",
+			level:     1,
+			wantIntro: "# api\n\n## Install\n\n",
+			want:      []readmePart{{Title: "Reference", Slug: "reference", Body: "See the docs."}},
+		},
+		{
+			name:      "headings in code blocks",
+			readme:    "## Install\n\n```sh\n## not a heading\n```\n",
+			level:     2,
+			wantIntro: "",
+			want:      []readmePart{{Title: "Install", Slug: "install", Body: "```sh\n## not a heading\n```"}},
+		},
+		{
+			name:      "explicit anchors",
+			readme:    "## Install {#install}\n\nGo get it.\n\n## Usage {#getting-started}\n\nRun it.\n",
+			level:     2,
+			wantIntro: "",
+			want:      []readmePart{{Title: "Install", Slug: "install", Body: "Go get it."}, {Title: "Usage", Slug: "getting-started", Body: "Run it."}},
+		},
+		{
+			name:      "repeated headings",
+			readme:    "## Usage\n\nOne.\n\n## Usage\n\nTwo.\n",
+			level:     2,
+			wantIntro: "",
+			want:      []readmePart{{Title: "Usage", Slug: "usage", Body: "One."}, {Title: "Usage", Slug: "usage-1", Body: "Two."}},
+		},
+		{
+			name:      "index is reserved",
+			readme:    "## Index\n\nEverything.\n\n## Other {#index}\n\nMore.\n",
+			level:     2,
+			wantIntro: "",
+			want:      []readmePart{{Title: "Index", Slug: "index-1", Body: "Everything."}, {Title: "Other", Slug: "index-2", Body: "More."}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			intro, parts := splitReadme(tc.readme, tc.level)
+			if intro != tc.wantIntro {
+				t.Errorf("got intro %q, want %q", intro, tc.wantIntro)
+			}
+			if !reflect.DeepEqual(parts, tc.want) {
+				t.Errorf("got parts %+v, want %+v", parts, tc.want)
+			}
+		})
+	}
+}
+
+func TestSplitPages(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		anchors   bool
+		wantPages []string
+	}{
+		{name: "split", wantPages: []string{"_index.md", "install.md", "usage.md"}},
+		{name: "split, with heading anchors", anchors: true, wantPages: []string{"_index.md", "install.md", "usage.md"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n\nIntro.\n\n## Install\n\nGo get it.\n\n## Usage\n\nRun it.\n")
+			e := newTestEnv(t, gh.URL)
+			e.splitLevel, e.headingAnchors = 2, tc.anchors
+			if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			dir := filepath.Join(e.hugoSource, e.dir, "api")
+			infos, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pages []string
+			for _, info := range infos {
+				pages = append(pages, info.Name())
+			}
+			sort.Strings(pages)
+			if !reflect.DeepEqual(pages, tc.wantPages) {
+				t.Fatalf("got pages %q, want %q", pages, tc.wantPages)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(dir, "install.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			page := string(b)
+			for _, want := range []string{`title = "Install"`, `repo = "api"`, "weight = 1", "Go get it."} {
+				if !strings.Contains(page, want) {
+					t.Errorf("install.md doesn't contain %q:\n%s", want, page)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAllowStale(t *testing.T) {
+	const (
+		up = iota
+		erroring
+		unreachable
+	)
+	for _, tc := range []struct {
+		name       string
+		allowStale bool
+		cached     bool
+		outage     int
+		want       int
+		wantPage   string
+		wantLogs   string
+	}{
+		{name: "GitHub erroring", allowStale: true, cached: true, outage: erroring, want: http.StatusOK, wantPage: "# api, cached", wantLogs: "GitHub returned 503 Service Unavailable, using stale cached content"},
+		{name: "GitHub unreachable", allowStale: true, cached: true, outage: unreachable, want: http.StatusOK, wantPage: "# api, cached", wantLogs: "GitHub unreachable, using stale cached content"},
+		{name: "GitHub up", allowStale: true, cached: true, outage: up, want: http.StatusOK, wantPage: "# api, updated"},
+		{name: "stale not allowed", cached: true, outage: erroring, want: http.StatusInternalServerError, wantPage: "# api, cached"},
+		{name: "nothing cached", allowStale: true, outage: erroring, want: http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			var outage int32
+			gh.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch atomic.LoadInt32(&outage) {
+				case erroring:
+					w.WriteHeader(http.StatusServiceUnavailable)
+				case unreachable:
+					panic(http.ErrAbortHandler)
+				default:
+					gh.serve(w, r)
+				}
+			})
+			gh.setReadme("darlinggo/api", "# api, cached\n")
+			e := newTestEnv(t, gh.URL)
+			e.cache, e.allowStale = fileStore{dir: t.TempDir()}, tc.allowStale
+			if tc.cached {
+				if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+					t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+				}
+			}
+			gh.setReadme("darlinggo/api", "# api, updated\n")
+			atomic.StoreInt32(&outage, int32(tc.outage))
+			logs := captureLog(t)
+			if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != tc.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			if tc.wantPage != "" {
+				if page := readPage(t, e, "api"); !strings.Contains(page, tc.wantPage) {
+					t.Errorf("page doesn't contain %q:\n%s", tc.wantPage, page)
+				}
+			}
+			if runs := hugoRuns(t, e.hugoCmd); tc.want == http.StatusOK && len(runs) != 2 {
+				t.Errorf("hugo ran %d times, want a rebuild after the first sync", len(runs))
+			}
+			if !strings.Contains(logs.String(), tc.wantLogs) {
+				t.Errorf("logs don't contain %q:\n%s", tc.wantLogs, logs)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// activity counts the builds and README fetches in progress.
+type activity struct {
+	builds  int64
+	fetches int64
+}
+
+// track adds one to counter, and returns a func that takes it away again.
+func track(counter *int64) func() {
+	atomic.AddInt64(counter, 1)
+	return func() { atomic.AddInt64(counter, -1) }
+}
+
+type status struct {
+	ActiveBuilds  int64 `json:"active_builds"`
+	ActiveFetches int64 `json:"active_fetches"`
+	Building      bool  `json:"building"`
+
+	// The outcome of the builds so far. LastError is the latest build's
+	// error, if it failed, and LastRepos how many repos it synced.
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastRepos   int        `json:"last_repos"`
+}
+
+func (e env) status(w http.ResponseWriter, r *http.Request) {
+	s := status{
+		ActiveBuilds:  atomic.LoadInt64(&e.activity.builds),
+		ActiveFetches: atomic.LoadInt64(&e.activity.fetches),
+	}
+	s.Building = s.ActiveBuilds > 0
+	e.reports.RLock()
+	if !e.reports.lastSuccess.IsZero() {
+		success := e.reports.lastSuccess
+		s.LastSuccess = &success
+	}
+	if last := e.reports.last; last != nil {
+		s.LastError = last.Error
+		s.LastRepos = len(last.Repos)
+	}
+	e.reports.RUnlock()
+	b, err := json.Marshal(s)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func readStatus(t *testing.T, e env) status {
+	t.Helper()
+	w := httptest.NewRecorder()
+	e.status(w, httptest.NewRequest("GET", "/status", nil))
+	var s status
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// waitForStatus polls /status until ok is true of it.
+func waitForStatus(t *testing.T, e env, what string, ok func(s status) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := readStatus(t, e)
+		if ok(s) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s: got %+v", what, s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestActivityCounters fires concurrent pushes, holding their README
+// fetches and then their builds, and checks /status counts them and goes
+// back to zero once they're done. Run it with -race.
+func TestActivityCounters(t *testing.T) {
+	const pushes = 5
+	gh := newFakeGitHub(t)
+	release := make(chan struct{})
+	gh.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/readme") {
+			<-release
+		}
+		gh.serve(w, r)
+	})
+	for i := 0; i < pushes; i++ {
+		gh.setReadme("darlinggo/repo"+strconv.Itoa(i), "# repo\n")
+	}
+	e := newTestEnv(t, gh.URL)
+	unblock := filepath.Join(t.TempDir(), "unblock")
+	e.hugoCmd = fakeHugo(t, `while [ ! -e "`+unblock+`" ]; do sleep 0.01; done`)
+	e.parallelBuilds = true
+	e.repoConfigs = map[string]string{}
+	e.outputDirs = map[string]string{}
+	for i := 0; i < pushes; i++ {
+		config := "repo" + strconv.Itoa(i) + ".toml"
+		e.repoConfigs["repo"+strconv.Itoa(i)] = config
+		e.outputDirs[config] = "public/" + strconv.Itoa(i)
+	}
+
+	if s := readStatus(t, e); s.ActiveBuilds != 0 || s.ActiveFetches != 0 || s.Building {
+		t.Fatalf("got %+v before any pushes", s)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < pushes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := serve(e, newDelivery("push", pushBody("repo"+strconv.Itoa(i), "refs/heads/master")))
+			if w.Code != http.StatusOK {
+				t.Errorf("got status %d: %s", w.Code, w.Body.String())
+			}
+		}(i)
+	}
+	waitForStatus(t, e, "the fetches", func(s status) bool { return s.ActiveFetches == pushes && s.ActiveBuilds == 0 })
+	close(release)
+	waitForStatus(t, e, "the builds", func(s status) bool { return s.ActiveFetches == 0 && s.ActiveBuilds == pushes && s.Building })
+	if err := ioutil.WriteFile(unblock, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if s := readStatus(t, e); s.ActiveBuilds != 0 || s.ActiveFetches != 0 || s.Building {
+		t.Errorf("got %+v once idle", s)
+	}
+}
+
+func TestLastBuildStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		hugo        []string
+		wantSuccess bool
+		wantError   bool
+		wantRepos   int
+	}{
+		{name: "no builds yet"},
+		{name: "successful build", hugo: []string{""}, wantSuccess: true, wantRepos: 2},
+		{name: "failed build", hugo: []string{"exit 1\n"}, wantError: true, wantRepos: 2},
+		{name: "failure after a success", hugo: []string{"", "exit 1\n"}, wantSuccess: true, wantError: true, wantRepos: 2},
+		{name: "success after a failure", hugo: []string{"exit 1\n", ""}, wantSuccess: true, wantRepos: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			gh.setReadme("darlinggo/hash", "# hash\n")
+			e := newTestEnv(t, gh.URL)
+			start := time.Now()
+			for _, hugo := range tc.hugo {
+				e.hugoCmd = fakeHugo(t, hugo)
+				serve(e, newDelivery("sync-all", syncAllBody("api", "hash")))
+			}
+			s := readStatus(t, e)
+			if got := s.LastSuccess != nil; got != tc.wantSuccess {
+				t.Fatalf("got last success %v, want one: %v", s.LastSuccess, tc.wantSuccess)
+			}
+			if s.LastSuccess != nil && s.LastSuccess.Before(start) {
+				t.Errorf("got last success %s, before the sync at %s", s.LastSuccess, start)
+			}
+			if got := s.LastError != ""; got != tc.wantError {
+				t.Errorf("got last error %q, want one: %v", s.LastError, tc.wantError)
+			}
+			if s.LastRepos != tc.wantRepos {
+				t.Errorf("got %d repos in the last sync, want %d", s.LastRepos, tc.wantRepos)
+			}
+			if s.Building {
+				t.Error("still building once the syncs were answered")
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncAllWritesPages(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		pipeline   bool
+		missing    string
+		wantPages  []string
+		wantFailed int
+	}{
+		{name: "three repos", wantPages: []string{"api.md", "hash.md", "site.md"}},
+		{name: "three repos, pipelined", pipeline: true, wantPages: []string{"api.md", "hash.md", "site.md"}},
+		{name: "one missing README", missing: "hash", wantPages: []string{"api.md", "site.md"}, wantFailed: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			for _, repo := range []string{"api", "hash", "site"} {
+				if repo != tc.missing {
+					gh.setReadme("darlinggo/"+repo, "# "+repo+" readme\n")
+				}
+			}
+			e := newTestEnv(t, gh.URL)
+			e.pipeline = tc.pipeline
+			w := serve(e, newDelivery("sync-all", syncAllBody("api", "hash", "site")))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			var s syncSummary
+			if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+				t.Fatalf("%v: %s", err, w.Body.String())
+			}
+			if s.Succeeded != len(tc.wantPages) || s.Failed != tc.wantFailed {
+				t.Errorf("got %+v, want %d synced and %d failed", s, len(tc.wantPages), tc.wantFailed)
+			}
+			infos, err := ioutil.ReadDir(filepath.Join(e.hugoSource, e.dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pages []string
+			for _, info := range infos {
+				pages = append(pages, info.Name())
+			}
+			sort.Strings(pages)
+			if !reflect.DeepEqual(pages, tc.wantPages) {
+				t.Errorf("got pages %q, want %q", pages, tc.wantPages)
+			}
+			for _, page := range tc.wantPages {
+				repo := strings.TrimSuffix(page, ".md")
+				if got := readPage(t, e, repo); !strings.Contains(got, "# "+repo+" readme") {
+					t.Errorf("%s doesn't contain its README:\n%s", page, got)
+				}
+			}
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != 1 {
+				t.Errorf("hugo ran %d times, want once", len(runs))
+			}
+		})
+	}
+}
+
+// TestSyncAllManyRepos checks that every page of a large sync-all is
+// written out in full.
+func TestSyncAllManyRepos(t *testing.T) {
+	gh := newFakeGitHub(t)
+	var repos []string
+	for i := 0; i < 50; i++ {
+		repo := "repo" + strconv.Itoa(i)
+		repos = append(repos, repo)
+		gh.setReadme("darlinggo/"+repo, "# "+repo+"\n\n"+strings.Repeat(repo+" line\n", 200)+"end of "+repo+"\n")
+	}
+	e := newTestEnv(t, gh.URL)
+	w := serve(e, newDelivery("sync-all", syncAllBody(repos...)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	for _, repo := range repos {
+		page := readPage(t, e, repo)
+		if n := strings.Count(page, repo+" line\n"); n != 200 || !strings.Contains(page, "end of "+repo+"\n") {
+			t.Errorf("%s is incomplete: %d of 200 lines", repo, n)
+		}
+	}
+	left, err := filepath.Glob(filepath.Join(e.hugoSource, e.dir, ".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) > 0 {
+		t.Errorf("left behind %v", left)
+	}
+}
+
+func TestSyncAllPartialFailure(t *testing.T) {
+	for _, pipeline := range []bool{false, true} {
+		t.Run("pipeline "+strconv.FormatBool(pipeline), func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			e.pipeline = pipeline
+			w := serve(e, newDelivery("sync-all", syncAllBody("api", "missing")))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("got Content-Type %q", ct)
+			}
+			var s syncSummary
+			if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+				t.Fatalf("%v: %s", err, w.Body.String())
+			}
+			if s.Succeeded != 1 || s.Failed != 1 || !reflect.DeepEqual(s.Synced, []string{"api"}) {
+				t.Errorf("got %+v, want api synced and one failure", s)
+			}
+			if msg := s.Errors["missing"]; !strings.Contains(msg, "404") {
+				t.Errorf("got error %q for missing, want a 404", msg)
+			}
+			if page := readPage(t, e, "api"); !strings.Contains(page, "# api") {
+				t.Errorf("api page doesn't contain its README:\n%s", page)
+			}
+		})
+	}
+}
+
+func TestSyncConcurrency(t *testing.T) {
+	for _, limit := range []int{1, 4, 8} {
+		t.Run(strconv.Itoa(limit), func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			var repos []string
+			for i := 0; i < 100; i++ {
+				repo := "repo" + strconv.Itoa(i)
+				repos = append(repos, repo)
+				gh.setReadme("darlinggo/"+repo, "# "+repo+"\n")
+			}
+			var mu sync.Mutex
+			inFlight, most := 0, 0
+			gh.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				inFlight++
+				if inFlight > most {
+					most = inFlight
+				}
+				mu.Unlock()
+				time.Sleep(2 * time.Millisecond)
+				gh.serve(w, r)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			})
+			e := newTestEnv(t, gh.URL)
+			e.syncConcurrency = limit
+			readmes, failed := e.syncAll(context.Background(), repos)
+			if len(readmes) != len(repos) || len(failed) != 0 {
+				t.Fatalf("got %d READMEs and %d failures, want %d and none", len(readmes), len(failed), len(repos))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if most > limit {
+				t.Errorf("got %d fetches at once, want at most %d", most, limit)
+			}
+			if limit > 1 && most < 2 {
+				t.Errorf("got %d fetches at once, want them to run concurrently", most)
+			}
+		})
+	}
+}
+
+// TestStableSyncAllOrder checks that sync-all answers the same way every
+// time, however the repos were listed and whichever READMEs arrive first.
+func TestStableSyncAllOrder(t *testing.T) {
+	repos := []string{"site", "api", "zeta", "hash", "beta", "docs"}
+	for _, pipeline := range []bool{false, true} {
+		t.Run("pipeline "+strconv.FormatBool(pipeline), func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			for _, repo := range repos {
+				if repo != "zeta" && repo != "beta" {
+					gh.setReadme("darlinggo/"+repo, "# "+repo+"\n")
+				}
+			}
+			var mu sync.Mutex
+			delays := map[string]int{}
+			gh.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Vary which READMEs arrive first from one sync to the next.
+				mu.Lock()
+				delays[r.URL.Path] = (delays[r.URL.Path] + len(r.URL.Path)) % 7
+				delay := time.Duration(delays[r.URL.Path]) * time.Millisecond
+				mu.Unlock()
+				time.Sleep(delay)
+				gh.serve(w, r)
+			})
+			e := newTestEnv(t, gh.URL)
+			e.pipeline = pipeline
+			var first string
+			for i := 0; i < 5; i++ {
+				order := append(append([]string{}, repos[i:]...), repos[:i]...)
+				w := serve(e, newDelivery("sync-all", syncAllBody(order...)))
+				var summary syncSummary
+				if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+					t.Fatal(err)
+				}
+				if !sort.StringsAreSorted(summary.Synced) {
+					t.Errorf("synced repos %q aren't sorted", summary.Synced)
+				}
+				if i == 0 {
+					first = w.Body.String()
+				} else if w.Body.String() != first {
+					t.Errorf("sync %d answered\n%s\nwant the same as the first\n%s", i, w.Body.String(), first)
+				}
+			}
+		})
+	}
+}
+
+func TestResultsOfOrder(t *testing.T) {
+	readmes := map[string][]byte{}
+	for _, repo := range []string{"site", "api", "zeta", "hash", "beta"} {
+		readmes[repo] = []byte("# " + repo + "\n")
+	}
+	for i := 0; i < 10; i++ {
+		var got []string
+		for result := range resultsOf(readmes) {
+			got = append(got, result.repo)
+		}
+		if want := []string{"api", "beta", "hash", "site", "zeta"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
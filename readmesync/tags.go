@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"strings"
+)
+
+const defaultTagPageName = "{{ .Repo }}-{{ .Tag }}"
+
+type tagPage struct {
+	repo string
+	tag  string
+}
+
+// tagPageName renders the page name for repo's README at tag, using the
+// TAG_PAGE_NAME template. Slashes in the tag are replaced so the page
+// stays within the output directory.
+func (e env) tagPageName(repo, tag string) (string, error) {
+	var buf bytes.Buffer
+	err := e.tagPageTmpl.Execute(&buf, struct {
+		Repo string
+		Tag  string
+	}{
+		Repo: repo,
+		Tag:  strings.Replace(tag, "/", "-", -1),
+	})
+	if err != nil {
+		return "", err
+	}
+	name := buf.String()
+	if name == repo || !safePageName(name) || strings.Count(name, "/") != strings.Count(repo, "/") {
+		return "", errors.New(repo + ": invalid page name " + name + " for tag " + tag)
+	}
+	return name, nil
+}
+
+// safePageName reports whether name is a clean relative path that stays
+// within the output directory.
+func safePageName(name string) bool {
+	if name == "" || strings.Contains(name, `\`) || path.Clean(name) != name || path.IsAbs(name) {
+		return false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+	return true
+}
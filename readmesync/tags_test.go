@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestTagPages(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		template   string
+		tag        string
+		wantPage   string
+		wantStatus int
+	}{
+		{name: "default name", tag: "v1.2.3", wantPage: "api-v1.2.3", wantStatus: http.StatusOK},
+		{name: "tag with a slash", tag: "release/1.2", wantPage: "api-release-1.2", wantStatus: http.StatusOK},
+		{name: "custom name", template: "{{ .Repo }}@{{ .Tag }}", tag: "v1.2.3", wantPage: "api@v1.2.3", wantStatus: http.StatusOK},
+		{name: "name of the repo's own page", template: "{{ .Repo }}", tag: "v1.2.3", wantStatus: http.StatusBadRequest},
+		{name: "name outside the output dir", template: "../{{ .Tag }}", tag: "v1.2.3", wantStatus: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			gh.setReadme("darlinggo/api@"+tc.tag, "# api at "+tc.tag+"\n")
+			e := newTestEnv(t, gh.URL)
+			e.syncTags = true
+			if tc.template != "" {
+				e.tagPageTmpl = template.Must(template.New("tag page").Parse(tc.template))
+			}
+			writeTestPage(t, e, "api")
+			w := serve(e, newDelivery("push", pushBody("api", "refs/tags/"+tc.tag)))
+			if w.Code != tc.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tc.wantStatus, w.Body.String())
+			}
+			if tc.wantPage == "" {
+				return
+			}
+			page := readPage(t, e, tc.wantPage)
+			for _, want := range []string{
+				`repo = "api"`,
+				`version = "` + tc.tag + `"`,
+				`url = "/` + tc.wantPage + `"`,
+				"# api at " + tc.tag + "\n",
+			} {
+				if !strings.Contains(page, want) {
+					t.Errorf("page doesn't contain %q:\n%s", want, page)
+				}
+			}
+			if got := readPage(t, e, "api"); got != "# api\n" {
+				t.Errorf("repo's own page changed to:\n%s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+const defaultTOCMarker = "<!-- toc -->"
+
+type tocEntry struct {
+	level int
+	text  string
+	slug  string
+}
+
+// headings lists the ATX headings in readme, outside of code fences, with
+// the slugs addHeadingAnchors would give them.
+func headings(readme []byte) []tocEntry {
+	var entries []tocEntry
+	slugs := newSlugger(readme)
+	inFence := false
+	scanner := bufio.NewScanner(bytes.NewReader(readme))
+	scanner.Buffer(nil, len(readme)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isFence(line) {
+			inFence = !inFence
+		}
+		if inFence {
+			continue
+		}
+		level, text, ok := atxHeading(line)
+		if !ok {
+			continue
+		}
+		entry := tocEntry{level: level, text: text}
+		if text, id, ok := headingAnchor(text); ok {
+			entry.text, entry.slug = text, id
+		} else {
+			entry.slug = slugs.unique(text)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// addTOC replaces the first line of readme that's just marker with a list
+// of links to the headings below level 1. READMEs without the marker are
+// returned as they are.
+func addTOC(readme []byte, marker string) []byte {
+	var toc strings.Builder
+	for _, entry := range headings(readme) {
+		if entry.level < 2 {
+			continue
+		}
+		toc.WriteString(strings.Repeat("  ", entry.level-2) + "- [" + entry.text + "](#" + entry.slug + ")\n")
+	}
+	replaced := false
+	return eachLine(readme, func(line string) string {
+		if replaced || strings.TrimSpace(line) != marker {
+			return line
+		}
+		replaced = true
+		return strings.TrimSuffix(toc.String(), "\n")
+	})
+}
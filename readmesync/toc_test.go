@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAddTOC(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		readme string
+		want   string
+	}{
+		{
+			name:   "marker",
+			readme: "# api\n\n<!-- toc -->\n\n## Install\n\n### From source\n\n## Usage\n",
+			want:   "# api\n\n- [Install](#install)\n  - [From source](#from-source)\n- [Usage](#usage)\n\n## Install\n\n### From source\n\n## Usage\n",
+		},
+		{
+			name:   "no marker",
+			readme: "# api\n\n## Install\n\n## Usage\n",
+			want:   "# api\n\n## Install\n\n## Usage\n",
+		},
+		{
+			name:   "duplicate headings",
+			readme: "<!-- toc -->\n## Options\n### Options\n## Options\n",
+			want:   "- [Options](#options)\n  - [Options](#options-1)\n- [Options](#options-2)\n## Options\n### Options\n## Options\n",
+		},
+		{
+			name:   "explicit anchors",
+			readme: "<!-- toc -->\n## Usage\n## Install {#usage}\n",
+			want:   "- [Usage](#usage-1)\n- [Install](#usage)\n## Usage\n## Install {#usage}\n",
+		},
+		{
+			name:   "code fences",
+			readme: "<!-- toc -->\n## Example\n```sh\n## not a heading\n```\n",
+			want:   "- [Example](#example)\n## Example\n```sh\n## not a heading\n```\n",
+		},
+		{
+			name:   "only the first marker",
+			readme: "<!-- toc -->\n## A\n<!-- toc -->\n",
+			want:   "- [A](#a)\n## A\n<!-- toc -->\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(addTOC([]byte(tc.readme), defaultTOCMarker)); got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestTOCMatchesHeadingAnchors checks that, with HEADING_ANCHORS set too,
+// every link in the table of contents points at an anchor on the page,
+// repeated headings included.
+func TestTOCMatchesHeadingAnchors(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n\n<!-- toc -->\n\n## Options\n\n### Options\n\n## Usage {#options-1}\n\n## Options\n")
+	e := newTestEnv(t, gh.URL)
+	e.tocMarker, e.headingAnchors = defaultTOCMarker, true
+	if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	page := readPage(t, e, "api")
+	anchors := map[string]bool{}
+	for _, m := range regexp.MustCompile(`\{#([^}]+)\}`).FindAllStringSubmatch(page, -1) {
+		anchors[m[1]] = true
+	}
+	links := regexp.MustCompile(`\]\(#([^)]+)\)`).FindAllStringSubmatch(page, -1)
+	if len(links) != 4 {
+		t.Fatalf("got %d links in the table of contents, want 4:\n%s", len(links), page)
+	}
+	seen := map[string]bool{}
+	for _, m := range links {
+		if !anchors[m[1]] {
+			t.Errorf("link to #%s has no anchor:\n%s", m[1], page)
+		}
+		if seen[m[1]] {
+			t.Errorf("#%s linked more than once:\n%s", m[1], page)
+		}
+		seen[m[1]] = true
+	}
+	if !strings.Contains(page, "- [Usage](#options-1)") {
+		t.Errorf("explicit anchor not used:\n%s", page)
+	}
+}
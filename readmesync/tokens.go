@@ -0,0 +1,39 @@
+package main
+
+import "strings"
+
+// orgToken is the GitHub token for an org in GITHUB_TOKENS_FILE, along
+// with the rate limit it's subject to, since each token has its own.
+type orgToken struct {
+	token string
+	limit *rateLimit
+}
+
+// newOrgTokens builds the tokens to use for each org from a mapping of
+// org to token. Orgs are matched case-insensitively, as GitHub does.
+func newOrgTokens(tokens map[string]string) map[string]orgToken {
+	orgs := make(map[string]orgToken, len(tokens))
+	limits := map[string]*rateLimit{}
+	for org, token := range tokens {
+		// Orgs sharing a token share its rate limit.
+		if limits[token] == nil {
+			limits[token] = &rateLimit{}
+		}
+		orgs[strings.ToLower(org)] = orgToken{token: token, limit: limits[token]}
+	}
+	return orgs
+}
+
+// credentialsFor returns the token to request the API path with, and the
+// rate limit that token is subject to: the owner's own token from
+// GITHUB_TOKENS_FILE if it has one, otherwise GITHUB_TOKEN.
+func (e env) credentialsFor(path string) (string, *rateLimit) {
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(segments) >= 2 && (segments[0] == "repos" || segments[0] == "orgs" || segments[0] == "users") {
+		owner := strings.SplitN(segments[1], "?", 2)[0]
+		if t, ok := e.orgTokens[strings.ToLower(owner)]; ok {
+			return t.token, t.limit
+		}
+	}
+	return e.githubToken, e.rateLimit
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCredentialsFor(t *testing.T) {
+	e := newTestEnv(t, "http://github.invalid")
+	e.githubToken = "default-token"
+	e.orgTokens = newOrgTokens(map[string]string{"darlinggo": "darlinggo-token", "Acme": "acme-token", "acme-labs": "acme-token"})
+	for _, tc := range []struct {
+		path      string
+		want      string
+		wantLimit *rateLimit
+	}{
+		{path: "/repos/darlinggo/api/readme", want: "darlinggo-token", wantLimit: e.orgTokens["darlinggo"].limit},
+		{path: "/repos/acme/tool", want: "acme-token", wantLimit: e.orgTokens["acme"].limit},
+		{path: "/repos/ACME/tool/languages", want: "acme-token", wantLimit: e.orgTokens["acme"].limit},
+		{path: "/orgs/acme-labs/repos?page=1", want: "acme-token", wantLimit: e.orgTokens["acme"].limit},
+		{path: "/orgs/acme?page=1", want: "acme-token", wantLimit: e.orgTokens["acme"].limit},
+		{path: "/users/darlinggo/repos", want: "darlinggo-token", wantLimit: e.orgTokens["darlinggo"].limit},
+		{path: "/repos/octo/repo/readme", want: "default-token", wantLimit: e.rateLimit},
+		{path: "/installation/repositories", want: "default-token", wantLimit: e.rateLimit},
+		{path: "/rate_limit", want: "default-token", wantLimit: e.rateLimit},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			token, limit := e.credentialsFor(tc.path)
+			if token != tc.want {
+				t.Errorf("got token %q, want %q", token, tc.want)
+			}
+			if limit != tc.wantLimit {
+				t.Errorf("got the wrong rate limit for %s", tc.want)
+			}
+		})
+	}
+	if e.orgTokens["acme"].limit == e.orgTokens["darlinggo"].limit {
+		t.Error("different tokens share a rate limit")
+	}
+}
+
+// TestOrgTokens checks that each org's READMEs are fetched with its own
+// token, that each token's rate limit is tracked on its own, and that no
+// token is ever logged.
+func TestOrgTokens(t *testing.T) {
+	gh := newFakeGitHub(t)
+	repos := []string{"darlinggo/api", "acme/tool", "octo/repo"}
+	for _, repo := range repos {
+		gh.setReadme(repo, "# "+repo+"\n")
+	}
+	remaining := map[string]int{"darlinggo-token": 4000, "acme-token": 3000, "default-token": 2000}
+	var mu sync.Mutex
+	tokens := map[string]string{}
+	gh.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "token ")
+		mu.Lock()
+		tokens[r.URL.Path] = token
+		mu.Unlock()
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining[token]))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		gh.serve(w, r)
+	})
+	e := newTestEnv(t, gh.URL)
+	e.orgs = []string{"darlinggo", "acme", "octo"}
+	e.githubToken = "default-token"
+	e.orgTokens = newOrgTokens(map[string]string{"darlinggo": "darlinggo-token", "acme": "acme-token"})
+	logs := captureLog(t)
+	readmes, failed := e.syncAll(context.Background(), append(repos, "acme/missing"))
+	if len(readmes) != 3 || len(failed) != 1 {
+		t.Fatalf("got %d READMEs and %d failures, want 3 and 1", len(readmes), len(failed))
+	}
+	mu.Lock()
+	for path, want := range map[string]string{
+		"/repos/darlinggo/api/readme": "darlinggo-token",
+		"/repos/acme/tool/readme":     "acme-token",
+		"/repos/acme/missing/readme":  "acme-token",
+		"/repos/octo/repo/readme":     "default-token",
+	} {
+		if got := tokens[path]; got != want {
+			t.Errorf("%s: got token %q, want %q", path, got, want)
+		}
+	}
+	mu.Unlock()
+	for token, limit := range map[string]*rateLimit{
+		"darlinggo-token": e.orgTokens["darlinggo"].limit,
+		"acme-token":      e.orgTokens["acme"].limit,
+		"default-token":   e.rateLimit,
+	} {
+		limit.Lock()
+		got := limit.remaining
+		limit.Unlock()
+		if got != remaining[token] {
+			t.Errorf("%s: got %d requests remaining, want %d", token, got, remaining[token])
+		}
+	}
+	e.rateLimit.log()
+	w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	statusW := httptest.NewRecorder()
+	e.status(statusW, httptest.NewRequest("GET", "/status", nil))
+	for _, token := range []string{"darlinggo-token", "acme-token", "default-token"} {
+		if strings.Contains(logs.String(), token) {
+			t.Errorf("logs contain %s:\n%s", token, logs)
+		}
+		if strings.Contains(w.Body.String(), token) {
+			t.Errorf("response contains %s:\n%s", token, w.Body.String())
+		}
+		if strings.Contains(statusW.Body.String(), token) {
+			t.Errorf("status contains %s:\n%s", token, statusW.Body.String())
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// repoNamePattern matches the names that are safe to use in a path. GitHub
+// itself allows no others, so anything else is a crafted payload.
+var repoNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func validRepoName(name string) bool {
+	return repoNamePattern.MatchString(name) && name != "." && name != ".."
+}
+
+// validRepoRef reports whether ref is a safe repo name, optionally
+// qualified by a safe owner, as in owner/repo.
+func validRepoRef(ref string) bool {
+	owner, name := "", ref
+	if i := strings.Index(ref, "/"); i >= 0 {
+		owner, name = ref[:i], ref[i+1:]
+		if !validRepoName(owner) {
+			return false
+		}
+	}
+	return validRepoName(name)
+}
+
+func requireString(fields map[string]json.RawMessage, key string) error {
+	raw, ok := fields[key]
+	if !ok {
+		return errors.New(key + " is required")
+	}
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return errors.New(key + " must be a string")
+	}
+	if s == "" {
+		return errors.New(key + " must not be empty")
+	}
+	return nil
+}
+
+// validateRequest checks body has the shape event needs before anything
+// acts on it, so malformed payloads get a precise error.
+func validateRequest(event string, body []byte) error {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil || fields == nil {
+		return errors.New("body must be a JSON object")
+	}
+	switch event {
+	case "sync-all":
+		raw, ok := fields["repos"]
+		if !ok {
+			return errors.New("repos is required")
+		}
+		var repos []json.RawMessage
+		if json.Unmarshal(raw, &repos) != nil || repos == nil {
+			return errors.New("repos must be an array of strings")
+		}
+		if len(repos) == 0 {
+			return errors.New("repos must not be empty")
+		}
+		for i, r := range repos {
+			var repo string
+			if json.Unmarshal(r, &repo) != nil || repo == "" {
+				return errors.New("repos[" + strconv.Itoa(i) + "] must be a non-empty string")
+			}
+			if !validRepoRef(repo) {
+				return errors.New("repos[" + strconv.Itoa(i) + "] must be a repo name, optionally as owner/repo")
+			}
+		}
+	case "push":
+		if err := requireString(fields, "ref"); err != nil {
+			return err
+		}
+		raw, ok := fields["repository"]
+		if !ok {
+			return errors.New("repository is required")
+		}
+		var repository map[string]json.RawMessage
+		if json.Unmarshal(raw, &repository) != nil || repository == nil {
+			return errors.New("repository must be an object")
+		}
+		if err := requireString(repository, "name"); err != nil {
+			return errors.New("repository." + err.Error())
+		}
+		var name string
+		json.Unmarshal(repository["name"], &name)
+		if !validRepoName(name) {
+			return errors.New("repository.name must be a valid repo name")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		event string
+		body  string
+		want  string
+	}{
+		{name: "sync-all", event: "sync-all", body: `{"repos": ["api", "darlinggo/hash"]}`},
+		{name: "not JSON", event: "sync-all", body: `repos=api`, want: "body must be a JSON object"},
+		{name: "not an object", event: "sync-all", body: `["api"]`, want: "body must be a JSON object"},
+		{name: "null", event: "sync-all", body: `null`, want: "body must be a JSON object"},
+		{name: "no repos", event: "sync-all", body: `{}`, want: "repos is required"},
+		{name: "repos a string", event: "sync-all", body: `{"repos": "api"}`, want: "repos must be an array of strings"},
+		{name: "repos null", event: "sync-all", body: `{"repos": null}`, want: "repos must be an array of strings"},
+		{name: "repos empty", event: "sync-all", body: `{"repos": []}`, want: "repos must not be empty"},
+		{name: "repo a number", event: "sync-all", body: `{"repos": ["api", 1]}`, want: "repos[1] must be a non-empty string"},
+		{name: "repo empty", event: "sync-all", body: `{"repos": [""]}`, want: "repos[0] must be a non-empty string"},
+		{name: "repo a path", event: "sync-all", body: `{"repos": ["../etc"]}`, want: "repos[0] must be a repo name, optionally as owner/repo"},
+		{name: "push", event: "push", body: pushBody("api", "refs/heads/master")},
+		{name: "push without a ref", event: "push", body: `{"repository": {"name": "api"}}`, want: "ref is required"},
+		{name: "push with a numeric ref", event: "push", body: `{"ref": 1, "repository": {"name": "api"}}`, want: "ref must be a string"},
+		{name: "push with an empty ref", event: "push", body: `{"ref": "", "repository": {"name": "api"}}`, want: "ref must not be empty"},
+		{name: "push without a repository", event: "push", body: `{"ref": "refs/heads/master"}`, want: "repository is required"},
+		{name: "push with a string repository", event: "push", body: `{"ref": "refs/heads/master", "repository": "api"}`, want: "repository must be an object"},
+		{name: "push without a repo name", event: "push", body: `{"ref": "refs/heads/master", "repository": {}}`, want: "repository.name is required"},
+		{name: "push with a path for a name", event: "push", body: `{"ref": "refs/heads/master", "repository": {"name": ".."}}`, want: "repository.name must be a valid repo name"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateRequest(tc.event, []byte(tc.body))
+			if tc.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.want {
+				t.Fatalf("got error %v, want %q", err, tc.want)
+			}
+			w := serve(newTestEnv(t, "http://github.invalid"), newDelivery(tc.event, tc.body))
+			if w.Code != http.StatusBadRequest || w.Body.String() != tc.want {
+				t.Errorf("got status %d with %q, want %d with %q", w.Code, w.Body.String(), http.StatusBadRequest, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidRepoRef(t *testing.T) {
+	for _, tc := range []struct {
+		ref  string
+		want bool
+	}{
+		{ref: "api", want: true},
+		{ref: "go-1.x_tools", want: true},
+		{ref: "darlinggo/api", want: true},
+		{ref: ".github", want: true},
+		{ref: ""},
+		{ref: "."},
+		{ref: ".."},
+		{ref: "../api"},
+		{ref: "darlinggo/.."},
+		{ref: "../../etc/passwd"},
+		{ref: "darlinggo/api/extra"},
+		{ref: `..\api`},
+		{ref: "/api"},
+		{ref: "api\x00"},
+		{ref: "api name"},
+	} {
+		t.Run(tc.ref, func(t *testing.T) {
+			if got := validRepoRef(tc.ref); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestTraversalNames sends deliveries naming repos with paths, and checks
+// they're rejected without anything being written.
+func TestTraversalNames(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		event        string
+		body         string
+		installation bool
+	}{
+		{name: "push", event: "push", body: pushBody("../../escaped", "refs/heads/master")},
+		{name: "push of a parent dir", event: "push", body: pushBody("..", "refs/heads/master")},
+		{name: "sync-all", event: "sync-all", body: syncAllBody("api", "../../escaped")},
+		{name: "sync-all with an owner", event: "sync-all", body: syncAllBody("darlinggo/../../escaped")},
+		{
+			name:         "installation",
+			event:        "installation_repositories",
+			body:         `{"action": "added", "repositories_added": [{"name": "../../escaped", "full_name": "darlinggo/../../escaped"}]}`,
+			installation: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			gh.setReadme("darlinggo/../../escaped", "# escaped\n")
+			e := newTestEnv(t, gh.URL)
+			e.installationEvents, e.installationSync = tc.installation, tc.installation
+			if w := serve(e, newDelivery(tc.event, tc.body)); w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			// Nothing at all should have been written, in dir or out of it.
+			root := filepath.Dir(e.hugoSource)
+			filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+				if err == nil && strings.HasSuffix(path, ".md") {
+					t.Errorf("wrote %s", path)
+				}
+				return nil
+			})
+			if runs := hugoRuns(t, e.hugoCmd); len(runs) != 0 {
+				t.Errorf("hugo ran %d times", len(runs))
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// verifier checks that a webhook delivery really came from the forge it
+// claims to, typically by checking a signature over the body.
+type verifier interface {
+	verify(r *http.Request, body []byte) (bool, error)
+}
+
+func verifyWebhook(newHash func() hash.Hash, mac, body, secret []byte) (bool, error) {
+	h := hmac.New(newHash, secret)
+	_, err := h.Write(body)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal(mac, h.Sum(nil)), nil
+}
+
+func sign(newHash func() hash.Hash, body, secret []byte) string {
+	h := hmac.New(newHash, secret)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// signDelivery signs req, a delivery made from within readmesync, the way
+// GitHub would, so it passes through the usual checks.
+func signDelivery(req *http.Request, body, secret []byte) {
+	req.Header.Set("X-Hub-Signature-256", "sha256="+sign(sha256.New, body, secret))
+	req.Header.Set("X-Hub-Signature", "sha1="+sign(sha1.New, body, secret))
+}
+
+// hmacVerifier checks a hex HMAC of the body, carried in header after
+// prefix.
+type hmacVerifier struct {
+	header string
+	prefix string
+	hash   func() hash.Hash
+	secret []byte
+}
+
+// mac returns the decoded signature from r, if it has one in the right
+// format.
+func (v hmacVerifier) mac(r *http.Request) ([]byte, bool) {
+	header := r.Header.Get(v.header)
+	if len(header) <= len(v.prefix) || !strings.HasPrefix(header, v.prefix) {
+		return nil, false
+	}
+	mac, err := hex.DecodeString(header[len(v.prefix):])
+	if err != nil {
+		return nil, false
+	}
+	return mac, true
+}
+
+// checkLength returns an error if r's signature isn't hex of the right
+// length for v's hash, like a SHA-1 signature sent in the SHA-256 header.
+// A missing signature is left to fail verification as usual.
+func (v hmacVerifier) checkLength(r *http.Request) error {
+	header := r.Header.Get(v.header)
+	if header == "" {
+		return nil
+	}
+	mac, err := hex.DecodeString(strings.TrimPrefix(header, v.prefix))
+	if err != nil {
+		return errors.New(v.header + " must be a hex signature")
+	}
+	if size := v.hash().Size(); len(mac) != size {
+		return errors.New(v.header + " must be a " + strconv.Itoa(size*8) + "-bit signature, got " + strconv.Itoa(len(mac)*8) + " bits")
+	}
+	return nil
+}
+
+func (v hmacVerifier) verify(r *http.Request, body []byte) (bool, error) {
+	mac, ok := v.mac(r)
+	if !ok {
+		return false, nil
+	}
+	return verifyWebhook(v.hash, mac, body, v.secret)
+}
+
+// streamVerifier is a verifier that can hash the body as it's read,
+// instead of going over it again once it's been buffered.
+type streamVerifier interface {
+	verifier
+	// stream returns a writer to copy r's body to as it's read, and a
+	// func that reports, once it has been, whether the signature matched.
+	stream(r *http.Request) (io.Writer, func() bool)
+}
+
+func (v hmacVerifier) stream(r *http.Request) (io.Writer, func() bool) {
+	h := hmac.New(v.hash, v.secret)
+	return h, func() bool {
+		mac, ok := v.mac(r)
+		return ok && hmac.Equal(mac, h.Sum(nil))
+	}
+}
+
+// githubVerifier checks GitHub's signatures. The SHA-256 signature in
+// X-Hub-Signature-256 is preferred, falling back to the SHA-1 one in
+// X-Hub-Signature when it's missing. With REQUIRE_BOTH_SIGNATURES set,
+// both must be present and valid.
+type githubVerifier struct {
+	sha256      hmacVerifier
+	sha1        hmacVerifier
+	requireBoth bool
+}
+
+func (v githubVerifier) verifiers(r *http.Request) []hmacVerifier {
+	if v.requireBoth {
+		return []hmacVerifier{v.sha256, v.sha1}
+	}
+	if r.Header.Get(v.sha256.header) != "" {
+		return []hmacVerifier{v.sha256}
+	}
+	return []hmacVerifier{v.sha1}
+}
+
+func (v githubVerifier) verify(r *http.Request, body []byte) (bool, error) {
+	for _, hv := range v.verifiers(r) {
+		ok, err := hv.verify(r, body)
+		if !ok || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (v githubVerifier) stream(r *http.Request) (io.Writer, func() bool) {
+	var writers []io.Writer
+	var checks []func() bool
+	for _, hv := range v.verifiers(r) {
+		w, check := hv.stream(r)
+		writers = append(writers, w)
+		checks = append(checks, check)
+	}
+	return io.MultiWriter(writers...), func() bool {
+		for _, check := range checks {
+			if !check() {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func (v githubVerifier) checkLength(r *http.Request) error {
+	for _, hv := range v.verifiers(r) {
+		if err := hv.checkLength(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lengthChecker is a verifier that can check a signature is the right
+// length for its algorithm before the body has even been read.
+type lengthChecker interface {
+	checkLength(r *http.Request) error
+}
+
+// tokenVerifier checks that header holds the shared secret itself.
+type tokenVerifier struct {
+	header string
+	secret []byte
+}
+
+func (v tokenVerifier) verify(r *http.Request, body []byte) (bool, error) {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(v.header)), v.secret) == 1, nil
+}
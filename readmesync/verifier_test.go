@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stubVerifier accepts or rejects every delivery, or fails with err.
+type stubVerifier struct {
+	ok  bool
+	err error
+}
+
+func (v stubVerifier) verify(r *http.Request, body []byte) (bool, error) {
+	return v.ok, v.err
+}
+
+func TestVerifiers(t *testing.T) {
+	const body = `{"ref": "refs/heads/master"}`
+	secret := []byte(testSecret)
+	sha256Sig := "sha256=" + sign(sha256.New, []byte(body), secret)
+	sha1Sig := "sha1=" + sign(sha1.New, []byte(body), secret)
+	newGitHubVerifier := func(requireBoth bool) verifier { return github{requireBoth: requireBoth}.verifier(secret) }
+	for _, tc := range []struct {
+		name     string
+		verifier verifier
+		headers  map[string]string
+		want     bool
+		wantErr  bool
+	}{
+		{name: "stub accepting", verifier: stubVerifier{ok: true}, want: true},
+		{name: "stub rejecting", verifier: stubVerifier{}},
+		{name: "stub failing", verifier: stubVerifier{err: errors.New("keys unavailable")}, wantErr: true},
+		{name: "SHA-256", verifier: newGitHubVerifier(false), headers: map[string]string{"X-Hub-Signature-256": sha256Sig}, want: true},
+		{name: "SHA-1 fallback", verifier: newGitHubVerifier(false), headers: map[string]string{"X-Hub-Signature": sha1Sig}, want: true},
+		{name: "SHA-256 preferred", verifier: newGitHubVerifier(false), headers: map[string]string{"X-Hub-Signature-256": "sha256=" + sign(sha256.New, []byte(body), []byte("wrong")), "X-Hub-Signature": sha1Sig}},
+		{name: "unsigned", verifier: newGitHubVerifier(false)},
+		{name: "both required", verifier: newGitHubVerifier(true), headers: map[string]string{"X-Hub-Signature-256": sha256Sig, "X-Hub-Signature": sha1Sig}, want: true},
+		{name: "both required, one sent", verifier: newGitHubVerifier(true), headers: map[string]string{"X-Hub-Signature-256": sha256Sig}},
+		{name: "both required, SHA-1 invalid", verifier: newGitHubVerifier(true), headers: map[string]string{"X-Hub-Signature-256": sha256Sig, "X-Hub-Signature": "sha1=" + sign(sha1.New, []byte(body), []byte("wrong"))}},
+		{name: "both required, SHA-256 invalid", verifier: newGitHubVerifier(true), headers: map[string]string{"X-Hub-Signature-256": "sha256=" + sign(sha256.New, []byte(body), []byte("wrong")), "X-Hub-Signature": sha1Sig}},
+		{name: "missing prefix", verifier: newGitHubVerifier(false), headers: map[string]string{"X-Hub-Signature-256": sign(sha256.New, []byte(body), secret)}},
+		{name: "not hex", verifier: newGitHubVerifier(false), headers: map[string]string{"X-Hub-Signature-256": "sha256=zz"}},
+		{name: "Gitea", verifier: gitea{}.verifier(secret), headers: map[string]string{"X-Gitea-Signature": sign(sha256.New, []byte(body), secret)}, want: true},
+		{name: "GitLab", verifier: gitlab{}.verifier(secret), headers: map[string]string{"X-Gitlab-Token": testSecret}, want: true},
+		{name: "GitLab with the wrong token", verifier: gitlab{}.verifier(secret), headers: map[string]string{"X-Gitlab-Token": "secre"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			ok, err := tc.verifier.verify(r, []byte(body))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want one: %v", err, tc.wantErr)
+			}
+			if ok != tc.want {
+				t.Errorf("got %v, want %v", ok, tc.want)
+			}
+			// Verifiers that can stream the body must agree.
+			sv, ok := tc.verifier.(streamVerifier)
+			if !ok {
+				return
+			}
+			w, check := sv.stream(r)
+			io.WriteString(w, body)
+			if got := check(); got != tc.want {
+				t.Errorf("streamed: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStreamVerify(t *testing.T) {
+	const body = `{"zen": "Keep it logically awesome."}`
+	for _, tc := range []struct {
+		name        string
+		sign        func(r *http.Request)
+		repoSecrets bool
+		want        int
+	}{
+		{
+			name: "valid signatures",
+			sign: func(r *http.Request) { signDelivery(r, []byte(body), []byte(testSecret)) },
+			want: http.StatusOK,
+		},
+		{
+			name: "valid SHA-1 signature alone",
+			sign: func(r *http.Request) {
+				r.Header.Set("X-Hub-Signature", "sha1="+sign(sha1.New, []byte(body), []byte(testSecret)))
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "wrong secret",
+			sign: func(r *http.Request) { signDelivery(r, []byte(body), []byte("wrong")) },
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "signature over another body",
+			sign: func(r *http.Request) { signDelivery(r, []byte(body+" "), []byte(testSecret)) },
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "unsigned",
+			sign: func(r *http.Request) {},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "bare prefix",
+			sign: func(r *http.Request) { r.Header.Set("X-Hub-Signature-256", "sha256=") },
+			want: http.StatusBadRequest,
+		},
+		{
+			name:        "per-repo secrets aren't streamed",
+			sign:        func(r *http.Request) { signDelivery(r, []byte(body), []byte(testSecret)) },
+			repoSecrets: true,
+			want:        http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t, "http://github.invalid")
+			e.streamVerify = true
+			if tc.repoSecrets {
+				e.repoSecrets = map[string]string{"darlinggo/other": "other"}
+			}
+			req := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+			req.Header.Set("X-Github-Event", "ping")
+			tc.sign(req)
+			if w := serve(e, req); w.Code != tc.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+		})
+	}
+}
+
+// BenchmarkVerify compares checking a large delivery's signature once it's
+// been buffered with checking it as the body is read.
+func BenchmarkVerify(b *testing.B) {
+	body := `{"zen": "` + strings.Repeat("a", 4<<20) + `"}`
+	for _, bc := range []struct {
+		name   string
+		stream bool
+	}{
+		{name: "buffered"},
+		{name: "streamed", stream: true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			e := newTestEnv(b, "http://github.invalid")
+			e.streamVerify = bc.stream
+			sig256 := "sha256=" + sign(sha256.New, []byte(body), []byte(testSecret))
+			sig1 := "sha1=" + sign(sha1.New, []byte(body), []byte(testSecret))
+			b.SetBytes(int64(len(body)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+				req.Header.Set("X-Github-Event", "ping")
+				req.Header.Set("X-Hub-Signature-256", sig256)
+				req.Header.Set("X-Hub-Signature", sig1)
+				w := httptest.NewRecorder()
+				e.ServeHTTP(w, req)
+				if w.Code != http.StatusOK {
+					b.Fatalf("got status %d: %s", w.Code, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestMalformedSignatureHeaders(t *testing.T) {
+	body := pushBody("api", "refs/heads/master")
+	sig := sign(sha1.New, []byte(body), []byte(testSecret))
+	for _, tc := range []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{name: "missing", want: http.StatusBadRequest},
+		{name: "shorter than the prefix", header: "sha", want: http.StatusBadRequest},
+		{name: "bare prefix", header: "sha1=", want: http.StatusBadRequest},
+		{name: "no prefix", header: sig, want: http.StatusBadRequest},
+		{name: "wrong prefix", header: "md5=" + sig, want: http.StatusBadRequest},
+		{name: "not hex", header: "sha1=" + strings.Repeat("z", len(sig)), want: http.StatusBadRequest},
+		{name: "odd length hex", header: "sha1=" + sig[1:], want: http.StatusBadRequest},
+		{name: "truncated", header: "sha1=" + sig[:len(sig)-2], want: http.StatusBadRequest},
+		{name: "oversized", header: "sha1=" + sig + strings.Repeat("00", 1<<10), want: http.StatusBadRequest},
+		{name: "uppercase hex", header: "sha1=" + strings.ToUpper(sig), want: http.StatusOK},
+		{name: "valid", header: "sha1=" + sig, want: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.setReadme("darlinggo/api", "# api\n")
+			e := newTestEnv(t, gh.URL)
+			for _, stream := range []bool{false, true} {
+				e.streamVerify = stream
+				req := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+				req.Header.Set("X-Github-Event", "push")
+				if tc.header != "" {
+					req.Header.Set("X-Hub-Signature", tc.header)
+				}
+				if w := serve(e, req); w.Code != tc.want {
+					t.Errorf("stream %v: got status %d, want %d: %s", stream, w.Code, tc.want, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRequireBothSignatures(t *testing.T) {
+	const body = `{"zen": "Keep it logically awesome."}`
+	sha256Sig := "sha256=" + sign(sha256.New, []byte(body), []byte(testSecret))
+	sha1Sig := "sha1=" + sign(sha1.New, []byte(body), []byte(testSecret))
+	for _, tc := range []struct {
+		name    string
+		strict  bool
+		headers map[string]string
+		want    int
+	}{
+		{name: "both valid", strict: true, headers: map[string]string{"X-Hub-Signature-256": sha256Sig, "X-Hub-Signature": sha1Sig}, want: http.StatusOK},
+		{name: "SHA-1 invalid", strict: true, headers: map[string]string{"X-Hub-Signature-256": sha256Sig, "X-Hub-Signature": "sha1=" + sign(sha1.New, []byte(body), []byte("wrong"))}, want: http.StatusBadRequest},
+		{name: "SHA-1 missing", strict: true, headers: map[string]string{"X-Hub-Signature-256": sha256Sig}, want: http.StatusBadRequest},
+		{name: "SHA-1 missing, not strict", headers: map[string]string{"X-Hub-Signature-256": sha256Sig}, want: http.StatusOK},
+		{name: "SHA-1 invalid, not strict", headers: map[string]string{"X-Hub-Signature-256": sha256Sig, "X-Hub-Signature": "sha1=" + sign(sha1.New, []byte(body), []byte("wrong"))}, want: http.StatusOK},
+	} {
+		for _, stream := range []bool{false, true} {
+			name := tc.name
+			if stream {
+				name += ", streamed"
+			}
+			t.Run(name, func(t *testing.T) {
+				e := newTestEnv(t, "http://github.invalid")
+				e.requireBothSigs, e.streamVerify = tc.strict, stream
+				req := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+				req.Header.Set("X-Github-Event", "ping")
+				for k, v := range tc.headers {
+					req.Header.Set(k, v)
+				}
+				if w := serve(e, req); w.Code != tc.want {
+					t.Errorf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		limit int64
+		size  int
+		want  int
+	}{
+		{name: "under the limit", limit: 4096, size: 1024, want: http.StatusOK},
+		{name: "at the limit", limit: 4096, size: 4096, want: http.StatusOK},
+		{name: "over the limit", limit: 4096, size: 4097, want: http.StatusRequestEntityTooLarge},
+		{name: "far over the limit", limit: 4096, size: 1 << 20, want: http.StatusRequestEntityTooLarge},
+		{name: "no limit", size: 1 << 20, want: http.StatusOK},
+	} {
+		for _, stream := range []bool{false, true} {
+			name := tc.name
+			if stream {
+				name += ", streamed"
+			}
+			t.Run(name, func(t *testing.T) {
+				e := newTestEnv(t, "http://github.invalid")
+				e.maxBodyBytes, e.streamVerify = tc.limit, stream
+				// A ping padded out to size, and validly signed.
+				body := `{"zen": "` + strings.Repeat("a", tc.size-len(`{"zen": ""}`)) + `"}`
+				if w := serve(e, newDelivery("ping", body)); w.Code != tc.want {
+					t.Errorf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestCheckSignatureLength(t *testing.T) {
+	const body = `{"zen": "Keep it logically awesome."}`
+	sha256Sig := sign(sha256.New, []byte(body), []byte(testSecret))
+	sha1Sig := sign(sha1.New, []byte(body), []byte(testSecret))
+	for _, tc := range []struct {
+		name     string
+		check    bool
+		strict   bool
+		headers  map[string]string
+		want     int
+		wantBody string
+	}{
+		{
+			name:     "SHA-1 signature in the SHA-256 header",
+			check:    true,
+			headers:  map[string]string{"X-Hub-Signature-256": "sha256=" + sha1Sig},
+			want:     http.StatusBadRequest,
+			wantBody: "X-Hub-Signature-256 must be a 256-bit signature, got 160 bits",
+		},
+		{
+			name:    "SHA-1 signature in the SHA-256 header, not checked",
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=" + sha1Sig},
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:     "SHA-256 signature in the SHA-1 header",
+			check:    true,
+			strict:   true,
+			headers:  map[string]string{"X-Hub-Signature-256": "sha256=" + sha256Sig, "X-Hub-Signature": "sha1=" + sha256Sig},
+			want:     http.StatusBadRequest,
+			wantBody: "X-Hub-Signature must be a 160-bit signature, got 256 bits",
+		},
+		{
+			name:     "not hex",
+			check:    true,
+			headers:  map[string]string{"X-Hub-Signature-256": "sha256=" + strings.Repeat("z", 64)},
+			want:     http.StatusBadRequest,
+			wantBody: "X-Hub-Signature-256 must be a hex signature",
+		},
+		{
+			name:    "missing",
+			check:   true,
+			headers: map[string]string{},
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "right length",
+			check:   true,
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=" + sha256Sig},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "right length, SHA-1",
+			check:   true,
+			headers: map[string]string{"X-Hub-Signature": "sha1=" + sha1Sig},
+			want:    http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newTestEnv(t, "http://github.invalid")
+			e.checkSigLength, e.requireBothSigs = tc.check, tc.strict
+			req := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+			req.Header.Set("X-Github-Event", "ping")
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			w := serve(e, req)
+			if w.Code != tc.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tc.wantBody) {
+				t.Errorf("got body %q, want it to contain %q", w.Body.String(), tc.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// verifyWrite re-reads f from disk and checks it holds want, rewriting it
+// once if it doesn't, to catch silent truncation and filesystem errors.
+func verifyWrite(f *os.File, want []byte) error {
+	for attempt := 0; ; attempt++ {
+		got, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(got, want) {
+			return nil
+		}
+		if attempt > 0 {
+			return errors.New(f.Name() + ": content on disk doesn't match what was written")
+		}
+		log.Println(f.Name() + ": content on disk doesn't match what was written, retrying")
+		err = f.Truncate(0)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteAt(want, 0)
+		if err != nil {
+			return err
+		}
+		err = f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+}
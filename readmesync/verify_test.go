@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyWrite(t *testing.T) {
+	want := "+++\ntitle = \"api\"\n+++\n\n# api\n\nLots of docs.\n"
+	for _, tc := range []struct {
+		name      string
+		onDisk    string
+		wantRetry bool
+	}{
+		{name: "complete write", onDisk: want},
+		{name: "short write", onDisk: want[:len(want)/2], wantRetry: true},
+		{name: "empty write", onDisk: "", wantRetry: true},
+		{name: "corrupted write", onDisk: strings.Replace(want, "docs", "d\x00cs", 1), wantRetry: true},
+		{name: "long write", onDisk: want + want, wantRetry: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := ioutil.TempFile(t.TempDir(), "page-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.WriteString(tc.onDisk); err != nil {
+				t.Fatal(err)
+			}
+			logs := captureLog(t)
+			if err := verifyWrite(f, []byte(want)); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("got %q on disk, want %q", got, want)
+			}
+			if retried := strings.Contains(logs.String(), "retrying"); retried != tc.wantRetry {
+				t.Errorf("retried: got %v, want %v", retried, tc.wantRetry)
+			}
+		})
+	}
+}
+
+func TestVerifyWriteUnreadable(t *testing.T) {
+	f, err := ioutil.TempFile(t.TempDir(), "page-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	os.Remove(f.Name())
+	if err := verifyWrite(f, []byte("# api\n")); err == nil {
+		t.Error("got no error for a page that's gone from disk")
+	}
+}
+
+func TestVerifiedPages(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.setReadme("darlinggo/api", "# api\n")
+	e := newTestEnv(t, gh.URL)
+	e.verifyWrites = true
+	if w := serve(e, newDelivery("push", pushBody("api", "refs/heads/master"))); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	if page := readPage(t, e, "api"); !strings.Contains(page, "# api\n") {
+		t.Errorf("got page\n%s", page)
+	}
+	if left, _ := filepath.Glob(filepath.Join(e.hugoSource, e.dir, ".api.md-*")); len(left) > 0 {
+		t.Errorf("left behind %v", left)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+type buildJob struct {
+	event string
+	repos []string
+	bytes int64
+	done  chan batchResult
+}
+
+// buildWorker runs builds one at a time in the background, so no two of
+// them write to the output dir at once.
+type buildWorker struct {
+	jobs chan buildJob
+	// pending counts the builds started with background that haven't
+	// finished yet.
+	pending sync.WaitGroup
+}
+
+func newBuildWorker(run func(event string, repos []string, bytes int64) (buildReport, error)) *buildWorker {
+	w := &buildWorker{jobs: make(chan buildJob)}
+	go func() {
+		for job := range w.jobs {
+			report, err := run(job.event, job.repos, job.bytes)
+			job.done <- batchResult{report: report, err: err}
+		}
+	}()
+	return w
+}
+
+// build queues a build and waits for it to finish.
+func (w *buildWorker) build(event string, repos []string, bytes int64) (buildReport, error) {
+	job := buildJob{event: event, repos: repos, bytes: bytes, done: make(chan batchResult, 1)}
+	w.jobs <- job
+	res := <-job.done
+	return res.report, res.err
+}
+
+// background runs build, for a delivery that's already been answered, in
+// a goroutine of its own, keeping track of it so shutdown can wait for it.
+func (w *buildWorker) background(build func() error) {
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		build()
+	}()
+}
+
+// wait blocks until every build started with background has finished,
+// or ctx is done. A nil buildWorker has nothing to wait for.
+func (w *buildWorker) wait(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	return waitGroup(ctx, &w.pending)
+}
+
+// waitGroup blocks until wg's count is zero, or ctx is done.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAsyncBuilds(t *testing.T) {
+	gh := newFakeGitHub(t)
+	for _, repo := range []string{"api", "hash"} {
+		gh.setReadme("darlinggo/"+repo, "# "+repo+"\n")
+	}
+	e := newTestEnv(t, gh.URL)
+	e.hugoCmd = fakeHugo(t, concurrentHugo)
+	e.parallelBuilds = true
+	e.repoConfigs = map[string]string{"api": "api.toml", "hash": "hash.toml"}
+	e.outputDirs = map[string]string{"api.toml": "public/api", "hash.toml": "public/hash"}
+	e.worker = newBuildWorker(e.buildAndReport)
+	var wg sync.WaitGroup
+	for _, repo := range []string{"api", "hash"} {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+			start := time.Now()
+			w := serve(e, newDelivery("push", pushBody(repo, "refs/heads/master")))
+			if w.Code != http.StatusAccepted {
+				t.Errorf("%s: got status %d, want %d: %s", repo, w.Code, http.StatusAccepted, w.Body.String())
+			}
+			// Builds take 0.3s, so waiting on even one is too long.
+			if took := time.Since(start); took > 200*time.Millisecond {
+				t.Errorf("%s: took %s to answer", repo, took)
+			}
+		}(repo)
+	}
+	wg.Wait()
+	for _, repo := range []string{"api", "hash"} {
+		readPage(t, e, repo)
+	}
+	if err := e.worker.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if runs := hugoRuns(t, e.hugoCmd); len(runs) != 2 {
+		t.Fatalf("hugo ran %d times, want 2", len(runs))
+	}
+	// Even builds of different sites run one at a time on the worker.
+	if got := maxConcurrency(t, e.hugoCmd); got != 1 {
+		t.Errorf("got %d builds at once, want 1", got)
+	}
+}
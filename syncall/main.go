@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"flag"
+	"hash"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -16,7 +19,25 @@ type request struct {
 	Repos []string `json:"repos"`
 }
 
+type summary struct {
+	// Queued is set instead of the rest when readmesync has QUEUE_DIR
+	// set, and the sync hasn't happened yet.
+	Queued    bool              `json:"queued"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Synced    []string          `json:"synced"`
+	Errors    map[string]string `json:"errors"`
+}
+
+func sign(newHash func() hash.Hash, body []byte, secret string) string {
+	h := hmac.New(newHash, []byte(secret))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func main() {
+	dryRun := flag.Bool("dry-run", false, "print the signed request instead of sending it")
+	flag.Parse()
 	secret := os.Getenv("GITHUB_SECRET")
 	endpoint := os.Getenv("HOOK_URL")
 	if secret == "" {
@@ -27,11 +48,11 @@ func main() {
 		log.Println("HOOK_URL must be set to the hook URL to call.")
 		os.Exit(1)
 	}
-	if len(os.Args) < 2 {
-		log.Println("Usage: syncall {repo} {repo} {repo}")
+	if flag.NArg() < 1 {
+		log.Println("Usage: syncall [-dry-run] {repo} {repo} {repo}")
 		os.Exit(1)
 	}
-	repos := os.Args[1:]
+	repos := flag.Args()
 	log.Println("Syncing repos:", repos)
 	b, err := json.Marshal(request{Repos: repos})
 	if err != nil {
@@ -39,18 +60,22 @@ func main() {
 	}
 	log.Println(string(b))
 	buf := bytes.NewBuffer(b)
-	h := hmac.New(sha1.New, []byte(secret))
-	_, err = h.Write(b)
-	if err != nil {
-		panic(err)
-	}
-	mac := hex.EncodeToString(h.Sum(nil))
 	req, err := http.NewRequest("POST", endpoint, buf)
 	if err != nil {
 		panic(err)
 	}
-	req.Header.Set("X-Hub-Signature", "sha1="+mac)
+	// Sign with both hashes, like GitHub does, so the request is accepted
+	// whichever readmesync checks.
+	req.Header.Set("X-Hub-Signature-256", "sha256="+sign(sha256.New, b, secret))
+	req.Header.Set("X-Hub-Signature", "sha1="+sign(sha1.New, b, secret))
 	req.Header.Set("X-Github-Event", "sync-all")
+	if *dryRun {
+		err = req.Write(os.Stdout)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		panic(err)
@@ -60,5 +85,26 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	log.Println(resp.Status+"\n", body)
+	var s summary
+	// With BUILD_ASYNC set, readmesync answers before building, with a
+	// 202.
+	if (resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted) || json.Unmarshal(body, &s) != nil {
+		log.Println(resp.Status+"\n", string(body))
+		os.Exit(1)
+	}
+	log.Println(resp.Status)
+	if s.Queued {
+		log.Println("queued")
+		return
+	}
+	for _, repo := range s.Synced {
+		log.Println("synced:", repo)
+	}
+	for repo, err := range s.Errors {
+		log.Println("failed:", repo+":", err)
+	}
+	log.Printf("%d synced, %d failed\n", s.Succeeded, s.Failed)
+	if s.Failed > 0 {
+		os.Exit(1)
+	}
 }
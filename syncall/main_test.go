@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testSecret = "secret"
+
+// TestMain runs syncall's main instead of the tests when SYNCALL_ARGS is
+// set, so runSyncall can run it as a separate process.
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv("SYNCALL_ARGS"); ok {
+		os.Args = append([]string{"syncall"}, strings.Fields(args)...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runSyncall runs syncall with args against hookURL, returning its
+// combined output and exit code.
+func runSyncall(t *testing.T, hookURL string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "SYNCALL_ARGS="+strings.Join(args, " "), "GITHUB_SECRET="+testSecret, "HOOK_URL="+hookURL)
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return string(out), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), 0
+}
+
+// fakeHook is a stub readmesync that records the deliveries it gets and
+// answers them with code and body.
+type fakeHook struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	deliveries []*http.Request
+	bodies     []string
+}
+
+func newFakeHook(t *testing.T, code int, contentType, body string) *fakeHook {
+	h := &fakeHook{}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		h.mu.Lock()
+		h.deliveries = append(h.deliveries, r)
+		h.bodies = append(h.bodies, string(b))
+		h.mu.Unlock()
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.WriteHeader(code)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(h.Close)
+	return h
+}
+
+// received returns the deliveries the hook has been sent, and their
+// bodies.
+func (h *fakeHook) received() ([]*http.Request, []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.deliveries, h.bodies
+}
+
+func TestDryRun(t *testing.T) {
+	hook := newFakeHook(t, http.StatusOK, "application/json", `{}`)
+	out, code := runSyncall(t, hook.URL, "-dry-run", "api", "hash")
+	if code != 0 {
+		t.Fatalf("exited %d:\n%s", code, out)
+	}
+	body, _ := json.Marshal(request{Repos: []string{"api", "hash"}})
+	for _, want := range []string{
+		"POST / HTTP/1.1",
+		"Host: " + strings.TrimPrefix(hook.URL, "http://"),
+		"X-Github-Event: sync-all",
+		"X-Hub-Signature-256: sha256=" + sign(sha256.New, body, testSecret),
+		"X-Hub-Signature: sha1=" + sign(sha1.New, body, testSecret),
+		string(body),
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, out)
+		}
+	}
+	if deliveries, _ := hook.received(); len(deliveries) != 0 {
+		t.Errorf("sent %d requests on a dry run", len(deliveries))
+	}
+}
+
+func TestSync(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		code     int
+		body     string
+		wantExit int
+		wantOut  []string
+	}{
+		{
+			name:    "synced",
+			code:    http.StatusOK,
+			body:    `{"succeeded": 2, "failed": 0, "synced": ["api", "hash"]}`,
+			wantOut: []string{"synced: api", "synced: hash", "2 synced, 0 failed"},
+		},
+		{
+			name:     "some failed",
+			code:     http.StatusOK,
+			body:     `{"succeeded": 1, "failed": 1, "synced": ["api"], "errors": {"hash": "no README"}}`,
+			wantExit: 1,
+			wantOut:  []string{"synced: api", "failed: hash: no README", "1 synced, 1 failed"},
+		},
+		{
+			name:    "accepted for an async build",
+			code:    http.StatusAccepted,
+			body:    `{"succeeded": 2, "failed": 0, "synced": ["api", "hash"]}`,
+			wantOut: []string{"synced: api", "synced: hash", "2 synced, 0 failed"},
+		},
+		{
+			name:    "queued",
+			code:    http.StatusAccepted,
+			body:    `{"queued":true}`,
+			wantOut: []string{"202 Accepted", "queued"},
+		},
+		{
+			name:     "rejected",
+			code:     http.StatusBadRequest,
+			body:     "bad signature",
+			wantExit: 1,
+			wantOut:  []string{"400 Bad Request", "bad signature"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hook := newFakeHook(t, tc.code, "application/json", tc.body)
+			out, code := runSyncall(t, hook.URL, "api", "hash")
+			if code != tc.wantExit {
+				t.Errorf("exited %d, want %d:\n%s", code, tc.wantExit, out)
+			}
+			for _, want := range tc.wantOut {
+				if !strings.Contains(out, want) {
+					t.Errorf("output doesn't contain %q:\n%s", want, out)
+				}
+			}
+			deliveries, bodies := hook.received()
+			if len(deliveries) != 1 {
+				t.Fatalf("sent %d requests, want 1", len(deliveries))
+			}
+			r, body := deliveries[0], bodies[0]
+			if got, want := r.Header.Get("X-Hub-Signature-256"), "sha256="+sign(sha256.New, []byte(body), testSecret); got != want {
+				t.Errorf("got signature %q, want %q", got, want)
+			}
+			if got, want := r.Header.Get("X-Hub-Signature"), "sha1="+sign(sha1.New, []byte(body), testSecret); got != want {
+				t.Errorf("got SHA-1 signature %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		newHash func() hash.Hash
+		body    string
+		secret  string
+		want    string
+	}{
+		{
+			// The example from GitHub's docs on validating deliveries.
+			name:    "SHA-256",
+			newHash: sha256.New,
+			body:    "Hello, World!",
+			secret:  "It's a Secret to Everybody",
+			want:    "757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+		},
+		{
+			name:    "SHA-256, short key",
+			newHash: sha256.New,
+			body:    "The quick brown fox jumps over the lazy dog",
+			secret:  "key",
+			want:    "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		},
+		{
+			name:    "SHA-1",
+			newHash: sha1.New,
+			body:    "The quick brown fox jumps over the lazy dog",
+			secret:  "key",
+			want:    "de7c9b85b8b78aa6bc8a7a36f70a90701c9db4d9",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sign(tc.newHash, []byte(tc.body), tc.secret); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}